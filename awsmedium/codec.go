@@ -9,7 +9,10 @@
 package awsmedium
 
 import (
+	"encoding/json"
+	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-cdk-go/awscdk/v2"
@@ -28,7 +31,9 @@ import (
 	// Note: required to import engine so that all deps used it are lifted to client.
 	//       app that uses only stack fails to build if image manipulation library is not imported.
 	//       e.g. github.com/anthonynsimon/bild
+	_ "github.com/fogfish/medium/internal/awslambda/approval"
 	_ "github.com/fogfish/medium/internal/awslambda/inbox"
+	_ "github.com/fogfish/medium/internal/awslambda/takedown"
 )
 
 type CodecProps struct {
@@ -61,11 +66,20 @@ type CodecProps struct {
 	//
 	// Lambda uses this value to proportionally allocate the amount of CPU
 	// power. For more information, see Resource Model in the AWS Lambda
-	// Developer Guide.
+	// Developer Guide. The codec derives its memory budget and the number of
+	// concurrent scalers from this value, media that does not fit is rejected.
 	// Default: 128.
 	//
 	MemorySize *float64
 
+	// The amount of local storage (/tmp), in MB, that is allocated to your
+	// Lambda function. The source of video is copied into the storage and
+	// renditions are transcoded next to it, video that does not fit is
+	// rejected.
+	// Default: 512.
+	//
+	EphemeralStorageSize *float64
+
 	// Deadline for running processing pipeline.
 	// The processing pipelines are terminated with force, the result is not predictable.
 	// Default: 60 seconds
@@ -92,6 +106,72 @@ type CodecProps struct {
 
 	// LogGroup to write logs
 	LogGroupName *string
+
+	// Moderation of media before its publication
+	// Default: None
+	//
+	Moderation *Moderation
+
+	// Address of clamd-compatible malware scanner, either tcp://host:port
+	// or unix:///path. The scanner has to be reachable from the lambda.
+	// Default: None
+	//
+	Scanner *string
+
+	// Lambda layer with ffmpeg binary at /opt/bin/ffmpeg, the binary
+	// transcodes video into renditions.
+	// Default: None
+	//
+	FFmpeg awslambda.ILayerVersion
+
+	// Lambda layer with poppler binary at /opt/bin/pdftoppm, the binary
+	// renders page of PDF documents.
+	// Default: None
+	//
+	Poppler awslambda.ILayerVersion
+
+	// Lambda layer with libjpeg-turbo binary at /opt/bin/djpeg, the binary
+	// decodes large JPEG at scale (1/2, 1/4, 1/8).
+	// Default: None
+	//
+	LibJpeg awslambda.ILayerVersion
+
+	// Lambda layer with libheif binary at /opt/bin/heif-dec, the binary
+	// decodes HEIC/HEIF and AVIF images. The formats are opt-in, they are
+	// not supported without the layer.
+	// Default: None
+	//
+	LibHeif awslambda.ILayerVersion
+
+	// Lambda layer with librsvg binary at /opt/bin/rsvg-convert, the binary
+	// rasterizes SVG images.
+	// Default: None
+	//
+	LibRsvg awslambda.ILayerVersion
+}
+
+// Moderation of media, the blocklist of content is always applied.
+type Moderation struct {
+	// Local rules of media, zero value of the rule disables it.
+	medium.Rules
+
+	// HTTP endpoint of remote classifier. The classifier receives media as
+	// image/jpeg and responds with {"verdict": "allow|deny|review", "labels": []}
+	Classifier *string
+
+	// Detection of near-duplicates of published media
+	Duplicates *Duplicates
+}
+
+// Duplicates detection using perceptual hash of media.
+type Duplicates struct {
+	// Hamming distance (0..64) between perceptual hashes of similar media.
+	// The distance up to 3 is detected reliably.
+	Distance int `json:"distance"`
+
+	// Verdict on duplicates, either "review" or "deny".
+	// Default: review
+	Verdict string `json:"verdict"`
 }
 
 func (props *CodecProps) assert() {
@@ -99,6 +179,28 @@ func (props *CodecProps) assert() {
 		panic("\n\nMedia processing profiles are not defined.")
 	}
 
+	for _, profile := range props.Profiles {
+		if err := profile.Validate(); err != nil {
+			panic(fmt.Sprintf("\n\nMedia processing profile %s is invalid: %s", profile.Prefix, err))
+		}
+	}
+
+	if props.Moderation != nil && props.Moderation.Duplicates != nil {
+		switch props.Moderation.Duplicates.Verdict {
+		case "", "review", "deny":
+		default:
+			panic(fmt.Sprintf("\n\nVerdict on duplicates %q is invalid, use review or deny.", props.Moderation.Duplicates.Verdict))
+		}
+	}
+
+	if props.MemorySize == nil {
+		props.MemorySize = jsii.Number(128.0)
+	}
+
+	if props.EphemeralStorageSize == nil {
+		props.EphemeralStorageSize = jsii.Number(512.0)
+	}
+
 	if props.Deadline == nil {
 		props.Deadline = awscdk.Duration_Seconds(jsii.Number(60.0))
 	}
@@ -117,9 +219,20 @@ type Codec struct {
 	namespace string
 	version   tagver.Version
 
-	logs  awslogs.LogGroup
-	dlq   awssqs.Queue
-	Inbox awss3.Bucket
+	logs       awslogs.LogGroup
+	dlq        awssqs.Queue
+	Inbox      awss3.Bucket
+	Index      awss3.Bucket
+	Blocklist  awss3.Bucket
+	Quarantine awss3.Bucket
+
+	// Takedown function removes published media, it is invoked with
+	// {"key": "...", "hash": "...", "reason": "..."}
+	Takedown awslambda.Function
+
+	// Approval function publishes or rejects quarantined media, it is invoked
+	// with {"key": "...", "action": "approve|reject", "reason": "..."}
+	Approval awslambda.Function
 }
 
 func NewCodec(app awscdk.App, id *string, props *CodecProps) *Codec {
@@ -134,9 +247,14 @@ func NewCodec(app awscdk.App, id *string, props *CodecProps) *Codec {
 	stack.createLogGroup(props)
 	stack.createDLQ(props)
 	stack.createInboxBucket(props)
+	stack.createIndexBucket(props)
+	stack.createBlocklistBucket(props)
+	stack.createQuarantineBucket(props)
 	for _, profile := range props.Profiles {
 		stack.createInboxCodec(props, profile)
 	}
+	stack.createTakedown(props)
+	stack.createApproval(props)
 
 	return stack
 }
@@ -192,6 +310,124 @@ func (stack *Codec) createInboxBucket(props *CodecProps) {
 	})
 }
 
+// private index of published media, it is not exposed with media
+func (stack *Codec) createIndexBucket(props *CodecProps) {
+	name := stack.resource("index")
+
+	policy := awscdk.RemovalPolicy_RETAIN
+	if tagver.IsTest(props.Version) {
+		policy = awscdk.RemovalPolicy_DESTROY
+	}
+
+	stack.Index = awss3.NewBucket(stack.Stack, jsii.String("Index"),
+		&awss3.BucketProps{
+			BucketName:    jsii.String(name),
+			RemovalPolicy: policy,
+		},
+	)
+}
+
+func (stack *Codec) createBlocklistBucket(props *CodecProps) {
+	name := stack.resource("blocklist")
+
+	policy := awscdk.RemovalPolicy_RETAIN
+	if tagver.IsTest(props.Version) {
+		policy = awscdk.RemovalPolicy_DESTROY
+	}
+
+	stack.Blocklist = awss3.NewBucket(stack.Stack, jsii.String("Blocklist"),
+		&awss3.BucketProps{
+			BucketName:    jsii.String(name),
+			RemovalPolicy: policy,
+		},
+	)
+}
+
+func (stack *Codec) createQuarantineBucket(props *CodecProps) {
+	name := stack.resource("quarantine")
+
+	policy := awscdk.RemovalPolicy_RETAIN
+	if tagver.IsTest(props.Version) {
+		policy = awscdk.RemovalPolicy_DESTROY
+	}
+
+	stack.Quarantine = awss3.NewBucket(stack.Stack, jsii.String("Quarantine"),
+		&awss3.BucketProps{
+			BucketName:    jsii.String(name),
+			RemovalPolicy: policy,
+		},
+	)
+}
+
+func (stack *Codec) createTakedown(props *CodecProps) {
+	name := stack.resource("takedown")
+
+	envs := map[string]*string{
+		"CONFIG_STORE_MEDIA":     props.Media.BucketName(),
+		"CONFIG_STORE_INDEX":     stack.Index.BucketName(),
+		"CONFIG_STORE_BLOCKLIST": stack.Blocklist.BucketName(),
+	}
+	if props.EventBus != nil {
+		envs["CONFIG_SINK_EVENTBUS"] = props.EventBus.EventBusName()
+	}
+
+	stack.Takedown = scud.NewFunctionGo(stack.Stack, jsii.String("Takedown"),
+		&scud.FunctionGoProps{
+			SourceCodeModule: "github.com/fogfish/medium",
+			SourceCodeLambda: "cmd/lambda/takedown",
+			FunctionProps: &awslambda.FunctionProps{
+				FunctionName: jsii.String(name),
+				Timeout:      props.Deadline,
+				LogGroup:     stack.logs,
+				Environment:  &envs,
+			},
+		},
+	)
+
+	props.Media.GrantReadWrite(stack.Takedown, nil)
+	props.Media.GrantDelete(stack.Takedown, nil)
+	stack.Index.GrantReadWrite(stack.Takedown, nil)
+	stack.Index.GrantDelete(stack.Takedown, nil)
+	stack.Blocklist.GrantReadWrite(stack.Takedown, nil)
+	if props.EventBus != nil {
+		props.EventBus.GrantPutEventsTo(stack.Takedown, nil)
+	}
+}
+
+func (stack *Codec) createApproval(props *CodecProps) {
+	name := stack.resource("approval")
+
+	envs := map[string]*string{
+		"CONFIG_STORE_MEDIA":      props.Media.BucketName(),
+		"CONFIG_STORE_INDEX":      stack.Index.BucketName(),
+		"CONFIG_STORE_QUARANTINE": stack.Quarantine.BucketName(),
+	}
+	if props.EventBus != nil {
+		envs["CONFIG_SINK_EVENTBUS"] = props.EventBus.EventBusName()
+	}
+
+	stack.Approval = scud.NewFunctionGo(stack.Stack, jsii.String("Approval"),
+		&scud.FunctionGoProps{
+			SourceCodeModule: "github.com/fogfish/medium",
+			SourceCodeLambda: "cmd/lambda/approval",
+			FunctionProps: &awslambda.FunctionProps{
+				FunctionName: jsii.String(name),
+				Timeout:      props.Deadline,
+				LogGroup:     stack.logs,
+				Environment:  &envs,
+			},
+		},
+	)
+
+	props.Media.GrantReadWrite(stack.Approval, nil)
+	stack.Index.GrantReadWrite(stack.Approval, nil)
+	stack.Quarantine.GrantReadWrite(stack.Approval, nil)
+	stack.Quarantine.GrantDelete(stack.Approval, nil)
+	if props.EventBus != nil {
+		props.EventBus.GrantPutEventsTo(stack.Approval, nil)
+	}
+}
+
 func (stack *Codec) createInboxCodec(props *CodecProps, profile medium.Profile) {
 	sfx := filepath.Base(profile.Prefix)
 	if profile.Suffix != "" {
@@ -201,13 +437,66 @@ func (stack *Codec) createInboxCodec(props *CodecProps, profile medium.Profile)
 	name := stack.resource("inbox-codec-" + sfx)
 
 	envs := map[string]*string{
-		"CONFIG_STORE_INBOX":   stack.Inbox.BucketName(),
-		"CONFIG_STORE_MEDIA":   props.Media.BucketName(),
-		"CONFIG_CODEC_PROFILE": jsii.String(profile.String()),
+		"CONFIG_STORE_INBOX":      stack.Inbox.BucketName(),
+		"CONFIG_STORE_MEDIA":      props.Media.BucketName(),
+		"CONFIG_CODEC_PROFILE":    jsii.String(profile.String()),
+		"CONFIG_CODEC_MEMORY":     jsii.String(strconv.Itoa(int(*props.MemorySize))),
+		"CONFIG_CODEC_STORAGE":    jsii.String(strconv.Itoa(int(*props.EphemeralStorageSize))),
+		"CONFIG_STORE_INDEX":      stack.Index.BucketName(),
+		"CONFIG_STORE_BLOCKLIST":  stack.Blocklist.BucketName(),
+		"CONFIG_STORE_QUARANTINE": stack.Quarantine.BucketName(),
 	}
 	if props.EventBus != nil {
 		envs["CONFIG_SINK_EVENTBUS"] = props.EventBus.EventBusName()
 	}
+	if props.Scanner != nil {
+		envs["CONFIG_CODEC_SCANNER"] = props.Scanner
+	}
+	var layers *[]awslambda.ILayerVersion
+	if props.FFmpeg != nil {
+		envs["CONFIG_CODEC_TRANSCODER"] = jsii.String("/opt/bin/ffmpeg")
+		layers = appendLayer(layers, props.FFmpeg)
+	}
+	if props.Poppler != nil {
+		envs["CONFIG_CODEC_RASTERIZER"] = jsii.String("/opt/bin/pdftoppm")
+		layers = appendLayer(layers, props.Poppler)
+	}
+	if props.LibJpeg != nil {
+		envs["CONFIG_CODEC_DECODER"] = jsii.String("/opt/bin/djpeg")
+		layers = appendLayer(layers, props.LibJpeg)
+	}
+	if props.LibHeif != nil {
+		envs["CONFIG_CODEC_HEIF"] = jsii.String("/opt/bin/heif-dec")
+		layers = appendLayer(layers, props.LibHeif)
+	}
+	if props.LibRsvg != nil {
+		envs["CONFIG_CODEC_SVG"] = jsii.String("/opt/bin/rsvg-convert")
+		layers = appendLayer(layers, props.LibRsvg)
+	}
+	if props.Moderation != nil {
+		rules, err := json.Marshal(props.Moderation.Rules)
+		if err != nil {
+			panic(err)
+		}
+		envs["CONFIG_CODEC_MODERATION"] = jsii.String(string(rules))
+
+		if props.Moderation.Classifier != nil {
+			envs["CONFIG_CODEC_CLASSIFIER"] = props.Moderation.Classifier
+		}
+
+		if props.Moderation.Duplicates != nil {
+			duplicates := *props.Moderation.Duplicates
+			if duplicates.Verdict == "" {
+				duplicates.Verdict = "review"
+			}
+
+			spec, err := json.Marshal(duplicates)
+			if err != nil {
+				panic(err)
+			}
+			envs["CONFIG_CODEC_DUPLICATES"] = jsii.String(string(spec))
+		}
+	}
 
 	var filter awss3.NotificationKeyFilter
 	if profile.Suffix != "" {
@@ -223,6 +512,7 @@ func (stack *Codec) createInboxCodec(props *CodecProps, profile medium.Profile)
 			EventSource: &awslambdaeventsources.S3EventSourceProps{
 				Events: &[]awss3.EventType{
 					awss3.EventType_OBJECT_CREATED,
+					awss3.EventType_OBJECT_REMOVED,
 				},
 				Filters: &[]*awss3.NotificationKeyFilter{&filter},
 			},
@@ -235,15 +525,32 @@ func (stack *Codec) createInboxCodec(props *CodecProps, profile medium.Profile)
 					DeadLetterQueueEnabled: jsii.Bool(true),
 					DeadLetterQueue:        stack.dlq,
 					MemorySize:             props.MemorySize,
+					EphemeralStorageSize:   awscdk.Size_Mebibytes(props.EphemeralStorageSize),
 					LogGroup:               stack.logs,
 					Environment:            &envs,
+					Layers:                 layers,
 				},
 			},
 		},
 	)
 	stack.Inbox.GrantRead(sink.Handler, nil)
-	props.Media.GrantWrite(sink.Handler, nil, nil)
+	stack.Index.GrantReadWrite(sink.Handler, nil)
+	stack.Index.GrantDelete(sink.Handler, nil)
+	stack.Blocklist.GrantRead(sink.Handler, nil)
+	stack.Quarantine.GrantReadWrite(sink.Handler, nil)
+	stack.Quarantine.GrantDelete(sink.Handler, nil)
+	props.Media.GrantReadWrite(sink.Handler, nil)
+	props.Media.GrantDelete(sink.Handler, nil)
 	if props.EventBus != nil {
 		props.EventBus.GrantPutEventsTo(sink.Handler, nil)
 	}
 }
+
+func appendLayer(layers *[]awslambda.ILayerVersion, layer awslambda.ILayerVersion) *[]awslambda.ILayerVersion {
+	if layers == nil {
+		return &[]awslambda.ILayerVersion{layer}
+	}
+
+	seq := append(*layers, layer)
+	return &seq
+}
@@ -19,6 +19,9 @@ require (
 	github.com/fogfish/swarm/broker/eventbridge v0.24.0
 	github.com/fogfish/swarm/broker/events3 v0.24.0
 	github.com/fogfish/tagver v0.2.0
+	github.com/hajimehoshi/go-mp3 v0.3.4
+	github.com/jfreymuth/oggvorbis v1.0.5
+	golang.org/x/image v0.25.0
 	golang.org/x/sync v0.16.0
 )
 
@@ -56,6 +59,7 @@ require (
 	github.com/fogfish/guid/v2 v2.1.0 // indirect
 	github.com/fogfish/opts v0.0.5 // indirect
 	github.com/google/go-cmp v0.7.0 // indirect
+	github.com/jfreymuth/vorbis v1.0.2 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/yuin/goldmark v1.7.12 // indirect
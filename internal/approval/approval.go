@@ -0,0 +1,182 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+// Package approval implements manual review of quarantined media. Approved
+// media is promoted from quarantine to media storage, rejected is removed.
+package approval
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fogfish/faults"
+	"github.com/fogfish/medium/internal/codec"
+)
+
+const (
+	errInvalidRequest = faults.Type("invalid approval request")
+	errNotFound       = faults.Safe1[string]("media is not quarantined (%s)")
+	errApprovalIO     = faults.Type("approval I/O error")
+)
+
+const (
+	ACTION_APPROVE = "approve"
+	ACTION_REJECT  = "reject"
+)
+
+// Approval request
+type Request struct {
+	Key    string `json:"key"`              // S3 key of media at inbox
+	Action string `json:"action"`           // approve or reject
+	Reason string `json:"reason,omitempty"` // reason of the decision
+}
+
+// Event of approved media
+type MediaApproved struct {
+	Request
+	Hash string   `json:"hash"`
+	Keys []string `json:"keys"` // S3 keys of published variants
+}
+
+type Approval struct {
+	quarantine *codec.Writer
+	media      *codec.Writer
+	index      *codec.Index
+	emitter    codec.Emitter[MediaApproved]
+}
+
+func New(quarantine codec.WriterFS, media codec.WriterFS, index *codec.Index, emitter codec.Emitter[MediaApproved]) *Approval {
+	return &Approval{
+		quarantine: codec.NewWriter(quarantine),
+		media:      codec.NewWriter(media),
+		index:      index,
+		emitter:    emitter,
+	}
+}
+
+// Apply the approval request, it returns the review of media
+func (a *Approval) Apply(ctx context.Context, req Request) (*codec.Review, error) {
+	if req.Key == "" {
+		return nil, errInvalidRequest.With(nil)
+	}
+
+	switch req.Action {
+	case ACTION_APPROVE:
+		return a.Approve(ctx, req)
+	case ACTION_REJECT:
+		return a.Reject(ctx, req)
+	default:
+		return nil, errInvalidRequest.With(nil)
+	}
+}
+
+// Approve promotes quarantined media to media storage
+func (a *Approval) Approve(ctx context.Context, req Request) (*codec.Review, error) {
+	review, err := a.pending(req.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("media is approved",
+		slog.String("path", review.Source),
+		slog.String("reason", req.Reason),
+	)
+
+	if err := a.media.Promote(ctx, a.quarantine, &review.Manifest); err != nil {
+		return nil, errApprovalIO.With(err)
+	}
+
+	if err := a.index.Put(ctx, review.Hash, review.Source); err != nil {
+		return nil, errApprovalIO.With(err)
+	}
+
+	if review.PHash != "" {
+		similar := codec.Similar{PHash: review.PHash, Source: review.Source}
+		if err := a.index.PutSimilar(ctx, similar); err != nil {
+			return nil, errApprovalIO.With(err)
+		}
+	}
+
+	if err := a.discard(ctx, review, codec.REVIEW_APPROVED, req.Reason); err != nil {
+		return nil, err
+	}
+
+	if a.emitter != nil {
+		event := MediaApproved{Request: req, Hash: review.Hash, Keys: make([]string, len(review.Variants))}
+		for i, path := range review.Variants {
+			event.Keys[i] = strings.TrimPrefix(path, "/")
+		}
+
+		if err := a.emitter.Enq(ctx, event); err != nil {
+			return nil, errApprovalIO.With(err)
+		}
+	}
+
+	return review, nil
+}
+
+// Reject removes quarantined media
+func (a *Approval) Reject(ctx context.Context, req Request) (*codec.Review, error) {
+	review, err := a.pending(req.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("media is rejected",
+		slog.String("path", review.Source),
+		slog.String("reason", req.Reason),
+	)
+
+	if err := a.discard(ctx, review, codec.REVIEW_REJECTED, req.Reason); err != nil {
+		return nil, err
+	}
+
+	return review, nil
+}
+
+// review of pending media
+func (a *Approval) pending(key string) (*codec.Review, error) {
+	review, err := a.quarantine.Review(filepath.Join("/", key))
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, errNotFound.With(nil, key)
+	default:
+		return nil, errApprovalIO.With(err)
+	}
+
+	if review.State != codec.REVIEW_PENDING {
+		return nil, errNotFound.With(nil, key)
+	}
+
+	return review, nil
+}
+
+// discards quarantined variants, the review is kept with final state
+func (a *Approval) discard(ctx context.Context, review *codec.Review, state string, reason string) error {
+	for _, path := range review.Objects() {
+		if err := a.quarantine.Remove(ctx, path); err != nil {
+			return errApprovalIO.With(err)
+		}
+	}
+
+	review.State = state
+	review.Reason = reason
+	review.Updated = time.Now().UTC()
+
+	if err := a.quarantine.PutReview(ctx, review); err != nil {
+		return errApprovalIO.With(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package approval_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+	"github.com/fogfish/medium/internal/approval"
+	"github.com/fogfish/medium/internal/codec"
+	"github.com/fogfish/medium/internal/mock"
+)
+
+func TestApproval(t *testing.T) {
+	profile := medium.On("a", "").
+		Process(
+			medium.ScaleTo("small", 4, 4),
+			medium.Replica("origin"),
+		).
+		WithApproval()
+
+	setup := func(t *testing.T) (*mock.FS, *mock.FS) {
+		t.Helper()
+
+		data := mock.NewJpeg(16, 12)
+		inbox, quarantine, media := mock.NewFS(), mock.NewFS(), mock.NewFS()
+		inbox.Put("/a/b.jpg", data)
+
+		c := codec.NewCodec(profile, inbox, media, codec.Emitters{}, codec.WithQuarantine(quarantine))
+		if err := c.Process(context.Background(), mock.NewEvent("a/b.jpg")); err != nil {
+			t.Fatal(err)
+		}
+
+		return quarantine, media
+	}
+
+	t.Run("Approve", func(t *testing.T) {
+		quarantine, media := setup(t)
+		index := codec.NewIndex(mock.NewFS())
+		emitter := &mock.Emitter[approval.MediaApproved]{}
+
+		review, err := approval.New(quarantine, media, index, emitter).Apply(context.Background(),
+			approval.Request{Key: "a/b.jpg", Action: approval.ACTION_APPROVE},
+		)
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(review.State, codec.REVIEW_APPROVED),
+			it.True(media.Has("/a/b.small-4x4.jpg")),
+			it.True(media.Has("/a/b.origin.jpg")),
+			it.True(media.Has("/a/b.manifest.json")),
+			it.Equal(quarantine.Has("/a/b.small-4x4.jpg"), false),
+			it.True(quarantine.Has("/a/b.review.json")),
+			it.Equal(len(emitter.Events), 1),
+			it.Seq(emitter.Events[0].Keys).Equal("a/b.small-4x4.jpg", "a/b.origin.jpg"),
+		)
+
+		sources, err := index.Sources(review.Hash)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Seq(sources).Equal("/a/b.jpg"),
+		)
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		quarantine, media := setup(t)
+
+		review, err := approval.New(quarantine, media, codec.NewIndex(mock.NewFS()), nil).Apply(context.Background(),
+			approval.Request{Key: "a/b.jpg", Action: approval.ACTION_REJECT, Reason: "spam"},
+		)
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(review.State, codec.REVIEW_REJECTED),
+			it.Equal(review.Reason, "spam"),
+			it.Equal(media.Len(), 0),
+			it.Equal(quarantine.Len(), 1),
+		)
+	})
+
+	t.Run("NotPending", func(t *testing.T) {
+		quarantine, media := setup(t)
+		a := approval.New(quarantine, media, codec.NewIndex(mock.NewFS()), nil)
+
+		_, err := a.Apply(context.Background(),
+			approval.Request{Key: "a/b.jpg", Action: approval.ACTION_REJECT},
+		)
+		it.Then(t).Should(it.Nil(err))
+
+		_, err = a.Apply(context.Background(),
+			approval.Request{Key: "a/b.jpg", Action: approval.ACTION_APPROVE},
+		)
+		it.Then(t).ShouldNot(it.Nil(err))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		quarantine, media := setup(t)
+		a := approval.New(quarantine, media, codec.NewIndex(mock.NewFS()), nil)
+
+		for _, req := range []approval.Request{
+			{Action: approval.ACTION_APPROVE},
+			{Key: "a/b.jpg", Action: "unknown"},
+			{Key: "a/c.jpg", Action: approval.ACTION_APPROVE},
+		} {
+			_, err := a.Apply(context.Background(), req)
+			it.Then(t).ShouldNot(it.Nil(err))
+		}
+	})
+}
@@ -0,0 +1,67 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package approval
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	_ "github.com/fogfish/logger/v3"
+	"github.com/fogfish/logger/x/xlog"
+	"github.com/fogfish/medium/internal/approval"
+	"github.com/fogfish/medium/internal/codec"
+	"github.com/fogfish/stream"
+	"github.com/fogfish/swarm/broker/eventbridge"
+	"github.com/fogfish/swarm/emit"
+)
+
+// Runner of approval lambda, the lambda is invoked directly with approval.Request
+func Runner() {
+	quarantine, err := stream.New[codec.Meta](os.Getenv("CONFIG_STORE_QUARANTINE"))
+	if err != nil {
+		xlog.Emergency("Failed to init quarantine s3 client", err)
+	}
+
+	media, err := stream.New[codec.Meta](os.Getenv("CONFIG_STORE_MEDIA"))
+	if err != nil {
+		xlog.Emergency("Failed to init media s3 client", err)
+	}
+
+	index, err := stream.New[codec.Meta](os.Getenv("CONFIG_STORE_INDEX"))
+	if err != nil {
+		xlog.Emergency("Failed to init index s3 client", err)
+	}
+
+	var emitter codec.Emitter[approval.MediaApproved]
+	eventbus := os.Getenv("CONFIG_SINK_EVENTBUS")
+	if eventbus != "" {
+		bridge := eventbridge.Must(eventbridge.Emitter().Build(eventbus))
+		emitter = emit.NewTyped[approval.MediaApproved](bridge)
+	}
+
+	ap := approval.New(quarantine, media, codec.NewIndex(index), emitter)
+
+	lambda.Start(
+		func(ctx context.Context, req approval.Request) (*codec.Review, error) {
+			review, err := ap.Apply(ctx, req)
+			if err != nil {
+				slog.Error("failed to review media",
+					slog.String("key", req.Key),
+					slog.String("action", req.Action),
+					"error", err,
+				)
+				return nil, err
+			}
+
+			return review, nil
+		},
+	)
+}
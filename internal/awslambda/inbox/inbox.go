@@ -10,10 +10,14 @@ package inbox
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
 	"os"
+	"strconv"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/fogfish/gurl/v2/http"
 	_ "github.com/fogfish/logger/v3"
 	"github.com/fogfish/logger/x/xlog"
 	"github.com/fogfish/medium"
@@ -45,14 +49,130 @@ func Runner() {
 		)
 	}
 
-	var emitter codec.Emitter
+	var emitter codec.Emitters
 	eventbus := os.Getenv("CONFIG_SINK_EVENTBUS")
 	if eventbus != "" {
 		bridge := eventbridge.Must(eventbridge.Emitter().Build(eventbus))
-		emitter = emit.NewTyped[codec.MediaPublished](bridge)
+		emitter.Published = emit.NewTyped[codec.MediaPublished](bridge)
+		emitter.Removed = emit.NewTyped[codec.MediaRemoved](bridge)
+		emitter.PendingReview = emit.NewTyped[codec.MediaPendingReview](bridge)
+		emitter.Rejected = emit.NewTyped[codec.MediaRejected](bridge)
 	}
 
-	codec := codec.NewCodec(profile, inbox, media, emitter)
+	var opts []codec.Option
+	if addr := os.Getenv("CONFIG_CODEC_SCANNER"); addr != "" {
+		scanner, err := codec.NewClamd(addr)
+		if err != nil {
+			xlog.Emergency("Failed to init malware scanner", err,
+				"scanner", addr,
+			)
+		}
+		opts = append(opts, codec.WithScanner(scanner))
+	}
+
+	if mb, err := strconv.Atoi(os.Getenv("CONFIG_CODEC_MEMORY")); err == nil && mb > 0 {
+		opts = append(opts, codec.WithMemoryBudget(mb))
+	}
+
+	if mb, err := strconv.Atoi(os.Getenv("CONFIG_CODEC_STORAGE")); err == nil && mb > 0 {
+		opts = append(opts, codec.WithStorageBudget(mb))
+	}
+
+	if bin := os.Getenv("CONFIG_CODEC_TRANSCODER"); bin != "" {
+		ffmpeg := codec.NewFFmpeg(bin)
+		opts = append(opts,
+			codec.WithTranscoder(ffmpeg),
+			codec.WithFrameDecoder(ffmpeg),
+		)
+	}
+
+	if bin := os.Getenv("CONFIG_CODEC_DECODER"); bin != "" {
+		opts = append(opts, codec.WithScaledDecoder(codec.NewDjpeg(bin)))
+	}
+
+	if bin := os.Getenv("CONFIG_CODEC_HEIF"); bin != "" {
+		heifdec := codec.NewHeifDec(bin)
+		opts = append(opts,
+			codec.WithDecoder(codec.MEDIA_HEIF, heifdec),
+			codec.WithDecoder(codec.MEDIA_AVIF, heifdec),
+		)
+	}
+
+	if bin := os.Getenv("CONFIG_CODEC_SVG"); bin != "" {
+		opts = append(opts, codec.WithRasterizer(codec.NewRsvg(bin)))
+	}
+
+	if bin := os.Getenv("CONFIG_CODEC_RASTERIZER"); bin != "" {
+		opts = append(opts, codec.WithPageRasterizer(codec.NewPoppler(bin)))
+	}
+
+	var index *codec.Index
+	if store := os.Getenv("CONFIG_STORE_INDEX"); store != "" {
+		fsys, err := stream.New[codec.Meta](store)
+		if err != nil {
+			xlog.Emergency("Failed to init index s3 client", err)
+		}
+		index = codec.NewIndex(fsys)
+		opts = append(opts, codec.WithIndex(index))
+	}
+
+	if store := os.Getenv("CONFIG_STORE_QUARANTINE"); store != "" {
+		quarantine, err := stream.New[codec.Meta](store)
+		if err != nil {
+			xlog.Emergency("Failed to init quarantine s3 client", err)
+		}
+		opts = append(opts, codec.WithQuarantine(quarantine))
+	}
+
+	// blocked content is denied by the rules of moderation
+	var rules codec.Rules
+	if spec := os.Getenv("CONFIG_CODEC_MODERATION"); spec != "" {
+		if err := json.Unmarshal([]byte(spec), &rules); err != nil {
+			xlog.Emergency("Failed to init moderation rules", err,
+				"moderation", spec,
+			)
+		}
+	}
+	if store := os.Getenv("CONFIG_STORE_BLOCKLIST"); store != "" {
+		blocklist, err := stream.New[codec.Meta](store)
+		if err != nil {
+			xlog.Emergency("Failed to init blocklist s3 client", err)
+		}
+		rules.Blocklist = codec.NewBlocklist(blocklist)
+	}
+
+	var moderators codec.Moderators
+	if rules.Rules != (medium.Rules{}) || rules.Blocklist != nil {
+		moderators = append(moderators, rules)
+	}
+	if endpoint := os.Getenv("CONFIG_CODEC_CLASSIFIER"); endpoint != "" {
+		moderators = append(moderators, codec.NewClassifier(http.New(), endpoint))
+	}
+	if len(moderators) != 0 {
+		opts = append(opts, codec.WithModerator(moderators))
+	}
+
+	if spec := os.Getenv("CONFIG_CODEC_DUPLICATES"); spec != "" && index != nil {
+		var config struct {
+			Distance int    `json:"distance"`
+			Verdict  string `json:"verdict"`
+		}
+		if err := json.Unmarshal([]byte(spec), &config); err != nil {
+			xlog.Emergency("Failed to init duplicates detection", err,
+				"duplicates", spec,
+			)
+		}
+
+		duplicates, err := codec.NewDuplicates(index, config.Distance, config.Verdict)
+		if err != nil {
+			xlog.Emergency("Failed to init duplicates detection", err,
+				"duplicates", spec,
+			)
+		}
+		opts = append(opts, codec.WithDuplicates(duplicates))
+	}
+
+	codec := codec.NewCodec(profile, inbox, media, emitter, opts...)
 
 	bus := bus{codec: codec}
 	go bus.onEventS3(events3.Listen(q))
@@ -63,12 +183,26 @@ func Runner() {
 type bus struct {
 	codec interface {
 		Process(context.Context, swarm.Msg[*events.S3EventRecord]) error
+		Remove(context.Context, swarm.Msg[*events.S3EventRecord]) error
 	}
 }
 
 func (bus *bus) onEventS3(rcv <-chan swarm.Msg[*events.S3EventRecord], ack chan<- swarm.Msg[*events.S3EventRecord]) {
 	for evt := range rcv {
-		err := bus.codec.Process(context.Background(), evt)
+		var err error
+		if codec.IsRemoved(evt.Object) {
+			err = bus.codec.Remove(context.Background(), evt)
+		} else {
+			err = bus.codec.Process(context.Background(), evt)
+		}
+
+		// infected media or media exceeding limit of scanner is rejected,
+		// retry is not needed
+		if errors.Is(err, codec.ErrMalware) || errors.Is(err, codec.ErrScanLimit) {
+			ack <- evt
+			continue
+		}
+
 		if err != nil {
 			slog.Error("failed to process s3 event",
 				slog.String("bucket", evt.Object.S3.Bucket.Name),
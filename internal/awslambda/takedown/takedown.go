@@ -0,0 +1,67 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package takedown
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	_ "github.com/fogfish/logger/v3"
+	"github.com/fogfish/logger/x/xlog"
+	"github.com/fogfish/medium/internal/codec"
+	"github.com/fogfish/medium/internal/takedown"
+	"github.com/fogfish/stream"
+	"github.com/fogfish/swarm/broker/eventbridge"
+	"github.com/fogfish/swarm/emit"
+)
+
+// Runner of takedown lambda, the lambda is invoked directly with takedown.Request
+func Runner() {
+	media, err := stream.New[codec.Meta](os.Getenv("CONFIG_STORE_MEDIA"))
+	if err != nil {
+		xlog.Emergency("Failed to init media s3 client", err)
+	}
+
+	index, err := stream.New[codec.Meta](os.Getenv("CONFIG_STORE_INDEX"))
+	if err != nil {
+		xlog.Emergency("Failed to init index s3 client", err)
+	}
+
+	blocklist, err := stream.New[codec.Meta](os.Getenv("CONFIG_STORE_BLOCKLIST"))
+	if err != nil {
+		xlog.Emergency("Failed to init blocklist s3 client", err)
+	}
+
+	var emitter codec.Emitter[takedown.MediaTakenDown]
+	eventbus := os.Getenv("CONFIG_SINK_EVENTBUS")
+	if eventbus != "" {
+		bridge := eventbridge.Must(eventbridge.Emitter().Build(eventbus))
+		emitter = emit.NewTyped[takedown.MediaTakenDown](bridge)
+	}
+
+	td := takedown.New(media, codec.NewIndex(index), codec.NewBlocklist(blocklist), emitter)
+
+	lambda.Start(
+		func(ctx context.Context, req takedown.Request) (*takedown.MediaTakenDown, error) {
+			evt, err := td.Apply(ctx, req)
+			if err != nil {
+				slog.Error("failed to takedown media",
+					slog.String("key", req.Key),
+					slog.String("hash", req.Hash),
+					"error", err,
+				)
+				return nil, err
+			}
+
+			return evt, nil
+		},
+	)
+}
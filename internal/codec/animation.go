@@ -0,0 +1,191 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"log/slog"
+	"time"
+)
+
+// Animation of media, frames are coalesced into full canvas
+type Animation struct {
+	Frames []image.Image
+	Delay  []int // delay of each frame, in 100ths of a second
+	Loop   int   // loop count as defined by image/gif
+}
+
+func (a *Animation) Duration() time.Duration {
+	var d time.Duration
+	for _, delay := range a.Delay {
+		d += time.Duration(delay) * 10 * time.Millisecond
+	}
+	return d
+}
+
+// delays of GIF frames, blocks of GIF are walked without decoding frames
+func gifFrames(data []byte) ([]int, error) {
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF8")) {
+		return nil, fmt.Errorf("gif: invalid header")
+	}
+
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+
+	// skips sequence of data sub-blocks
+	skip := func() error {
+		for {
+			if pos >= len(data) {
+				return fmt.Errorf("gif: unexpected EOF")
+			}
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				return nil
+			}
+		}
+	}
+
+	var seq []int
+	delay := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension
+			if pos+1 >= len(data) {
+				return nil, fmt.Errorf("gif: unexpected EOF")
+			}
+			// graphic control extension defines the delay of next frame
+			if data[pos+1] == 0xf9 && pos+7 < len(data) && data[pos+2] == 4 {
+				delay = int(binary.LittleEndian.Uint16(data[pos+4:]))
+			}
+			pos += 2
+			if err := skip(); err != nil {
+				return nil, err
+			}
+		case 0x2c: // image descriptor
+			if pos+10 >= len(data) {
+				return nil, fmt.Errorf("gif: unexpected EOF")
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++ // LZW minimum code size
+			if err := skip(); err != nil {
+				return nil, err
+			}
+			seq = append(seq, delay)
+			delay = 0
+		case 0x3b: // trailer
+			return seq, nil
+		default:
+			return nil, fmt.Errorf("gif: invalid block 0x%02x", data[pos])
+		}
+	}
+
+	return nil, fmt.Errorf("gif: unexpected EOF")
+}
+
+// coalesce first n frames of GIF applying the disposal method, each frame is
+// rendered onto the full canvas.
+func coalesce(g *gif.GIF, n int) *Animation {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	for _, frame := range g.Image {
+		bounds = bounds.Union(frame.Bounds())
+	}
+
+	canvas := image.NewRGBA(bounds)
+	frames := make([]image.Image, n)
+	for i, frame := range g.Image[:n] {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var backup *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			backup = clone(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames[i] = clone(canvas)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = backup
+		}
+	}
+
+	return &Animation{
+		Frames: frames,
+		Delay:  g.Delay[:min(n, len(g.Delay))],
+		Loop:   g.LoopCount,
+	}
+}
+
+func clone(img *image.RGBA) *image.RGBA {
+	c := image.NewRGBA(img.Bounds())
+	copy(c.Pix, img.Pix)
+	return c
+}
+
+// animation is replaced by poster if it is disabled by profile or exceeds
+// the limits
+func (codec *Codec) animates(path string, frames int, duration time.Duration) bool {
+	switch {
+	case !codec.animated:
+		return false
+	case codec.maxFrames != 0 && frames > codec.maxFrames,
+		codec.maxDuration != 0 && duration > codec.maxDuration:
+		slog.Warn("animation exceeds limits, poster is used",
+			slog.String("path", path),
+			slog.Int("frames", frames),
+			slog.Duration("duration", duration),
+		)
+		return false
+	default:
+		return true
+	}
+}
+
+// transparent color is the last one
+var gifPalette = append(color.Palette{}, append(palette.Plan9[:255:255], color.Transparent)...)
+
+func encodeGif(anim *Animation) ([]byte, error) {
+	g := gif.GIF{
+		Image:     make([]*image.Paletted, len(anim.Frames)),
+		Delay:     anim.Delay,
+		LoopCount: anim.Loop,
+	}
+
+	for i, frame := range anim.Frames {
+		img := image.NewPaletted(frame.Bounds(), gifPalette)
+		draw.FloydSteinberg.Draw(img, frame.Bounds(), frame, frame.Bounds().Min)
+		g.Image[i] = img
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &g); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,157 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+	"time"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestCoalesce(t *testing.T) {
+	anim := coalesce(newMockAnimation(16, 12, 3), 3)
+
+	it.Then(t).Should(
+		it.Equal(len(anim.Frames), 3),
+		it.Equal(anim.Frames[2].Bounds(), image.Rect(0, 0, 16, 12)),
+		it.Equal(anim.Duration(), 300*time.Millisecond),
+		// background of first frame is kept by following frames
+		it.Equiv(color.RGBAModel.Convert(anim.Frames[2].At(15, 11)), color.Color(color.RGBA{0, 0, 255, 255})),
+		// frame 1 is disposed to transparent background
+		it.Equiv(color.RGBAModel.Convert(anim.Frames[1].At(0, 0)), color.Color(color.RGBA{255, 0, 0, 255})),
+		it.Equiv(color.RGBAModel.Convert(anim.Frames[2].At(0, 0)), color.Color(color.RGBA{0, 0, 0, 0})),
+		it.Equiv(color.RGBAModel.Convert(anim.Frames[2].At(1, 1)), color.Color(color.RGBA{255, 0, 0, 255})),
+	)
+
+	poster := coalesce(newMockAnimation(16, 12, 3), 1)
+	it.Then(t).Should(
+		it.Equal(len(poster.Frames), 1),
+		it.Equal(len(poster.Delay), 1),
+	)
+}
+
+func TestGifFrames(t *testing.T) {
+	var buf bytes.Buffer
+	it.Then(t).Should(it.Nil(gif.EncodeAll(&buf, newMockAnimation(8, 8, 5))))
+
+	t.Run("Frames", func(t *testing.T) {
+		delay, err := gifFrames(buf.Bytes())
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Seq(delay).Equal(10, 10, 10, 10, 10),
+		)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		_, err := gifFrames(buf.Bytes()[:buf.Len()-8])
+		it.Then(t).ShouldNot(it.Nil(err))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := gifFrames([]byte("PNG"))
+		it.Then(t).ShouldNot(it.Nil(err))
+	})
+}
+
+func TestCodecAnimation(t *testing.T) {
+	process := func(t *testing.T, profile medium.Profile) *mockFS {
+		t.Helper()
+
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, newMockAnimation(16, 12, 3)); err != nil {
+			t.Fatal(err)
+		}
+
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.gif", buf.Bytes())
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.gif"))
+		it.Then(t).Should(it.Nil(err))
+
+		return wfs
+	}
+
+	profile := medium.On("a", "").Process(
+		medium.ScaleTo("small", 4, 4),
+		medium.Replica("origin"),
+	)
+
+	t.Run("Animated", func(t *testing.T) {
+		wfs := process(t, profile.KeepAnimation(10, time.Second))
+
+		g, err := gif.DecodeAll(bytes.NewReader(wfs.files["/a/b.small-4x4.gif"]))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(len(g.Image), 3),
+			it.Equal(g.Image[0].Bounds(), image.Rect(0, 0, 4, 4)),
+			it.Seq(g.Delay).Equal(10, 10, 10),
+			it.True(wfs.Has("/a/b.origin.gif")),
+		)
+	})
+
+	t.Run("Poster", func(t *testing.T) {
+		wfs := process(t, profile)
+
+		it.Then(t).Should(
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+			it.True(wfs.Has("/a/b.origin.jpg")),
+		)
+	})
+
+	t.Run("ExceedsFrames", func(t *testing.T) {
+		wfs := process(t, profile.KeepAnimation(2, 0))
+
+		it.Then(t).Should(
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+		)
+	})
+
+	t.Run("ExceedsDuration", func(t *testing.T) {
+		wfs := process(t, profile.KeepAnimation(0, 100*time.Millisecond))
+
+		it.Then(t).Should(
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+		)
+	})
+}
+
+// animation with blue background and red square moving along diagonal,
+// squares are disposed to background.
+func newMockAnimation(w, h, n int) *gif.GIF {
+	pal := color.Palette{color.Transparent, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}}
+
+	g := &gif.GIF{Config: image.Config{ColorModel: pal, Width: w, Height: h}}
+
+	bg := image.NewPaletted(image.Rect(0, 0, w, h), pal)
+	for i := range bg.Pix {
+		bg.Pix[i] = 2
+	}
+	g.Image = append(g.Image, bg)
+	g.Delay = append(g.Delay, 10)
+	g.Disposal = append(g.Disposal, gif.DisposalNone)
+
+	for i := 1; i < n; i++ {
+		frame := image.NewPaletted(image.Rect(i-1, i-1, i+3, i+3), pal)
+		for j := range frame.Pix {
+			frame.Pix[j] = 1
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+
+	return g
+}
@@ -0,0 +1,448 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log/slog"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/jfreymuth/oggvorbis"
+)
+
+// Audio metadata
+type Audio struct {
+	Format     string        `json:"format"` // wav, mp3 or ogg
+	Duration   time.Duration `json:"duration"`
+	SampleRate int           `json:"sampleRate"`
+	Channels   int           `json:"channels"`
+
+	peaks *Peaks
+}
+
+func (a *Audio) ContentType() string {
+	switch a.Format {
+	case "mp3":
+		return "audio/mpeg"
+	default:
+		return "audio/" + a.Format
+	}
+}
+
+// Size of the waveform used as image of audio media
+const (
+	waveformWidth  = 1024
+	waveformHeight = 256
+)
+
+// fetches audio media, the waveform is built while the object is streamed
+func (r Reader) fetchMediaAudio(_ context.Context, path string) (*Media, error) {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+
+	fd, err := r.fsys.Open(path)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	defer fd.Close()
+
+	hash := sha256.New()
+	stream := io.TeeReader(fd, hash)
+
+	var audio *Audio
+	switch format {
+	case "wav":
+		audio, err = decodeWav(stream)
+	case "mp3":
+		audio, err = decodeMp3(stream)
+	case "ogg":
+		audio, err = decodeOgg(stream)
+	default:
+		return nil, errCodecNotSupported.With(nil, MEDIA_AUDIO+"/"+format)
+	}
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	audio.Format = format
+
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	slog.Debug("audio metadata",
+		slog.String("path", path),
+		slog.Any("audio", audio),
+	)
+
+	waveform := audio.peaks.render(waveformWidth, waveformHeight)
+
+	return &Media{
+		path:   path,
+		hash:   hex.EncodeToString(hash.Sum(nil)),
+		image:  waveform,
+		audio:  audio,
+		origin: &Origin{fsys: r.fsys, path: path, contentType: audio.ContentType()},
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// WAV sample encoding
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xfffe
+)
+
+// limit of channels, extensible wav defines 18 speaker positions
+const wavChannelsLimit = 32
+
+// size of buffer used to decode samples, it is aligned to blocks
+const wavBufferSize = 64 << 10
+
+type wavFormat struct {
+	encoding   uint16
+	channels   int
+	sampleRate int
+	blockAlign int
+	bits       int
+}
+
+// decodes WAV stream into peaks of waveform
+func decodeWav(r io.Reader) (*Audio, error) {
+	br := bufio.NewReader(r)
+
+	var riff [12]byte
+	if _, err := io.ReadFull(br, riff[:]); err != nil {
+		return nil, fmt.Errorf("wav: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("wav: invalid header")
+	}
+
+	var format *wavFormat
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(br, chunk[:]); err != nil {
+			return nil, fmt.Errorf("wav: data not found: %w", err)
+		}
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch string(chunk[0:4]) {
+		case "fmt ":
+			if size < 16 || size > 1024 {
+				return nil, fmt.Errorf("wav: invalid fmt chunk")
+			}
+
+			data := make([]byte, size+size%2)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return nil, fmt.Errorf("wav: %w", err)
+			}
+
+			format, _ = parseWavFormat(data)
+			if format == nil {
+				return nil, fmt.Errorf("wav: unsupported format")
+			}
+		case "data":
+			if format == nil {
+				return nil, fmt.Errorf("wav: fmt chunk not found")
+			}
+
+			// streamed wav does not define the size
+			var data io.Reader = br
+			if size != 0 && size != math.MaxUint32 {
+				data = io.LimitReader(br, int64(size))
+			}
+
+			return format.decode(data)
+		default:
+			if _, err := io.CopyN(io.Discard, br, int64(size)+int64(size%2)); err != nil {
+				return nil, fmt.Errorf("wav: %w", err)
+			}
+		}
+	}
+}
+
+func parseWavFormat(data []byte) (*wavFormat, bool) {
+	format := &wavFormat{
+		encoding:   binary.LittleEndian.Uint16(data[0:2]),
+		channels:   int(binary.LittleEndian.Uint16(data[2:4])),
+		sampleRate: int(binary.LittleEndian.Uint32(data[4:8])),
+		blockAlign: int(binary.LittleEndian.Uint16(data[12:14])),
+		bits:       int(binary.LittleEndian.Uint16(data[14:16])),
+	}
+
+	// sub-format of extensible wav is defined by GUID
+	if format.encoding == wavExtensible && len(data) >= 26 {
+		format.encoding = binary.LittleEndian.Uint16(data[24:26])
+	}
+
+	switch {
+	case format.channels == 0 || format.channels > wavChannelsLimit || format.sampleRate == 0:
+		return nil, false
+	case format.blockAlign != format.channels*format.bits/8:
+		return nil, false
+	case format.encoding == wavPCM && (format.bits == 8 || format.bits == 16 || format.bits == 24 || format.bits == 32):
+		return format, true
+	case format.encoding == wavFloat && (format.bits == 32 || format.bits == 64):
+		return format, true
+	default:
+		return nil, false
+	}
+}
+
+// decodes samples, channels are mixed into mono
+func (f *wavFormat) decode(r io.Reader) (*Audio, error) {
+	peaks := newPeaks(f.sampleRate)
+	bytesPerSample := f.bits / 8
+
+	frames := 0
+	block := make([]byte, wavBufferSize-wavBufferSize%f.blockAlign)
+	for {
+		n, err := io.ReadFull(r, block)
+		for i := 0; i+f.blockAlign <= n; i += f.blockAlign {
+			x := 0.0
+			for c := 0; c < f.channels; c++ {
+				x += f.sample(block[i+c*bytesPerSample:])
+			}
+			peaks.add(x / float64(f.channels))
+			frames++
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("wav: %w", err)
+		}
+	}
+
+	return &Audio{
+		Duration:   time.Duration(frames) * time.Second / time.Duration(f.sampleRate),
+		SampleRate: f.sampleRate,
+		Channels:   f.channels,
+		peaks:      peaks.flush(),
+	}, nil
+}
+
+// sample normalized to [-1, 1]
+func (f *wavFormat) sample(b []byte) float64 {
+	switch {
+	case f.encoding == wavFloat && f.bits == 32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case f.encoding == wavFloat:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case f.bits == 8:
+		return (float64(b[0]) - 128) / 128
+	case f.bits == 16:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+	case f.bits == 24:
+		return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+	default:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// decodes MP3 stream into peaks of waveform, the decoder produces 16-bit
+// stereo PCM.
+func decodeMp3(r io.Reader) (*Audio, error) {
+	d, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, fmt.Errorf("mp3: %w", err)
+	}
+
+	format := &wavFormat{
+		encoding:   wavPCM,
+		channels:   2,
+		sampleRate: d.SampleRate(),
+		blockAlign: 4,
+		bits:       16,
+	}
+	if format.sampleRate == 0 {
+		return nil, fmt.Errorf("mp3: invalid sample rate")
+	}
+
+	audio, err := format.decode(d)
+	if err != nil {
+		return nil, fmt.Errorf("mp3: %w", err)
+	}
+
+	return audio, nil
+}
+
+// decodes Ogg Vorbis stream into peaks of waveform
+func decodeOgg(r io.Reader) (*Audio, error) {
+	d, err := oggvorbis.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("ogg: %w", err)
+	}
+
+	if d.Channels() == 0 || d.Channels() > wavChannelsLimit || d.SampleRate() == 0 {
+		return nil, fmt.Errorf("ogg: unsupported format")
+	}
+
+	audio, err := decodeSamples(d, d.Channels(), d.SampleRate())
+	if err != nil {
+		return nil, fmt.Errorf("ogg: %w", err)
+	}
+
+	return audio, nil
+}
+
+// decodes interleaved float samples, channels are mixed into mono
+func decodeSamples(r interface{ Read([]float32) (int, error) }, channels, sampleRate int) (*Audio, error) {
+	peaks := newPeaks(sampleRate)
+
+	frames := 0
+	buf := make([]float32, channels*4096)
+	for {
+		n, err := r.Read(buf)
+		for i := 0; i+channels <= n; i += channels {
+			x := 0.0
+			for c := 0; c < channels; c++ {
+				x += float64(buf[i+c])
+			}
+			peaks.add(x / float64(channels))
+			frames++
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Audio{
+		Duration:   time.Duration(frames) * time.Second / time.Duration(sampleRate),
+		SampleRate: sampleRate,
+		Channels:   channels,
+		peaks:      peaks.flush(),
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+// limit of peaks kept for audio, resolution of peaks is halved when exceeded
+const peaksLimit = 1 << 15
+
+// Peaks of waveform, min and max of 8-bit samples per pixel. The peaks are
+// published using format of audiowaveform (https://github.com/bbc/audiowaveform)
+type Peaks struct {
+	SampleRate      int
+	SamplesPerPixel int
+	Data            []int8 // min, max pairs
+
+	n        int // samples at pending pixel
+	min, max float64
+}
+
+// peaks at resolution of 10ms
+func newPeaks(sampleRate int) *Peaks {
+	return &Peaks{
+		SampleRate:      sampleRate,
+		SamplesPerPixel: max(1, sampleRate/100),
+		Data:            make([]int8, 0, 1024),
+		min:             1,
+		max:             -1,
+	}
+}
+
+func (p *Peaks) add(x float64) {
+	p.min, p.max = min(p.min, x), max(p.max, x)
+	p.n++
+
+	if p.n == p.SamplesPerPixel {
+		p.flush()
+	}
+}
+
+// flushes pending pixel
+func (p *Peaks) flush() *Peaks {
+	if p.n == 0 {
+		return p
+	}
+
+	p.Data = append(p.Data, quantize(p.min), quantize(p.max))
+	p.n, p.min, p.max = 0, 1, -1
+
+	if len(p.Data) == 2*peaksLimit {
+		for i := 0; i < peaksLimit/2; i++ {
+			p.Data[2*i] = min(p.Data[4*i], p.Data[4*i+2])
+			p.Data[2*i+1] = max(p.Data[4*i+1], p.Data[4*i+3])
+		}
+		p.Data = p.Data[:peaksLimit]
+		p.SamplesPerPixel *= 2
+	}
+
+	return p
+}
+
+func quantize(x float64) int8 {
+	return int8(math.Round(max(-1, min(1, x)) * 127))
+}
+
+func (p *Peaks) Len() int { return len(p.Data) / 2 }
+
+func (p *Peaks) MarshalJSON() ([]byte, error) {
+	data := make([]int, len(p.Data))
+	for i, x := range p.Data {
+		data[i] = int(x)
+	}
+
+	return json.Marshal(struct {
+		Version         int   `json:"version"`
+		Channels        int   `json:"channels"`
+		SampleRate      int   `json:"sample_rate"`
+		SamplesPerPixel int   `json:"samples_per_pixel"`
+		Bits            int   `json:"bits"`
+		Length          int   `json:"length"`
+		Data            []int `json:"data"`
+	}{2, 1, p.SampleRate, p.SamplesPerPixel, 8, p.Len(), data})
+}
+
+// renders waveform, each column shows the range of samples
+func (p *Peaks) render(w, h int) image.Image {
+	w, h = max(1, w), max(1, h)
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	fg := color.NRGBA{R: 0x33, G: 0x33, B: 0x33, A: 0xff}
+
+	n := p.Len()
+	for x := 0; x < w && n > 0; x++ {
+		a, b := x*n/w, max((x+1)*n/w, x*n/w+1)
+
+		lo, hi := int8(127), int8(-127)
+		for i := a; i < b; i++ {
+			lo, hi = min(lo, p.Data[2*i]), max(hi, p.Data[2*i+1])
+		}
+
+		top := int(math.Round((1 - float64(hi)/127) * float64(h-1) / 2))
+		bottom := int(math.Round((1 - float64(lo)/127) * float64(h-1) / 2))
+		for y := top; y <= max(bottom, top); y++ {
+			img.SetNRGBA(x, y, fg)
+		}
+	}
+
+	return img
+}
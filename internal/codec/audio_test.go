@@ -0,0 +1,322 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestDecodeWav(t *testing.T) {
+	for _, format := range []struct {
+		encoding, bits int
+	}{{wavPCM, 8}, {wavPCM, 16}, {wavPCM, 24}, {wavPCM, 32}, {wavFloat, 32}, {wavFloat, 64}} {
+		audio, err := decodeWav(bytes.NewReader(newMockWav(format.encoding, format.bits, 2, 8000, 8000, false)))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(audio.Duration, time.Second),
+			it.Equal(audio.SampleRate, 8000),
+			it.Equal(audio.Channels, 2),
+			it.Equal(audio.peaks.SamplesPerPixel, 80),
+			it.Equal(audio.peaks.Len(), 100),
+			it.Less(audio.peaks.Data[0], -120),
+			it.Greater(audio.peaks.Data[1], 120),
+		)
+	}
+
+	t.Run("Streamed", func(t *testing.T) {
+		audio, err := decodeWav(bytes.NewReader(newMockWav(wavPCM, 16, 1, 8000, 4000, true)))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(audio.Duration, 500*time.Millisecond),
+			it.Equal(audio.peaks.Len(), 50),
+		)
+	})
+
+	t.Run("PeaksLimit", func(t *testing.T) {
+		audio, err := decodeWav(bytes.NewReader(newMockWav(wavPCM, 8, 1, 100, 3*peaksLimit, false)))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(audio.Duration, time.Duration(3*peaksLimit)*10*time.Millisecond),
+			it.Equal(audio.peaks.SamplesPerPixel, 4),
+			it.Equal(audio.peaks.Len(), 3*peaksLimit/4),
+		)
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		wav := newMockWav(wavPCM, 16, 1, 8000, 10, false)
+		for _, input := range [][]byte{
+			nil,
+			wav[:10],
+			wav[:20],
+			newMockWav(2, 16, 1, 8000, 10, false),
+			newMockWav(wavPCM, 12, 1, 8000, 10, false),
+			newMockWav(wavPCM, 16, 0, 8000, 10, false),
+			newMockWav(wavPCM, 16, wavChannelsLimit+1, 8000, 10, false),
+		} {
+			_, err := decodeWav(bytes.NewReader(input))
+			it.Then(t).ShouldNot(it.Nil(err))
+		}
+	})
+}
+
+func TestDecodeMp3(t *testing.T) {
+	audio, err := decodeMp3(bytes.NewReader(newMockMp3(40)))
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(audio.SampleRate, 44100),
+		it.Equal(audio.Channels, 2),
+		it.Greater(audio.Duration, 900*time.Millisecond),
+		it.Less(audio.Duration, 1100*time.Millisecond),
+	)
+
+	t.Run("Corrupted", func(t *testing.T) {
+		_, err := decodeMp3(bytes.NewReader([]byte("ID3 mp3 frames")))
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("mp3"),
+		)
+	})
+}
+
+func TestDecodeOgg(t *testing.T) {
+	t.Run("Samples", func(t *testing.T) {
+		audio, err := decodeSamples(&mockSamples{n: 8000}, 2, 8000)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(audio.Duration, time.Second),
+			it.Equal(audio.Channels, 2),
+			it.Equal(audio.peaks.Len(), 100),
+			it.Less(audio.peaks.Data[0], -120),
+			it.Greater(audio.peaks.Data[1], 120),
+		)
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		_, err := decodeOgg(bytes.NewReader([]byte("OggS")))
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("ogg"),
+		)
+	})
+}
+
+func TestPeaks(t *testing.T) {
+	audio, _ := decodeWav(bytes.NewReader(newMockWav(wavPCM, 16, 1, 8000, 800, false)))
+
+	t.Run("JSON", func(t *testing.T) {
+		var peaks struct {
+			Version         int   `json:"version"`
+			SampleRate      int   `json:"sample_rate"`
+			SamplesPerPixel int   `json:"samples_per_pixel"`
+			Bits            int   `json:"bits"`
+			Length          int   `json:"length"`
+			Data            []int `json:"data"`
+		}
+		data, err := json.Marshal(audio.peaks)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Nil(json.Unmarshal(data, &peaks)),
+			it.Equal(peaks.Version, 2),
+			it.Equal(peaks.SampleRate, 8000),
+			it.Equal(peaks.SamplesPerPixel, 80),
+			it.Equal(peaks.Bits, 8),
+			it.Equal(peaks.Length, 10),
+			it.Equal(len(peaks.Data), 20),
+		)
+	})
+
+	t.Run("RenderEmpty", func(t *testing.T) {
+		img := audio.peaks.render(20, 0)
+		it.Then(t).Should(
+			it.Equal(img.Bounds(), image.Rect(0, 0, 20, 1)),
+		)
+	})
+
+	t.Run("Render", func(t *testing.T) {
+		img := audio.peaks.render(20, 16)
+		it.Then(t).Should(
+			it.Equal(img.Bounds(), image.Rect(0, 0, 20, 16)),
+			it.Equal(alphaAt(img, 0, 0), 0xffff),
+			it.Equal(alphaAt(img, 0, 15), 0xffff),
+			it.Equal(alphaAt(img, 19, 8), 0xffff),
+		)
+	})
+}
+
+func alphaAt(img image.Image, x, y int) uint32 {
+	_, _, _, a := img.At(x, y).RGBA()
+	return a
+}
+
+func TestCodecAudio(t *testing.T) {
+	profile := medium.On("a", "").
+		Process(
+			medium.ScaleTo("wave", 64, 16),
+			medium.Replica("origin"),
+		)
+
+	t.Run("Wav", func(t *testing.T) {
+		source := newMockWav(wavPCM, 16, 2, 8000, 8000, false)
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.wav", source)
+		emitter := &mockEmitter[MediaPublished]{}
+
+		codec := NewCodec(profile, rfs, wfs, Emitters{Published: emitter})
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.wav"))),
+			it.Equal(wfs.meta["/a/b.wave-64x16.png"].ContentType, "image/png"),
+			it.Equal(string(wfs.files["/a/b.origin.wav"]), string(source)),
+			it.Equal(wfs.meta["/a/b.origin.wav"].ContentType, "audio/wav"),
+			it.Equal(wfs.meta["/a/b.peaks.json"].ContentType, "application/json"),
+			it.Equal(len(emitter.events), 1),
+			it.Equal(emitter.events[0].Peaks, "a/b.peaks.json"),
+			it.Equal(emitter.events[0].Audio.Format, "wav"),
+			it.Equal(emitter.events[0].Audio.Duration, time.Second),
+			it.Equal(emitter.events[0].Audio.SampleRate, 8000),
+		)
+
+		img, _, err := image.Decode(bytes.NewReader(wfs.files["/a/b.wave-64x16.png"]))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 64, 16)),
+		)
+
+		it.Then(t).Should(
+			it.Nil(codec.Remove(context.Background(), newMockEvent("a/b.wav"))),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("Mp3", func(t *testing.T) {
+		source := newMockMp3(40)
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.mp3", source)
+
+		codec := NewCodec(profile, rfs, wfs, Emitters{})
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.mp3"))),
+			it.True(wfs.Has("/a/b.wave-64x16.png")),
+			it.Equal(string(wfs.files["/a/b.origin.mp3"]), string(source)),
+			it.Equal(wfs.meta["/a/b.origin.mp3"].ContentType, "audio/mpeg"),
+		)
+	})
+
+	t.Run("WidthOnly", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.wav", newMockWav(wavPCM, 16, 1, 8000, 800, false))
+
+		codec := NewCodec(medium.On("a", "").Process(medium.WidthLadder("wave", 2)...), rfs, wfs, Emitters{})
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.wav"))),
+			it.True(wfs.Has("/a/b.wave.2w-2x0.png")),
+		)
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.ogg", []byte("OggS"))
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.ogg"))
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("ogg"),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+}
+
+// MPEG-1 Layer III, 128 kbit/s, 44.1 kHz mono frames of silence, the side
+// information and main data are zeros.
+func newMockMp3(frames int) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0xc0})
+
+	return bytes.Repeat(frame, frames)
+}
+
+// interleaved samples of full-scale with alternating sign
+type mockSamples struct{ n int }
+
+func (m *mockSamples) Read(p []float32) (int, error) {
+	n := 0
+	for ; n+2 <= len(p) && m.n > 0; n += 2 {
+		x := float32(1.0)
+		if m.n%2 == 1 {
+			x = -1.0
+		}
+		p[n], p[n+1] = x, x
+		m.n--
+	}
+
+	if m.n == 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WAV of full-scale samples with alternating sign
+func newMockWav(encoding, bits, channels, rate, frames int, streamed bool) []byte {
+	var data []byte
+	for i := 0; i < frames; i++ {
+		x := 1.0
+		if i%2 == 1 {
+			x = -1.0
+		}
+
+		for c := 0; c < channels; c++ {
+			switch {
+			case encoding == wavFloat && bits == 32:
+				data = binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(x)))
+			case encoding == wavFloat:
+				data = binary.LittleEndian.AppendUint64(data, math.Float64bits(x))
+			case bits == 8:
+				data = append(data, byte(128+x*127))
+			case bits == 16:
+				data = binary.LittleEndian.AppendUint16(data, uint16(int16(x*math.MaxInt16)))
+			case bits == 24:
+				v := uint32(int32(x * (1<<23 - 1)))
+				data = append(data, byte(v), byte(v>>8), byte(v>>16))
+			default:
+				data = binary.LittleEndian.AppendUint32(data, uint32(int32(x*math.MaxInt32)))
+			}
+		}
+	}
+
+	fmt := binary.LittleEndian.AppendUint16(nil, uint16(encoding))
+	fmt = binary.LittleEndian.AppendUint16(fmt, uint16(channels))
+	fmt = binary.LittleEndian.AppendUint32(fmt, uint32(rate))
+	fmt = binary.LittleEndian.AppendUint32(fmt, uint32(rate*channels*bits/8))
+	fmt = binary.LittleEndian.AppendUint16(fmt, uint16(channels*bits/8))
+	fmt = binary.LittleEndian.AppendUint16(fmt, uint16(bits))
+
+	size := uint32(len(data))
+	if streamed {
+		size = math.MaxUint32
+	}
+
+	wav := []byte("RIFF\x00\x00\x00\x00WAVE")
+	wav = append(wav, "fmt "...)
+	wav = binary.LittleEndian.AppendUint32(wav, uint32(len(fmt)))
+	wav = append(wav, fmt...)
+	wav = append(wav, "LIST\x03\x00\x00\x00abc\x00"...)
+	wav = append(wav, "data"...)
+	wav = binary.LittleEndian.AppendUint32(wav, size)
+	return append(wav, data...)
+}
@@ -0,0 +1,65 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// Blocklist of media content, the content is identified by sha256 hash.
+// Each hash is stored as individual object at private file system.
+type Blocklist struct {
+	writer *Writer
+}
+
+// Entry of the blocklist
+type Blocked struct {
+	Hash    string    `json:"hash"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+}
+
+func NewBlocklist(fsys WriterFS) *Blocklist {
+	return &Blocklist{
+		writer: NewWriter(fsys),
+	}
+}
+
+// Has checks if content is blocked
+func (b *Blocklist) Has(ctx context.Context, hash string) (bool, error) {
+	_, err := fs.Stat(b.writer.fsys, "/"+hash)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	default:
+		return false, errCodecIO.With(err)
+	}
+}
+
+// Add content to blocklist
+func (b *Blocklist) Add(ctx context.Context, hash string, reason string) error {
+	data, err := json.Marshal(
+		Blocked{
+			Hash:    hash,
+			Reason:  reason,
+			Created: time.Now().UTC(),
+		},
+	)
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+
+	return b.writer.write("/"+hash, &Meta{ContentType: "application/json"}, data)
+}
@@ -0,0 +1,90 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"image"
+	"sort"
+
+	"github.com/fogfish/medium"
+)
+
+// the larger variant is used for downscaling if its scale is at least twice
+// the scale of the derived variant, otherwise resampling artefacts are visible.
+const cascadeRatio = 2.0
+
+// Plans the cascade of downscaling. The still image variants are ordered by
+// size, each one is derived from the smallest larger variant that covers its
+// crop region at the sufficient scale. Nearest neighbour variants keep pixels
+// of the source, they are out of cascade. The plan is the index of the parent
+// variant for each scaler, -1 stands for the source.
+func (codec *Codec) cascade(media *Media) []int {
+	parent := make([]int, len(codec.scaler))
+	for i := range parent {
+		parent[i] = -1
+	}
+
+	if !codec.cascaded || media.animation != nil || media.vector != nil || media.audio != nil {
+		return parent
+	}
+
+	source := image.Point{X: media.image.Bounds().Dx(), Y: media.image.Bounds().Dy()}
+	if source.X == 0 || source.Y == 0 {
+		return parent
+	}
+
+	order := make([]int, 0, len(codec.scaler))
+	for i, s := range codec.scaler {
+		if s.resolution.Bitrate == 0 && !s.replicates() && s.resolution.Filter != medium.Nearest {
+			order = append(order, i)
+		}
+	}
+
+	size := func(i int) image.Point { return codec.scaler[i].sizeOf(media.image.Bounds()) }
+	sort.SliceStable(order, func(a, b int) bool {
+		sa, sb := size(order[a]), size(order[b])
+		return sa.X*sa.Y > sb.X*sb.Y
+	})
+
+	for k, i := range order {
+		target := size(i)
+		region := cropRegion(source, target)
+
+		for j := k - 1; j >= 0; j-- {
+			larger := size(order[j])
+			covers := cropRegion(source, larger)
+
+			// the larger variant is a downscale that contains crop region of the target
+			if larger.X > covers.X || covers.X < region.X || covers.Y < region.Y {
+				continue
+			}
+
+			// scale of the larger variant within the crop region of the target
+			scale := float64(larger.X) / float64(covers.X)
+			if scale*float64(region.X) < cascadeRatio*float64(target.X) {
+				continue
+			}
+
+			parent[i] = order[j]
+			break
+		}
+	}
+
+	return parent
+}
+
+// region of the source used by the variant of target size
+func cropRegion(source, target image.Point) image.Point {
+	if target.X == 0 || target.Y == 0 {
+		return source
+	}
+
+	cropX, cropY := CropToScale(source, target)
+	return image.Point{X: source.X - cropX, Y: source.Y - cropY}
+}
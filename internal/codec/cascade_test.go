@@ -0,0 +1,144 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestCascade(t *testing.T) {
+	profile := medium.On("a", "").Process(
+		medium.ScaleTo("large", 800, 600),
+		medium.Replica("origin"),
+		medium.WidthLadder("thumb", 100)[0],
+		medium.ScaleTo("square", 200, 200),
+		medium.ScaleTo("medium", 400, 300),
+	)
+
+	t.Run("Plan", func(t *testing.T) {
+		codec := NewCodec(profile, newMockFS(), newMockFS(), Emitters{})
+		media := &Media{image: image.NewGray(image.Rect(0, 0, 1600, 1200))}
+
+		it.Then(t).Should(
+			it.Seq(codec.cascade(media)).Equal(-1, -1, 4, 0, 0),
+		)
+	})
+
+	t.Run("Upscale", func(t *testing.T) {
+		profile := medium.On("a", "").Process(
+			medium.ScaleTo("huge", 3200, 2400),
+			medium.ScaleTo("large", 800, 600),
+		)
+		codec := NewCodec(profile, newMockFS(), newMockFS(), Emitters{})
+		media := &Media{image: image.NewGray(image.Rect(0, 0, 1600, 1200))}
+
+		it.Then(t).Should(
+			it.Seq(codec.cascade(media)).Equal(-1, -1),
+		)
+	})
+
+	t.Run("Nearest", func(t *testing.T) {
+		profile := medium.On("a", "").Process(
+			medium.ScaleTo("large", 800, 600),
+			medium.ScaleTo("pixel", 400, 300).Resample(medium.Nearest),
+			medium.ScaleTo("small", 200, 150),
+		)
+		codec := NewCodec(profile, newMockFS(), newMockFS(), Emitters{})
+		media := &Media{image: image.NewGray(image.Rect(0, 0, 1600, 1200))}
+
+		it.Then(t).Should(
+			it.Seq(codec.cascade(media)).Equal(-1, -1, 0),
+		)
+	})
+
+	t.Run("Animation", func(t *testing.T) {
+		codec := NewCodec(profile, newMockFS(), newMockFS(), Emitters{})
+		media := &Media{
+			image:     image.NewGray(image.Rect(0, 0, 1600, 1200)),
+			animation: &Animation{},
+		}
+
+		it.Then(t).Should(
+			it.Seq(codec.cascade(media)).Equal(-1, -1, -1, -1, -1),
+		)
+	})
+
+	t.Run("Publish", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.jpg", newMockJpeg(t, 1600, 1200))
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}, WithConcurrency(1)).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(sizeOfJpeg(t, wfs, "/a/b.large-800x600.jpg"), image.Point{X: 800, Y: 600}),
+			it.Equal(sizeOfJpeg(t, wfs, "/a/b.medium-400x300.jpg"), image.Point{X: 400, Y: 300}),
+			it.Equal(sizeOfJpeg(t, wfs, "/a/b.square-200x200.jpg"), image.Point{X: 200, Y: 200}),
+			it.Equal(sizeOfJpeg(t, wfs, "/a/b.thumb.100w-100x0.jpg"), image.Point{X: 100, Y: 75}),
+		)
+	})
+}
+
+func sizeOfJpeg(t testing.TB, fsys *mockFS, path string) image.Point {
+	t.Helper()
+
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	img, err := jpeg.DecodeConfig(bytes.NewReader(fsys.files[path]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return image.Point{X: img.Width, Y: img.Height}
+}
+
+func BenchmarkPublish(b *testing.B) {
+	profile := medium.On("a", "").Process(
+		medium.ScaleTo("large", 1200, 900),
+		medium.ScaleTo("medium", 800, 600),
+		medium.ScaleTo("small", 400, 300),
+		medium.ScaleTo("square", 200, 200),
+		medium.ScaleTo("thumb", 100, 100),
+	)
+
+	img := image.NewRGBA(image.Rect(0, 0, 2400, 1800))
+	for y := 0; y < 1800; y++ {
+		for x := 0; x < 2400; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8(x ^ y), A: 0xff})
+		}
+	}
+	media := &Media{path: "/a/b.jpg", hash: "b", image: img}
+
+	for _, cascaded := range []bool{false, true} {
+		name := "Independent"
+		if cascaded {
+			name = "Cascade"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			codec := NewCodec(profile, newMockFS(), newMockFS(), Emitters{})
+			codec.cascaded = cascaded
+
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.publish(context.Background(), media, codec.writer); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -10,94 +10,652 @@ package codec
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
 	"os"
+	"runtime"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/fogfish/gurl/v2/http"
 	"github.com/fogfish/medium"
 	"github.com/fogfish/swarm"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
-type Emitter interface {
-	Enq(context.Context, MediaPublished, ...string) error
+type Emitter[T any] interface {
+	Enq(context.Context, T, ...string) error
+}
+
+// Emitters of codec events, the event is not emitted if emitter is nil
+type Emitters struct {
+	Published     Emitter[MediaPublished]
+	Removed       Emitter[MediaRemoved]
+	PendingReview Emitter[MediaPendingReview]
+	Rejected      Emitter[MediaRejected]
+}
+
+// Media writer used by the codec, either direct or transactional
+type publisher interface {
+	Put(context.Context, *Media) error
+	PutSidecar(context.Context, Sidecar) error
 }
 
 type Codec struct {
-	reader  *Reader
-	scaler  []*Scaler
-	writer  *Writer
-	emitter Emitter
+	reader    *Reader
+	scanner   Scanner
+	scaler    []*Scaler
+	layout    medium.Profile // layout of sidecar objects
+	writer    *Writer
+	emitter   Emitters
+	index     *Index
+	moderator Moderator
+	duplicate *Duplicates
+	quarantor *Writer
+	profile   string
+	atomic    bool
+	reemit    bool
+	approval  bool
+	workers   int    // limit of concurrent scalers
+	budget    budget // memory budget of media processing
+	cascaded  bool   // variants are derived from larger variants
+
+	animated    bool
+	maxFrames   int
+	maxDuration time.Duration
+}
+
+// Option of the codec
+type Option func(*Codec)
+
+// WithScanner scans media for malware before decoding
+func WithScanner(scanner Scanner) Option {
+	return func(codec *Codec) { codec.scanner = scanner }
+}
+
+// WithDecoder decodes media format using the decoder (e.g. MEDIA_HEIF)
+func WithDecoder(format string, decoder Decoder) Option {
+	return func(codec *Codec) { codec.reader.decoders[format] = decoder }
+}
+
+// WithScaledDecoder decodes JPEG media at the smallest scale that covers
+// all resolutions of the profile
+func WithScaledDecoder(decoder ScaledDecoder) Option {
+	return func(codec *Codec) { codec.reader.scaled = decoder }
+}
+
+// WithRasterizer renders SVG media
+func WithRasterizer(rasterizer Rasterizer) Option {
+	return func(codec *Codec) { codec.reader.rasterizer = rasterizer }
+}
+
+// WithFrameDecoder extracts poster frame of video media
+func WithFrameDecoder(decoder FrameDecoder) Option {
+	return func(codec *Codec) { codec.reader.frames = decoder }
+}
+
+// WithTranscoder transcodes video media into renditions
+func WithTranscoder(transcoder Transcoder) Option {
+	return func(codec *Codec) {
+		for _, s := range codec.scaler {
+			s.transcoder = transcoder
+		}
+	}
+}
+
+// WithPageRasterizer renders page of document media
+func WithPageRasterizer(rasterizer PageRasterizer) Option {
+	return func(codec *Codec) { codec.reader.pages = rasterizer }
+}
+
+// WithConcurrency limits number of concurrent scalers, each scaler holds
+// the variant in memory while it is encoded and written.
+func WithConcurrency(n int) Option {
+	return func(codec *Codec) { codec.workers = max(1, n) }
 }
 
-func NewCodec(profile medium.Profile, rfs ReaderFS, wfs WriterFS, emitter Emitter) *Codec {
+// WithMemoryBudget limits memory (MB) used by the codec, media that does not
+// fit is rejected before processing. The limit of concurrent scalers follows
+// the memory, lambda allocates one vCPU per 1769 MB.
+func WithMemoryBudget(mb int) Option {
+	return func(codec *Codec) {
+		codec.budget = newBudget(mb)
+		codec.reader.budget = codec.budget
+		codec.workers = max(1, min(codec.workers, (mb+memoryPerWorker-1)/memoryPerWorker))
+	}
+}
+
+// WithStorageBudget limits local storage (MB) used by video, the source is
+// copied into the storage once, the renditions are transcoded next to it.
+func WithStorageBudget(mb int) Option {
+	return func(codec *Codec) { codec.reader.storage = int64(mb) << 20 }
+}
+
+// WithIndex indexes published media by content hash, the index is required
+// for the takedown by hash
+func WithIndex(index *Index) Option {
+	return func(codec *Codec) { codec.index = index }
+}
+
+// WithModerator moderates media before its publication
+func WithModerator(moderator Moderator) Option {
+	return func(codec *Codec) { codec.moderator = moderator }
+}
+
+// WithDuplicates moderates near-duplicates of published media
+func WithDuplicates(duplicates *Duplicates) Option {
+	return func(codec *Codec) { codec.duplicate = duplicates }
+}
+
+// WithQuarantine holds media that requires review in the private storage
+func WithQuarantine(fsys WriterFS) Option {
+	return func(codec *Codec) { codec.quarantor = NewWriter(fsys) }
+}
+
+func NewCodec(profile medium.Profile, rfs ReaderFS, wfs WriterFS, emitter Emitters, opts ...Option) *Codec {
 	// defines HTTP client to download media objects
 	client := http.Client()
 	client.CheckRedirect = nil
 	stack := http.New(http.WithClient(client))
 
 	reader := NewReader(stack, rfs)
+	reader.posterAt = profile.Poster
+	reader.page = profile.Page
 
 	scaler := make([]*Scaler, len(profile.Resolutions))
 	for i, r := range profile.Resolutions {
-		scaler[i] = NewScaler(r)
+		scaler[i] = NewScaler(profile, r)
 	}
 
 	writer := NewWriter(wfs)
 
-	return &Codec{
-		reader:  reader,
-		scaler:  scaler,
-		writer:  writer,
-		emitter: emitter,
+	codec := &Codec{
+		reader:   reader,
+		scaler:   scaler,
+		layout:   profile,
+		writer:   writer,
+		emitter:  emitter,
+		profile:  profile.String(),
+		atomic:   profile.Atomic,
+		reemit:   profile.Reemit,
+		approval: profile.RequireApproval,
+		workers:  runtime.GOMAXPROCS(0),
+		cascaded: true,
+
+		animated:    profile.Animated,
+		maxFrames:   profile.MaxFrames,
+		maxDuration: profile.MaxDuration,
 	}
+
+	for _, opt := range opts {
+		opt(codec)
+	}
+	codec.reader.scale = codec.decodeScale
+	codec.reader.animates = codec.animates
+
+	switch {
+	case codec.duplicate == nil:
+	case codec.moderator == nil:
+		codec.moderator = codec.duplicate
+	default:
+		codec.moderator = Moderators{codec.moderator, codec.duplicate}
+	}
+
+	return codec
 }
 
 func (codec *Codec) Process(ctx context.Context, evt swarm.Msg[*events.S3EventRecord]) error {
+	if manifest := codec.publishedAs(evt); manifest != nil {
+		slog.Info("media is already published",
+			slog.String("path", manifest.Source),
+			slog.String("etag", manifest.ETag),
+		)
+
+		if codec.reemit {
+			codec.sink(ctx, evt, manifest)
+		}
+		return nil
+	}
+
+	if codec.scanner != nil {
+		if err := codec.scan(ctx, evt); err != nil {
+			return err
+		}
+	}
+
 	media, err := codec.reader.Get(ctx, evt)
 	if err != nil {
 		return errCodecIO.With(err)
 	}
+	defer media.release()
+	media.etag = evt.Object.S3.Object.ETag
+	codec.perceptualHash(media)
+
+	if manifest := codec.published(media); manifest != nil {
+		slog.Info("media is already published",
+			slog.String("path", media.path),
+			slog.String("hash", media.hash),
+		)
+
+		if codec.reemit {
+			codec.sink(ctx, evt, manifest)
+		}
+		return nil
+	}
+
+	review, labels := codec.approval, []string(nil)
+	if codec.moderator != nil {
+		decision, err := codec.moderator.Moderate(ctx, media)
+		if err != nil {
+			return errCodecIO.With(err)
+		}
+
+		switch decision.Verdict {
+		case MODERATION_DENY:
+			slog.Warn("media is denied by moderation",
+				slog.String("path", media.path),
+				slog.Any("labels", decision.Labels),
+			)
+			codec.sinkRejected(ctx, evt, "moderation", "", decision.Labels...)
+			return nil
+		case MODERATION_REVIEW:
+			review, labels = true, decision.Labels
+		}
+	}
+
+	if review {
+		return codec.hold(ctx, evt, media, labels)
+	}
+
+	var variants []string
+	if codec.atomic {
+		variants, err = codec.publishAtomic(ctx, media)
+	} else {
+		variants, err = codec.publish(ctx, media, codec.writer)
+	}
+
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+
+	manifest := codec.manifest(media, variants)
+	if err := codec.writer.PutManifest(ctx, manifest); err != nil {
+		// media is published, failure only disables the idempotency
+		slog.Warn("failed to write manifest",
+			slog.String("path", media.path),
+			"error", err,
+		)
+	}
+
+	if codec.index != nil {
+		if err := codec.index.Put(ctx, media.hash, media.path); err != nil {
+			// media is published, failure only disables the takedown by hash
+			slog.Warn("failed to write hash index",
+				slog.String("path", media.path),
+				"error", err,
+			)
+		}
+	}
+
+	if codec.duplicate != nil && manifest.PHash != "" {
+		if err := codec.duplicate.index.PutSimilar(ctx, Similar{PHash: manifest.PHash, Source: media.path}); err != nil {
+			// media is published, failure only disables the duplicate detection
+			slog.Warn("failed to write perceptual hash index",
+				slog.String("path", media.path),
+				"error", err,
+			)
+		}
+	}
+
+	codec.sink(ctx, evt, manifest)
+
+	return nil
+}
+
+// Manifest of variants published from media
+func (codec *Codec) manifest(media *Media, variants []string) *Manifest {
+	manifest := &Manifest{
+		Source:     media.path,
+		Hash:       media.hash,
+		ETag:       media.etag,
+		Profile:    codec.profile,
+		Variants:   variants,
+		Video:      media.video,
+		Renditions: codec.renditions(media),
+		Document:   media.document,
+		Srcset:     codec.srcset(media),
+	}
+
+	if len(manifest.Renditions) != 0 {
+		manifest.Playlist = codec.keyOf(media, "hls", "m3u8")
+	}
+
+	if media.audio != nil {
+		manifest.Audio = media.audio
+		manifest.Peaks = codec.keyOf(media, "peaks", "json")
+	}
+
+	if media.phash != nil {
+		manifest.PHash = media.phash.String()
+	}
+
+	return manifest
+}
+
+// Sidecar objects published along with variants of media
+func (codec *Codec) sidecars(media *Media) ([]Sidecar, error) {
+	var seq []Sidecar
+	if playlist := codec.playlist(media); playlist != nil {
+		seq = append(seq, *playlist)
+	}
+
+	if media.audio != nil {
+		data, err := json.Marshal(media.audio.peaks)
+		if err != nil {
+			return nil, errCodecIO.With(err)
+		}
+
+		seq = append(seq, Sidecar{
+			path: codec.keyOf(media, "peaks", "json"),
+			meta: &Meta{ContentType: "application/json"},
+			data: data,
+		})
+	}
+
+	return seq, nil
+}
 
+// key of sidecar object, it follows the layout of variants
+func (codec *Codec) keyOf(media *Media, label, format string) string {
+	return NewScaler(codec.layout, medium.Resolution{Label: label}).keyOf(media, format)
+}
+
+// Media is published if variants are produced from the same source by same
+// profile, it returns manifest of published media.
+func (codec *Codec) published(media *Media) *Manifest {
+	manifest, err := codec.writer.Manifest(media.path)
+	if err != nil {
+		return nil
+	}
+
+	if manifest.Hash != media.hash ||
+		manifest.Profile != codec.profile ||
+		!codec.writer.HasManifest(manifest) {
+		return nil
+	}
+
+	return manifest
+}
+
+// Media is published from the same source object if the ETag of uploaded
+// object matches the manifest. The check precedes the fetch and decode of
+// media, redelivered events are cheap.
+func (codec *Codec) publishedAs(evt swarm.Msg[*events.S3EventRecord]) *Manifest {
+	etag := evt.Object.S3.Object.ETag
+	if etag == "" {
+		return nil
+	}
+
+	path, err := pathOf(evt.Object)
+	if err != nil {
+		return nil
+	}
+
+	manifest, err := codec.writer.Manifest(path)
+	if err != nil {
+		return nil
+	}
+
+	if manifest.ETag != etag ||
+		manifest.Profile != codec.profile ||
+		!codec.writer.HasManifest(manifest) {
+		return nil
+	}
+
+	return manifest
+}
+
+// Publishes all variants using the transaction
+func (codec *Codec) publishAtomic(ctx context.Context, media *Media) ([]string, error) {
+	tx, err := codec.writer.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := codec.publish(ctx, media, tx)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// Publishes all variants, returns paths of published objects. Variants are
+// derived from larger variants following the cascade, the number of
+// concurrently running scalers and their memory is limited.
+func (codec *Codec) publish(ctx context.Context, media *Media, writer publisher) ([]string, error) {
 	var g errgroup.Group
 
-	for _, scaler := range codec.scaler {
-		s := scaler
+	parent := codec.cascade(media)
+	held, costs := codec.memoryOf(media, parent)
+	if err := codec.budget.fit(held + slices.Max(append(costs, 0))); err != nil {
+		return nil, err
+	}
+	if err := codec.fitStorage(media); err != nil {
+		return nil, err
+	}
+
+	done := make([]chan struct{}, len(codec.scaler))
+	larger := make([]*Media, len(codec.scaler))
+	slots := make(chan struct{}, max(1, codec.workers))
+	memory := semaphore.NewWeighted(codec.budget.available(held))
+
+	variants := make([]string, len(codec.scaler))
+	for i, scaler := range codec.scaler {
+		i, s := i, scaler
+		done[i] = make(chan struct{})
 
 		g.Go(func() error {
-			img, err := s.Process(ctx, media)
+			defer close(done[i])
+
+			var source *Media
+			if p := parent[i]; p != -1 {
+				<-done[p]
+				// failure of larger variant is reported by its scaler
+				if source = larger[p]; source == nil {
+					return nil
+				}
+			}
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			if err := memory.Acquire(ctx, costs[i]); err != nil {
+				return err
+			}
+			defer memory.Release(costs[i])
+
+			var img *Media
+			var err error
+			if source != nil {
+				slog.Debug("cascading media object",
+					slog.String("path", media.path),
+					slog.String("source", source.path),
+				)
+				img, err = s.scaleFrom(ctx, media, source)
+			} else {
+				img, err = s.Process(ctx, media)
+			}
 			if err != nil {
 				return err
 			}
+			defer img.release()
 
-			return codec.writer.Put(ctx, img)
+			if slices.Contains(parent, i) {
+				larger[i] = img
+			}
+
+			variants[i] = img.path
+			return writer.Put(ctx, img)
 		})
 	}
 
 	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	sidecars, err := codec.sidecars(media)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sidecar := range sidecars {
+		if err := writer.PutSidecar(ctx, sidecar); err != nil {
+			return nil, err
+		}
+	}
+
+	return variants, nil
+}
+
+// Remove all variants produced from the source object
+func (codec *Codec) Remove(ctx context.Context, evt swarm.Msg[*events.S3EventRecord]) error {
+	path, err := pathOf(evt.Object)
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+
+	manifest, err := codec.writer.Manifest(path)
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrNotExist) && codec.layout.ContentAddressed():
+		return ErrManifestNotFound.With(err, path)
+	case errors.Is(err, fs.ErrNotExist):
+		// media published without manifest, keys are derived from profile
+		media := &Media{path: path}
+		manifest = &Manifest{Source: path, Renditions: codec.renditions(media)}
+		for _, scaler := range codec.scaler {
+			manifest.Variants = append(manifest.Variants, scaler.pathOf(media))
+		}
+		if len(manifest.Renditions) != 0 {
+			manifest.Playlist = codec.keyOf(media, "hls", "m3u8")
+		}
+	default:
 		return errCodecIO.With(err)
 	}
 
-	codec.sink(ctx, evt)
+	slog.Debug("removing media object",
+		slog.String("path", path),
+		slog.Any("variants", manifest.Variants),
+	)
+
+	if err := codec.writer.Unpublish(ctx, manifest); err != nil {
+		return errCodecIO.With(err)
+	}
+
+	if codec.index != nil && manifest.Hash != "" {
+		if err := codec.index.Remove(ctx, manifest.Hash, manifest.Source); err != nil {
+			return err
+		}
+	}
+
+	if codec.duplicate != nil && manifest.PHash != "" {
+		if err := codec.duplicate.index.RemoveSimilar(ctx, manifest.PHash, manifest.Source); err != nil {
+			return err
+		}
+	}
+
+	if codec.quarantor != nil {
+		if err := codec.release(ctx, path); err != nil {
+			return errCodecIO.With(err)
+		}
+	}
+
+	codec.sinkRemoved(ctx, evt, manifest.Objects())
 
 	return nil
 }
 
-func (codec *Codec) sink(ctx context.Context, evt swarm.Msg[*events.S3EventRecord]) {
-	if codec.emitter == nil {
+func (codec *Codec) sink(ctx context.Context, evt swarm.Msg[*events.S3EventRecord], manifest *Manifest) {
+	if codec.emitter.Published == nil {
 		return
 	}
 
-	event := MediaPublished{S3EventRecord: *evt.Object}
+	event := MediaPublished{
+		S3EventRecord: mediaRecordOf(evt.Object),
+		PHash:         manifest.PHash,
+		Video:         manifest.Video,
+		Renditions:    make([]Rendition, len(manifest.Renditions)),
+		Playlist:      strings.TrimPrefix(manifest.Playlist, "/"),
+		Audio:         manifest.Audio,
+		Peaks:         strings.TrimPrefix(manifest.Peaks, "/"),
+		Document:      manifest.Document,
+	}
 
-	event.S3.Bucket.Name = os.Getenv("CONFIG_STORE_MEDIA")
-	event.S3.Bucket.Arn = strings.ReplaceAll(event.S3.Bucket.Arn, os.Getenv("CONFIG_STORE_INBOX"), os.Getenv("CONFIG_STORE_MEDIA"))
+	if len(manifest.Srcset) != 0 {
+		event.Srcset = map[string]*Srcset{}
+		for set, candidates := range manifest.Srcset {
+			event.Srcset[set] = newSrcset(candidates)
+		}
+	}
+
+	for i, r := range manifest.Renditions {
+		r.Key = strings.TrimPrefix(r.Key, "/")
+		r.Playlist = strings.TrimPrefix(r.Playlist, "/")
+		event.Renditions[i] = r
+	}
 
 	event.Variants = make([]string, len(codec.scaler))
 	for i, scaler := range codec.scaler {
 		event.Variants[i] = scaler.resolution.String()
 	}
 
-	codec.emitter.Enq(ctx, event)
+	event.Keys = make([]string, len(manifest.Variants))
+	for i, path := range manifest.Variants {
+		event.Keys[i] = strings.TrimPrefix(path, "/")
+	}
+
+	codec.emitter.Published.Enq(ctx, event)
+}
+
+func (codec *Codec) sinkRemoved(ctx context.Context, evt swarm.Msg[*events.S3EventRecord], variants []string) {
+	if codec.emitter.Removed == nil {
+		return
+	}
+
+	event := MediaRemoved{
+		S3EventRecord: mediaRecordOf(evt.Object),
+		Keys:          make([]string, len(variants)),
+	}
+
+	for i, path := range variants {
+		event.Keys[i] = strings.TrimPrefix(path, "/")
+	}
+
+	codec.emitter.Removed.Enq(ctx, event)
+}
+
+// S3 event record of inbox is relocated to media bucket
+func mediaRecordOf(evt *events.S3EventRecord) events.S3EventRecord {
+	record := *evt
+	record.S3.Bucket.Name = os.Getenv("CONFIG_STORE_MEDIA")
+	record.S3.Bucket.Arn = strings.ReplaceAll(record.S3.Bucket.Arn, os.Getenv("CONFIG_STORE_INBOX"), os.Getenv("CONFIG_STORE_MEDIA"))
+	return record
+}
+
+// IsRemoved checks if S3 event removes the source object. The source object
+// is removed either by deleting it or uploading empty object (tombstone).
+func IsRemoved(evt *events.S3EventRecord) bool {
+	return strings.HasPrefix(evt.EventName, "ObjectRemoved") ||
+		(strings.HasPrefix(evt.EventName, "ObjectCreated") && evt.S3.Object.Size == 0)
 }
@@ -0,0 +1,369 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+	"github.com/fogfish/swarm"
+)
+
+func TestCodec(t *testing.T) {
+	profile := medium.On("a", "").Process(
+		medium.ScaleTo("small", 4, 4),
+		medium.ScaleTo("large", 8, 8),
+		medium.Replica("origin"),
+	)
+
+	t.Run("Process", func(t *testing.T) {
+		rfs, wfs := newMockInbox(t, "/a/b.jpg"), newMockFS()
+		err := NewCodec(profile, rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+			it.True(wfs.Has("/a/b.large-8x8.jpg")),
+			it.True(wfs.Has("/a/b.origin.jpg")),
+		)
+	})
+
+	t.Run("ProcessFailed", func(t *testing.T) {
+		rfs, wfs := newMockInbox(t, "/a/b.jpg"), newMockFS()
+		wfs.failAt = "large"
+		wfs.failClose = errors.New("close")
+		err := NewCodec(profile, rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("codec I/O error"),
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+			it.True(wfs.Has("/a/b.origin.jpg")),
+		)
+	})
+
+	t.Run("ProcessAtomic", func(t *testing.T) {
+		rfs, wfs := newMockInbox(t, "/a/b.jpg"), newMockFS()
+		err := NewCodec(profile.Atomically(), rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+			it.True(wfs.Has("/a/b.large-8x8.jpg")),
+			it.True(wfs.Has("/a/b.origin.jpg")),
+			it.True(wfs.Has("/a/b.manifest.json")),
+			it.Equal(wfs.Len(), 4),
+		)
+	})
+
+	t.Run("ProcessAtomicFailed", func(t *testing.T) {
+		rfs, wfs := newMockInbox(t, "/a/b.jpg"), newMockFS()
+		wfs.failAt = "large"
+		wfs.failClose = errors.New("close")
+		err := NewCodec(profile.Atomically(), rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("codec I/O error"),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("ProcessIdempotent", func(t *testing.T) {
+		rfs, wfs := newMockInbox(t, "/a/b.jpg"), newMockFS()
+		codec := NewCodec(profile, rfs, wfs, Emitters{})
+
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+			it.True(wfs.Has("/a/b.manifest.json")),
+		)
+
+		// re-encoding would fail
+		wfs.failClose = errors.New("close")
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+		)
+
+		// source is changed
+		rfs.Put("/a/b.jpg", newMockJpeg(t, 12, 16))
+		it.Then(t).ShouldNot(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+		)
+	})
+
+	t.Run("ProcessIdempotentETag", func(t *testing.T) {
+		rfs, wfs := newMockInbox(t, "/a/b.jpg"), newMockFS()
+		codec := NewCodec(profile, rfs, wfs, Emitters{})
+
+		evt := newMockEvent("a/b.jpg")
+		evt.Object.S3.Object.ETag = "etag"
+
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), evt)),
+			it.True(wfs.Has("/a/b.manifest.json")),
+		)
+
+		// source is not decoded if ETag is published
+		rfs.Put("/a/b.jpg", []byte("corrupted"))
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), evt)),
+		)
+
+		// source is changed
+		evt.Object.S3.Object.ETag = "other"
+		it.Then(t).ShouldNot(
+			it.Nil(codec.Process(context.Background(), evt)),
+		)
+	})
+
+	t.Run("ProcessOutputKey", func(t *testing.T) {
+		rfs, wfs, emitter := newMockInbox(t, "/a/b.jpg"), newMockFS(), &mockEmitter[MediaPublished]{}
+		codec := NewCodec(profile.OutputTo("{prefix}/{sha256[:8]}.{label}.{ext}"), rfs, wfs, Emitters{Published: emitter})
+		err := codec.Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		addr := newMockHash(rfs, "/a/b.jpg")[:8]
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(len(emitter.events), 1),
+			it.Seq(emitter.events[0].Keys).Equal(
+				"a/"+addr+".small.jpg",
+				"a/"+addr+".large.jpg",
+				"a/"+addr+".origin.jpg",
+			),
+			it.True(wfs.Has("/a/"+addr+".small.jpg")),
+		)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		rfs, wfs, ifs, emitter := newMockInbox(t, "/a/b.jpg"), newMockFS(), newMockFS(), &mockEmitter[MediaRemoved]{}
+		codec := NewCodec(profile.OutputTo("{prefix}/{sha256[:8]}.{label}.{ext}"), rfs, wfs, Emitters{Removed: emitter},
+			WithIndex(NewIndex(ifs)),
+		)
+
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+			it.Equal(wfs.Len(), 4),
+			it.Equal(ifs.Len(), 1),
+		)
+
+		err := codec.Remove(context.Background(), newMockEvent("a/b.jpg"))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(wfs.Len(), 0),
+			it.Equal(ifs.Len(), 0),
+			it.Equal(len(emitter.events), 1),
+			it.Equal(len(emitter.events[0].Keys), 3),
+		)
+	})
+
+	t.Run("RemoveWithoutManifest", func(t *testing.T) {
+		rfs, wfs := newMockInbox(t, "/a/b.jpg"), newMockFS()
+		codec := NewCodec(profile, rfs, wfs, Emitters{})
+
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+			it.Nil(wfs.Remove("/a/b.manifest.json")),
+			it.Equal(wfs.Len(), 3),
+		)
+
+		err := codec.Remove(context.Background(), newMockEvent("a/b.jpg"))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("RemoveContentAddressedWithoutManifest", func(t *testing.T) {
+		rfs, wfs, emitter := newMockInbox(t, "/a/b.jpg"), newMockFS(), &mockEmitter[MediaRemoved]{}
+		codec := NewCodec(profile.OutputTo("{prefix}/{sha256[:8]}.{label}.{ext}"), rfs, wfs, Emitters{Removed: emitter})
+
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+			it.Nil(wfs.Remove("/a/b.manifest.json")),
+		)
+		n := wfs.Len()
+
+		err := codec.Remove(context.Background(), newMockEvent("a/b.jpg"))
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("manifest not found"),
+			it.Equal(wfs.Len(), n),
+			it.Equal(len(emitter.events), 0),
+		)
+	})
+
+	t.Run("ProcessBlocked", func(t *testing.T) {
+		rfs, wfs, bfs := newMockInbox(t, "/a/b.jpg"), newMockFS(), newMockFS()
+		emitter := &mockEmitter[MediaRejected]{}
+		blocklist := NewBlocklist(bfs)
+		codec := NewCodec(profile, rfs, wfs, Emitters{Rejected: emitter}, WithModerator(Rules{Blocklist: blocklist}))
+
+		it.Then(t).Should(
+			it.Nil(blocklist.Add(context.Background(), newMockHash(rfs, "/a/b.jpg"), "test")),
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+			it.Equal(wfs.Len(), 0),
+			it.Equal(len(emitter.events), 1),
+			it.Equal(emitter.events[0].Reason, "moderation"),
+			it.Seq(emitter.events[0].Labels).Equal("blocked"),
+		)
+	})
+
+	t.Run("ProcessQuarantine", func(t *testing.T) {
+		rfs, wfs, qfs := newMockInbox(t, "/a/b.jpg"), newMockFS(), newMockFS()
+		emitter := &mockEmitter[MediaPendingReview]{}
+		codec := NewCodec(profile.WithApproval(), rfs, wfs, Emitters{PendingReview: emitter}, WithQuarantine(qfs))
+
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+			it.Equal(wfs.Len(), 0),
+			it.True(qfs.Has("/a/b.small-4x4.jpg")),
+			it.True(qfs.Has("/a/b.review.json")),
+			it.Equal(len(emitter.events), 1),
+			it.Equal(len(emitter.events[0].Keys), 3),
+		)
+
+		review, err := NewWriter(qfs).Review("/a/b.jpg")
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(review.State, REVIEW_PENDING),
+			it.Equal(review.Hash, newMockHash(rfs, "/a/b.jpg")),
+		)
+
+		it.Then(t).Should(
+			it.Nil(codec.Remove(context.Background(), newMockEvent("a/b.jpg"))),
+			it.Equal(qfs.Len(), 0),
+		)
+	})
+
+	t.Run("ProcessQuarantineNotConfigured", func(t *testing.T) {
+		rfs, wfs := newMockInbox(t, "/a/b.jpg"), newMockFS()
+		codec := NewCodec(profile.WithApproval(), rfs, wfs, Emitters{})
+
+		it.Then(t).Should(
+			it.Fail(func() error {
+				return codec.Process(context.Background(), newMockEvent("a/b.jpg"))
+			}).Contain("quarantine"),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("Promote", func(t *testing.T) {
+		rfs, wfs, qfs := newMockInbox(t, "/a/b.jpg"), newMockFS(), newMockFS()
+		codec := NewCodec(profile.WithApproval(), rfs, wfs, Emitters{}, WithQuarantine(qfs))
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+		)
+
+		review, err := NewWriter(qfs).Review("/a/b.jpg")
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Nil(NewWriter(wfs).Promote(context.Background(), NewWriter(qfs), &review.Manifest)),
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+			it.True(wfs.Has("/a/b.manifest.json")),
+			it.Equal(wfs.meta["/a/b.small-4x4.jpg"].ContentType, "image/jpeg"),
+			it.Equal(wfs.meta["/a/b.origin.jpg"].ContentType, "image/jpeg"),
+		)
+	})
+
+	t.Run("PromoteFailed", func(t *testing.T) {
+		rfs, wfs, qfs := newMockInbox(t, "/a/b.jpg"), newMockFS(), newMockFS()
+		codec := NewCodec(profile.WithApproval(), rfs, wfs, Emitters{}, WithQuarantine(qfs))
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+		)
+
+		wfs.Put("/a/b.small-4x4.jpg", []byte("previous"))
+		wfs.failPath = "/a/b.origin.jpg"
+		wfs.failClose = errors.New("close")
+
+		review, err := NewWriter(qfs).Review("/a/b.jpg")
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Fail(func() error {
+				return NewWriter(wfs).Promote(context.Background(), NewWriter(qfs), &review.Manifest)
+			}).Contain("codec I/O error"),
+			it.Equal(string(wfs.files["/a/b.small-4x4.jpg"]), "previous"),
+			it.Equal(wfs.Has("/a/b.origin.jpg"), false),
+			it.Equal(wfs.Has("/a/b.manifest.json"), false),
+			it.Equal(wfs.Len(), 1),
+		)
+	})
+}
+
+func TestIsRemoved(t *testing.T) {
+	for name, expect := range map[string]bool{
+		"ObjectCreated:Put":                 false,
+		"ObjectRemoved:Delete":              true,
+		"ObjectRemoved:DeleteMarkerCreated": true,
+		"LifecycleExpiration:Delete":        false,
+	} {
+		evt := newMockEvent("a/b.jpg")
+		evt.Object.EventName = name
+		evt.Object.S3.Object.Size = 1
+		it.Then(t).Should(
+			it.Equal(IsRemoved(evt.Object), expect),
+		)
+	}
+
+	tombstone := newMockEvent("a/b.jpg")
+	tombstone.Object.EventName = "ObjectCreated:Put"
+	it.Then(t).Should(
+		it.True(IsRemoved(tombstone.Object)),
+	)
+}
+
+//------------------------------------------------------------------------------
+
+func newMockInbox(t *testing.T, path string) *mockFS {
+	t.Helper()
+
+	fsys := newMockFS()
+	fsys.Put(path, newMockJpeg(t, 16, 12))
+	return fsys
+}
+
+func newMockHash(fsys *mockFS, path string) string {
+	hash := sha256.Sum256(fsys.files[path])
+	return hex.EncodeToString(hash[:])
+}
+
+func newMockJpeg(t testing.TB, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+type mockEmitter[T any] struct {
+	events []T
+}
+
+func (e *mockEmitter[T]) Enq(_ context.Context, evt T, _ ...string) error {
+	e.events = append(e.events, evt)
+	return nil
+}
+
+func newMockEvent(key string) swarm.Msg[*events.S3EventRecord] {
+	var evt events.S3EventRecord
+	evt.S3.Bucket.Name = "inbox"
+	evt.S3.Object.Key = key
+
+	return swarm.Msg[*events.S3EventRecord]{Object: &evt}
+}
@@ -0,0 +1,155 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Djpeg decodes JPEG at scale using djpeg subprocess of libjpeg(-turbo)
+type Djpeg struct {
+	bin string
+}
+
+// NewDjpeg creates decoder using djpeg binary (e.g. /opt/bin/djpeg)
+func NewDjpeg(bin string) *Djpeg {
+	return &Djpeg{bin: bin}
+}
+
+func (d *Djpeg) DecodeScaled(ctx context.Context, jpeg io.Reader, denom int) (image.Image, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, d.bin,
+		"-scale", "1/"+strconv.Itoa(denom),
+		"-pnm",
+	)
+	cmd.Stdin = jpeg
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("djpeg: %w", err)
+	}
+
+	img, err := decodePnm(bufio.NewReader(stdout))
+	if err != nil {
+		io.Copy(io.Discard, stdout)
+	}
+
+	if werr := cmd.Wait(); werr != nil {
+		return nil, fmt.Errorf("djpeg: %w: %s", werr, strings.TrimSpace(stderr.String()))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("djpeg: %w", err)
+	}
+
+	return img, nil
+}
+
+//------------------------------------------------------------------------------
+
+// decodes binary PNM image, either PGM (P5) or PPM (P6) with 8-bit samples
+func decodePnm(r *bufio.Reader) (image.Image, error) {
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("pnm: %w", err)
+	}
+	if magic[0] != 'P' || (magic[1] != '5' && magic[1] != '6') {
+		return nil, fmt.Errorf("pnm: unsupported format")
+	}
+
+	var header [3]int
+	for i := range header {
+		v, err := pnmInt(r)
+		if err != nil {
+			return nil, err
+		}
+		header[i] = v
+	}
+
+	w, h, maxval := header[0], header[1], header[2]
+	if w <= 0 || h <= 0 || maxval != 255 {
+		return nil, fmt.Errorf("pnm: unsupported header %dx%d (%d)", w, h, maxval)
+	}
+
+	// single whitespace separates header and raster
+	if _, err := r.ReadByte(); err != nil {
+		return nil, fmt.Errorf("pnm: %w", err)
+	}
+
+	if magic[1] == '5' {
+		img := image.NewGray(image.Rect(0, 0, w, h))
+		if _, err := io.ReadFull(r, img.Pix); err != nil {
+			return nil, fmt.Errorf("pnm: %w", err)
+		}
+		return img, nil
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	row := make([]byte, 3*w)
+	for y := 0; y < h; y++ {
+		if _, err := io.ReadFull(r, row); err != nil {
+			return nil, fmt.Errorf("pnm: %w", err)
+		}
+
+		pix := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			pix[4*x], pix[4*x+1], pix[4*x+2], pix[4*x+3] = row[3*x], row[3*x+1], row[3*x+2], 0xff
+		}
+	}
+
+	return img, nil
+}
+
+// reads decimal value of header, whitespaces and comments are skipped
+func pnmInt(r *bufio.Reader) (int, error) {
+	var digits []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("pnm: %w", err)
+		}
+
+		switch {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+			if len(digits) > 9 {
+				return 0, fmt.Errorf("pnm: invalid header")
+			}
+			continue
+		case c == '#' && len(digits) == 0:
+			if _, err := r.ReadBytes('\n'); err != nil {
+				return 0, fmt.Errorf("pnm: %w", err)
+			}
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			if len(digits) == 0 {
+				continue
+			}
+			if err := r.UnreadByte(); err != nil {
+				return 0, err
+			}
+			return strconv.Atoi(string(digits))
+		default:
+			return 0, fmt.Errorf("pnm: invalid header")
+		}
+	}
+}
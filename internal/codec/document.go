@@ -0,0 +1,242 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"regexp"
+	"strconv"
+)
+
+// Document metadata
+type Document struct {
+	Format string `json:"format"` // pdf
+	Pages  int    `json:"pages"`
+}
+
+func (d *Document) ContentType() string {
+	return "application/" + d.Format
+}
+
+// PageRasterizer renders the page (1-based) of PDF document
+type PageRasterizer interface {
+	Page(ctx context.Context, pdf io.Reader, page int) (image.Image, error)
+}
+
+// size limit of documents
+const documentLimit = 256 << 20
+
+// size limit of inflated object streams of the document
+const pdfInflateLimit = 64 << 20
+
+// fetches document media, the image is the rendered page
+func (r Reader) fetchMediaDocument(ctx context.Context, path string) (*Media, error) {
+	if r.pages == nil {
+		return nil, errCodecNotSupported.With(nil, MEDIA_DOCUMENT)
+	}
+
+	fd, err := r.fsys.Open(path)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	defer fd.Close()
+
+	// document is held in memory, it is not read beyond the budget
+	limit := int64(documentLimit)
+	if r.budget.limit != 0 {
+		limit = min(limit, r.budget.limit)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(fd, limit+1))
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	if len(data) > documentLimit {
+		return nil, errCodecIO.With(fmt.Errorf("pdf: document exceeds %d bytes", documentLimit))
+	}
+	if err := r.budget.fit(int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	inflate := min(pdfInflateLimit, r.budget.available(int64(len(data))))
+	pages, err := pdfPageCount(data, inflate)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	page := r.page
+	if page < 1 || page > pages {
+		page = 1
+	}
+
+	img, err := r.pages.Page(ctx, bytes.NewReader(data), page)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	// page size is known after rendering
+	if err := r.budget.fit(int64(len(data)) + sizeOfImage(img)); err != nil {
+		return nil, err
+	}
+
+	document := &Document{Format: "pdf", Pages: pages}
+
+	slog.Debug("document metadata",
+		slog.String("path", path),
+		slog.Any("document", document),
+	)
+
+	hash := sha256.Sum256(data)
+
+	return &Media{
+		path:     path,
+		hash:     hex.EncodeToString(hash[:]),
+		image:    img,
+		document: document,
+		origin:   &Origin{fsys: r.fsys, path: path, contentType: document.ContentType()},
+	}, nil
+}
+
+//------------------------------------------------------------------------------
+
+var (
+	// dictionary of page tree node, the root node counts all pages
+	pdfPages = regexp.MustCompile(`/Type\s*/Pages\b`)
+	pdfCount = regexp.MustCompile(`/Count\s+(\d+)`)
+
+	// compressed stream of objects (PDF 1.5)
+	pdfObjStm  = regexp.MustCompile(`/Type\s*/ObjStm\b`)
+	pdfFirst   = regexp.MustCompile(`/First\s+(\d+)`)
+	pdfStream  = regexp.MustCompile(`stream\r?\n`)
+	pdfObjects = regexp.MustCompile(`\d+\s+\d+\s+obj\b`)
+)
+
+// Counts pages of PDF document. The page tree root counts all pages of
+// the document, it has the largest count among page tree nodes. Nodes are
+// either plain objects or compressed into object streams, total size of
+// inflated streams is limited.
+func pdfPageCount(data []byte, inflate int64) (int, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return 0, fmt.Errorf("pdf: invalid header")
+	}
+
+	count := pdfCountPages(bytes.Split(data, []byte("endobj")))
+
+	for _, loc := range pdfStream.FindAllIndex(data, -1) {
+		if inflate <= 0 {
+			slog.Warn("pdf object streams exceed the limit, page count is approximated")
+			break
+		}
+
+		if bytes.HasSuffix(data[:loc[0]], []byte("end")) {
+			continue
+		}
+
+		dict := pdfDictionary(data[max(0, loc[0]-pdfDictionaryLimit):loc[0]])
+		if !pdfObjStm.Match(dict) || !bytes.Contains(dict, []byte("/FlateDecode")) {
+			continue
+		}
+
+		stream, err := zlib.NewReader(bytes.NewReader(data[loc[1]:]))
+		if err != nil {
+			continue
+		}
+
+		objects, err := io.ReadAll(io.LimitReader(stream, inflate))
+		inflate -= int64(len(objects))
+		if err != nil && len(objects) == 0 {
+			continue
+		}
+
+		count = max(count, pdfCountPages(pdfObjStmObjects(dict, objects)))
+	}
+
+	if count == 0 {
+		return 0, fmt.Errorf("pdf: page tree not found")
+	}
+
+	return count, nil
+}
+
+// size limit of stream dictionary
+const pdfDictionaryLimit = 4096
+
+// dictionary of the stream, it is the text between object header and stream
+func pdfDictionary(data []byte) []byte {
+	loc := pdfObjects.FindAllIndex(data, -1)
+	if len(loc) == 0 {
+		return nil
+	}
+
+	return data[loc[len(loc)-1][1]:]
+}
+
+// objects of object stream, the header of stream is pairs of object number
+// and offset relative to the first object, offsets are increasing.
+func pdfObjStmObjects(dict []byte, data []byte) [][]byte {
+	m := pdfFirst.FindSubmatch(dict)
+	if m == nil {
+		return nil
+	}
+
+	first, err := strconv.Atoi(string(m[1]))
+	if err != nil || first > len(data) {
+		return nil
+	}
+
+	header := bytes.Fields(data[:first])
+	offsets := make([]int, 0, len(header)/2)
+	for i := 1; i < len(header); i += 2 {
+		offset, err := strconv.Atoi(string(header[i]))
+		if err != nil || offset < 0 || offset > len(data)-first {
+			return nil
+		}
+		if len(offsets) > 0 && first+offset <= offsets[len(offsets)-1] {
+			return nil
+		}
+		offsets = append(offsets, first+offset)
+	}
+
+	objects := make([][]byte, len(offsets))
+	for i, offset := range offsets {
+		end := len(data)
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+		objects[i] = data[offset:end]
+	}
+
+	return objects
+}
+
+// largest count of page tree nodes
+func pdfCountPages(objects [][]byte) int {
+	count := 0
+	for _, obj := range objects {
+		if !pdfPages.Match(obj) {
+			continue
+		}
+
+		for _, m := range pdfCount.FindAllSubmatch(obj, -1) {
+			if n, err := strconv.Atoi(string(m[1])); err == nil {
+				count = max(count, n)
+			}
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,263 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestPdfPageCount(t *testing.T) {
+	t.Run("Plain", func(t *testing.T) {
+		pages, err := pdfPageCount(newMockPdf(3), pdfInflateLimit)
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(pages, 3),
+		)
+	})
+
+	t.Run("ObjectStream", func(t *testing.T) {
+		pages, err := pdfPageCount(newMockPdfObjStm(7), pdfInflateLimit)
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(pages, 7),
+		)
+	})
+
+	t.Run("InflateLimit", func(t *testing.T) {
+		_, err := pdfPageCount(newMockPdfObjStm(7), 16)
+		it.Then(t).ShouldNot(it.Nil(err))
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		for _, input := range [][]byte{
+			nil,
+			[]byte("%PNG"),
+			[]byte("%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\n%%EOF"),
+		} {
+			_, err := pdfPageCount(input, pdfInflateLimit)
+			it.Then(t).ShouldNot(it.Nil(err))
+		}
+	})
+}
+
+func TestPdfObjStmObjects(t *testing.T) {
+	dict := []byte("/Type /ObjStm /N 2 /First 8")
+
+	t.Run("Objects", func(t *testing.T) {
+		objects := pdfObjStmObjects(dict, []byte("1 0 2 3 abcdef"))
+		it.Then(t).Should(
+			it.Equal(len(objects), 2),
+			it.Equal(string(objects[0]), "abc"),
+			it.Equal(string(objects[1]), "def"),
+		)
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		for _, input := range []string{
+			"1 0 2 -3 abcdef",
+			"1 3 2 3 abcdef",
+			"1 3 2 0 abcdef",
+			"1 0 2 9 abcdef",
+		} {
+			it.Then(t).Should(
+				it.Equal(len(pdfObjStmObjects(dict, []byte(input))), 0),
+			)
+		}
+	})
+}
+
+func TestCodecDocument(t *testing.T) {
+	profile := medium.On("a", "").
+		Process(
+			medium.ScaleTo("small", 4, 4),
+			medium.Replica("origin"),
+		)
+
+	t.Run("CoverPage", func(t *testing.T) {
+		source := newMockPdf(3)
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.pdf", source)
+		rasterizer := &mockPageRasterizer{}
+		emitter := &mockEmitter[MediaPublished]{}
+
+		codec := NewCodec(profile.CoverPage(2), rfs, wfs, Emitters{Published: emitter}, WithPageRasterizer(rasterizer))
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.pdf"))),
+			it.Seq(rasterizer.pages).Equal(2),
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+			it.Equal(string(wfs.files["/a/b.origin.pdf"]), string(source)),
+			it.Equal(wfs.meta["/a/b.origin.pdf"].ContentType, "application/pdf"),
+			it.Equal(len(emitter.events), 1),
+			it.Equiv(emitter.events[0].Document, &Document{Format: "pdf", Pages: 3}),
+		)
+	})
+
+	t.Run("CoverPageOutOfRange", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.pdf", newMockPdfObjStm(2))
+		rasterizer := &mockPageRasterizer{}
+
+		codec := NewCodec(profile.CoverPage(5), rfs, wfs, Emitters{}, WithPageRasterizer(rasterizer))
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.pdf"))),
+			it.Seq(rasterizer.pages).Equal(1),
+		)
+	})
+
+	t.Run("MemoryBudget", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.pdf", append(newMockPdf(1), make([]byte, 2<<20)...))
+		rasterizer := &mockPageRasterizer{}
+
+		codec := NewCodec(profile, rfs, wfs, Emitters{}, WithPageRasterizer(rasterizer), WithMemoryBudget(1))
+		it.Then(t).Should(
+			it.Fail(func() error { return codec.Process(context.Background(), newMockEvent("a/b.pdf")) }).Contain("memory"),
+			it.Equal(len(rasterizer.pages), 0),
+		)
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.pdf", newMockPdf(1))
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.pdf"))
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("document"),
+		)
+	})
+}
+
+func TestPoppler(t *testing.T) {
+	poppler := func(script string) *Poppler {
+		bin := filepath.Join(t.TempDir(), "pdftoppm")
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return NewPoppler(bin)
+	}
+
+	t.Run("Page", func(t *testing.T) {
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 6)))
+		page := filepath.Join(t.TempDir(), "page.png")
+		os.WriteFile(page, buf.Bytes(), 0644)
+
+		f := poppler(`[ "$2" = "3" ] && [ "$4" = "3" ] && [ "$(head -c 5)" = "%PDF-" ] && cat ` + page)
+
+		img, err := f.Page(context.Background(), bytes.NewReader(newMockPdf(3)), 3)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 4, 6)),
+		)
+	})
+
+	t.Run("PixelLimit", func(t *testing.T) {
+		// header of the page declares 8192x8192 pixels
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 6)))
+		data := buf.Bytes()
+		binary.BigEndian.PutUint32(data[16:], 8192)
+		binary.BigEndian.PutUint32(data[20:], 8192)
+		binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+		page := filepath.Join(t.TempDir(), "page.png")
+		os.WriteFile(page, data, 0644)
+
+		f := poppler(`cat ` + page)
+
+		_, err := f.Page(context.Background(), bytes.NewReader(newMockPdf(1)), 1)
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("exceeds"),
+		)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		f := poppler(`echo "invalid page" >&2; exit 1`)
+
+		_, err := f.Page(context.Background(), strings.NewReader("pdf"), 1)
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("invalid page"),
+		)
+	})
+}
+
+// rasterizer renders 4x6 pages
+type mockPageRasterizer struct {
+	pages []int
+}
+
+func (r *mockPageRasterizer) Page(_ context.Context, pdf io.Reader, page int) (image.Image, error) {
+	if _, err := io.ReadAll(pdf); err != nil {
+		return nil, err
+	}
+
+	r.pages = append(r.pages, page)
+	return image.NewRGBA(image.Rect(0, 0, 4, 6)), nil
+}
+
+// PDF with page tree of two levels and outlines
+func newMockPdf(pages int) []byte {
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	pdf.WriteString("1 0 obj\n<< /Type /Catalog /Pages 2 0 R /Outlines 3 0 R >>\nendobj\n")
+	fmt.Fprintf(&pdf, "2 0 obj\n<< /Type /Pages /Kids [4 0 R] /Count %d >>\nendobj\n", pages)
+	fmt.Fprintf(&pdf, "3 0 obj\n<< /Type /Outlines /Count %d >>\nendobj\n", 10*pages)
+	fmt.Fprintf(&pdf, "4 0 obj\n<</Type/Pages/Parent 2 0 R/Count %d>>\nendobj\n", pages-1)
+	pdf.WriteString("5 0 obj\n<< /Length 4 >>\nstream\nq Q\nendstream\nendobj\n")
+	pdf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+// PDF with page tree compressed into object stream
+func newMockPdfObjStm(pages int) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R /Outlines 3 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [] /Count %d >>", pages),
+		fmt.Sprintf("<< /Type /Outlines /Count %d >>", 10*pages),
+	}
+
+	var header, body bytes.Buffer
+	for i, obj := range objects {
+		fmt.Fprintf(&header, "%d %d ", i+1, body.Len())
+		body.WriteString(obj + "\n")
+	}
+
+	var stream bytes.Buffer
+	w := zlib.NewWriter(&stream)
+	w.Write(header.Bytes())
+	w.Write(body.Bytes())
+	w.Close()
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.5\n")
+	pdf.WriteString("4 0 obj\n<< /Length 4 >>\nstream\nq Q\nendstream\nendobj\n")
+	fmt.Fprintf(&pdf, "5 0 obj\n<< /Type /ObjStm /N %d /First %d /Filter /FlateDecode /Length %d >>\nstream\n",
+		len(objects), header.Len(), stream.Len(),
+	)
+	pdf.Write(stream.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n")
+	pdf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
@@ -0,0 +1,140 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fogfish/medium"
+)
+
+// FFmpeg transcodes video using ffmpeg subprocess. The video is cropped to
+// fill the resolution, encoded as H.264/AAC and segmented into single file
+// fragmented MP4 addressed by HLS playlist. It also extracts poster frames.
+type FFmpeg struct {
+	bin     string
+	segment int // duration of HLS segment, seconds
+}
+
+// NewFFmpeg creates transcoder using ffmpeg binary (e.g. /opt/bin/ffmpeg)
+func NewFFmpeg(bin string) *FFmpeg {
+	return &FFmpeg{bin: bin, segment: 6}
+}
+
+func (f *FFmpeg) Transcode(ctx context.Context, video io.Reader, rendition medium.Resolution, dir string) error {
+	source, release, err := localSource(video, dir)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, f.bin, f.args(source, rendition, dir)...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// Frame extracts the frame at the timestamp as coded, without rotation
+func (f *FFmpeg) Frame(ctx context.Context, video io.Reader, at time.Duration) (image.Image, error) {
+	dir, err := os.MkdirTemp("", "frame-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	source, release, err := localSource(video, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, f.bin,
+		"-hide_banner", "-loglevel", "error", "-nostdin", "-noautorotate",
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64),
+		"-i", source,
+		"-frames:v", "1", "-f", "image2pipe", "-c:v", "png", "-",
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return png.Decode(&stdout)
+}
+
+func (f *FFmpeg) args(source string, rendition medium.Resolution, dir string) []string {
+	w, h := strconv.Itoa(rendition.Width), strconv.Itoa(rendition.Height)
+	bitrate := strconv.Itoa(rendition.Bitrate) + "k"
+	bufsize := strconv.Itoa(2*rendition.Bitrate) + "k"
+
+	return []string{
+		"-hide_banner", "-loglevel", "error", "-nostdin", "-y",
+		"-i", source,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", "scale=" + w + ":" + h + ":force_original_aspect_ratio=increase,crop=" + w + ":" + h + ",setsar=1",
+		"-c:v", "libx264", "-profile:v", "high", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-b:v", bitrate, "-maxrate", bitrate, "-bufsize", bufsize,
+		"-c:a", "aac", "-b:a", strconv.Itoa(renditionAudioBitrate) + "k", "-ac", "2",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(f.segment),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", "fmp4",
+		"-hls_flags", "single_file+independent_segments",
+		"-hls_segment_filename", filepath.Join(dir, renditionVideo),
+		filepath.Join(dir, renditionPlaylist),
+	}
+}
+
+// ffmpeg requires seekable input to read trailing metadata (e.g. moov box),
+// the local file is used as is, other sources are copied into the directory.
+func localSource(video io.Reader, dir string) (string, func(), error) {
+	if fd, ok := video.(*os.File); ok {
+		return fd.Name(), func() {}, nil
+	}
+
+	source := filepath.Join(dir, "source")
+	if err := copyToFile(source, video); err != nil {
+		return "", nil, err
+	}
+
+	return source, func() { os.Remove(source) }, nil
+}
+
+func copyToFile(path string, r io.Reader) error {
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(fd, r); err != nil {
+		fd.Close()
+		return err
+	}
+
+	return fd.Close()
+}
@@ -0,0 +1,420 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"math"
+)
+
+// HEIF (HEIC, AVIF) container, ISO/IEC 23008-12. Only metadata is parsed
+// by the container, the coded image is decoded by pluggable Decoder.
+type heif struct {
+	brand     string
+	primary   uint32
+	exif      []byte   // TIFF structure of EXIF
+	icc       []byte   // ICC profile
+	transform []heifOp // irot and imir properties, in the order of application
+	width     int      // ispe property of primary item
+	height    int
+}
+
+// transformation of image: irot (angle 0..3, anticlockwise) or imir (axis 0..1)
+type heifOp struct {
+	kind  string
+	value byte
+}
+
+type heifItem struct {
+	kind   string
+	method uint16
+	extent [][2]uint64 // offset, length
+}
+
+func parseHeif(data []byte) (*heif, error) {
+	boxes, err := parseBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		h    heif
+		meta []byte
+	)
+
+	for _, b := range boxes {
+		switch b.kind {
+		case "ftyp":
+			if len(b.data) < 4 {
+				return nil, fmt.Errorf("heif: invalid ftyp")
+			}
+			h.brand = string(b.data[:4])
+		case "meta":
+			if len(b.data) < 4 {
+				return nil, fmt.Errorf("heif: invalid meta")
+			}
+			meta = b.data[4:]
+		}
+	}
+
+	if h.brand == "" || meta == nil {
+		return nil, fmt.Errorf("heif: ftyp or meta box is not found")
+	}
+
+	boxes, err = parseBoxes(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	items := map[uint32]*heifItem{}
+	var (
+		idat  []byte
+		props []box
+		assoc map[uint32][]int
+	)
+
+	for _, b := range boxes {
+		switch b.kind {
+		case "pitm":
+			r := newBoxReader(b.data)
+			if r.fullbox() == 0 {
+				h.primary = uint32(r.u16())
+			} else {
+				h.primary = r.u32()
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+		case "iinf":
+			if err := parseIinf(b.data, items); err != nil {
+				return nil, err
+			}
+		case "iloc":
+			if err := parseIloc(b.data, items); err != nil {
+				return nil, err
+			}
+		case "idat":
+			idat = b.data
+		case "iprp":
+			props, assoc, err = parseIprp(b.data)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, index := range assoc[h.primary] {
+		if index < 1 || index > len(props) {
+			continue
+		}
+
+		prop := props[index-1]
+		switch prop.kind {
+		case "colr":
+			if len(prop.data) > 4 && (string(prop.data[:4]) == "prof" || string(prop.data[:4]) == "rICC") {
+				h.icc = prop.data[4:]
+			}
+		case "irot", "imir":
+			if len(prop.data) > 0 {
+				h.transform = append(h.transform, heifOp{kind: prop.kind, value: prop.data[0] & 0x03})
+			}
+		case "ispe":
+			r := newBoxReader(prop.data)
+			r.fullbox()
+			width, height := r.u32(), r.u32()
+			if r.err != nil || width > math.MaxInt32 || height > math.MaxInt32 {
+				return nil, fmt.Errorf("heif: invalid ispe")
+			}
+			h.width, h.height = int(width), int(height)
+		}
+	}
+
+	// Exif is best-effort, the broken item does not fail the image
+	for _, item := range items {
+		if item.kind != "Exif" {
+			continue
+		}
+
+		payload, err := item.read(data, idat)
+		if err != nil {
+			continue
+		}
+
+		// payload is prefixed with offset to TIFF header
+		if len(payload) < 4 {
+			continue
+		}
+		offset := 4 + int(binary.BigEndian.Uint32(payload))
+		if offset < len(payload) {
+			h.exif = payload[offset:]
+		}
+	}
+
+	return &h, nil
+}
+
+func parseIinf(data []byte, items map[uint32]*heifItem) error {
+	r := newBoxReader(data)
+	if r.fullbox() == 0 {
+		r.u16()
+	} else {
+		r.u32()
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	boxes, err := parseBoxes(r.rest())
+	if err != nil {
+		return err
+	}
+
+	for _, b := range boxes {
+		if b.kind != "infe" {
+			continue
+		}
+
+		r := newBoxReader(b.data)
+		version := r.fullbox()
+		if version < 2 {
+			// legacy item info does not define item type
+			continue
+		}
+
+		var id uint32
+		if version == 2 {
+			id = uint32(r.u16())
+		} else {
+			id = r.u32()
+		}
+		r.u16() // protection index
+		kind := r.fourcc()
+		if r.err != nil {
+			return r.err
+		}
+
+		item(items, id).kind = kind
+	}
+
+	return nil
+}
+
+func parseIloc(data []byte, items map[uint32]*heifItem) error {
+	r := newBoxReader(data)
+	version := r.fullbox()
+
+	sizes := r.u8()
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0x0f)
+	sizes = r.u8()
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0x0f)
+	}
+
+	var count uint32
+	if version < 2 {
+		count = uint32(r.u16())
+	} else {
+		count = r.u32()
+	}
+
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(r.u16())
+		} else {
+			id = r.u32()
+		}
+
+		x := item(items, id)
+		if version == 1 || version == 2 {
+			x.method = r.u16() & 0x0f
+		}
+		r.u16() // data reference index
+		base := r.uint(baseOffsetSize)
+
+		extents := int(r.u16())
+		for j := 0; j < extents && r.err == nil; j++ {
+			r.uint(indexSize)
+			offset := r.uint(offsetSize)
+			length := r.uint(lengthSize)
+			x.extent = append(x.extent, [2]uint64{base + offset, length})
+		}
+	}
+
+	return r.err
+}
+
+// properties of items and its association with items, index is 1-based
+func parseIprp(data []byte) ([]box, map[uint32][]int, error) {
+	boxes, err := parseBoxes(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var props []box
+	assoc := map[uint32][]int{}
+
+	for _, b := range boxes {
+		switch b.kind {
+		case "ipco":
+			props, err = parseBoxes(b.data)
+			if err != nil {
+				return nil, nil, err
+			}
+		case "ipma":
+			r := newBoxReader(b.data)
+			version := r.fullbox()
+			large := r.flags&1 == 1
+
+			count := r.u32()
+			for i := uint32(0); i < count && r.err == nil; i++ {
+				var id uint32
+				if version < 1 {
+					id = uint32(r.u16())
+				} else {
+					id = r.u32()
+				}
+
+				n := int(r.u8())
+				for j := 0; j < n && r.err == nil; j++ {
+					if large {
+						assoc[id] = append(assoc[id], int(r.u16()&0x7fff))
+					} else {
+						assoc[id] = append(assoc[id], int(r.u8()&0x7f))
+					}
+				}
+			}
+
+			if r.err != nil {
+				return nil, nil, r.err
+			}
+		}
+	}
+
+	return props, assoc, nil
+}
+
+func item(items map[uint32]*heifItem, id uint32) *heifItem {
+	x, has := items[id]
+	if !has {
+		x = &heifItem{}
+		items[id] = x
+	}
+	return x
+}
+
+// read item data either from file (method 0) or from idat (method 1)
+func (x *heifItem) read(file, idat []byte) ([]byte, error) {
+	src := file
+	switch x.method {
+	case 0:
+	case 1:
+		src = idat
+	default:
+		return nil, fmt.Errorf("heif: construction method %d is not supported", x.method)
+	}
+
+	var buf bytes.Buffer
+	for _, e := range x.extent {
+		offset, length := e[0], e[1]
+		// offset is checked first, offset+length overflows on crafted extents
+		if offset > uint64(len(src)) {
+			return nil, fmt.Errorf("heif: item extent is out of range")
+		}
+		if length == 0 {
+			length = uint64(len(src)) - offset
+		}
+		if length > uint64(len(src))-offset {
+			return nil, fmt.Errorf("heif: item extent is out of range")
+		}
+		buf.Write(src[offset : offset+length])
+	}
+
+	return buf.Bytes(), nil
+}
+
+// apply irot and imir transformation, EXIF orientation is informative only
+// (ISO/IEC 23008-12), the image without transformation is displayed as is.
+func (h *heif) orient(img image.Image) image.Image {
+	for _, op := range h.transform {
+		switch {
+		case op.kind == "irot" && op.value == 1:
+			img = orient(img, 8) // 90 anticlockwise
+		case op.kind == "irot" && op.value == 2:
+			img = orient(img, 3)
+		case op.kind == "irot" && op.value == 3:
+			img = orient(img, 6) // 270 anticlockwise
+		case op.kind == "imir" && op.value == 0:
+			img = orient(img, 2) // vertical axis
+		case op.kind == "imir" && op.value == 1:
+			img = orient(img, 4) // horizontal axis
+		}
+	}
+
+	return img
+}
+
+//------------------------------------------------------------------------------
+
+// fetches HEIF/AVIF media, metadata is parsed from the container, the coded
+// image is decoded by the decoder registered for the format.
+func (r Reader) fetchMediaHeif(_ context.Context, path string, format string) (*Media, error) {
+	decoder, has := r.decoders[format]
+	if !has {
+		return nil, errCodecNotSupported.With(nil, format+", decoder is not configured")
+	}
+
+	fd, err := r.fsys.Open(path)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	defer fd.Close()
+
+	data, err := r.budget.readAll(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	container, err := parseHeif(data)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	// image is checked before decoding, the size is defined by container
+	decoded := int64(container.width) * int64(container.height) * 4
+	if err := r.budget.fit(int64(len(data)) + decoded); err != nil {
+		return nil, err
+	}
+
+	img, err := decoder.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	// decoder might apply the transformation of container itself
+	if o, ok := decoder.(interface{ Oriented() bool }); !ok || !o.Oriented() {
+		img = container.orient(img)
+	}
+
+	hash := sha256.Sum256(data)
+
+	return &Media{
+		path:   path,
+		hash:   hex.EncodeToString(hash[:]),
+		image:  img,
+		icc:    container.icc,
+		origin: newOrigin(r.fsys, path, format),
+	}, nil
+}
@@ -0,0 +1,311 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestHeif(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		h, err := parseHeif(newMockHeif(6, []byte("icc-profile"), 1))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(h.brand, "heic"),
+			it.Equal(h.primary, 1),
+			it.Equal(string(h.icc), "icc-profile"),
+			it.Equal(exifOrientation(h.exif), 6),
+			it.Seq(h.transform).Equal(heifOp{kind: "irot", value: 1}),
+			it.Equal(h.width, 4000),
+			it.Equal(h.height, 3000),
+		)
+	})
+
+	t.Run("BrokenExif", func(t *testing.T) {
+		data := newMockHeif(6, nil, 0)
+
+		// extent of Exif item is beyond the file
+		iloc := bytes.Index(data, []byte{0x44, 0x00, 0, 1, 0, 2, 0, 0, 0, 1})
+		binary.BigEndian.PutUint32(data[iloc+14:], math.MaxUint32)
+
+		h, err := parseHeif(data)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(len(h.exif), 0),
+			it.Equal(h.width, 4000),
+		)
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		data := newMockHeif(1, nil, 0)
+
+		for _, input := range [][]byte{
+			nil,
+			data[:10],
+			data[:len(data)-4],
+			[]byte("\x00\x00\x00\x08free"),
+		} {
+			_, err := parseHeif(input)
+			it.Then(t).ShouldNot(it.Nil(err))
+		}
+	})
+
+	t.Run("Extent", func(t *testing.T) {
+		src := []byte("0123456789")
+
+		for _, tc := range []struct {
+			extent [2]uint64
+			expect string
+		}{
+			{[2]uint64{0, 0}, "0123456789"},
+			{[2]uint64{2, 3}, "234"},
+			{[2]uint64{8, 0}, "89"},
+			{[2]uint64{10, 0}, ""},
+			{[2]uint64{11, 0}, "error"},
+			{[2]uint64{8, 3}, "error"},
+			{[2]uint64{2, math.MaxUint64}, "error"},
+			{[2]uint64{math.MaxUint64, 2}, "error"},
+		} {
+			item := heifItem{extent: [][2]uint64{tc.extent}}
+			data, err := item.read(src, nil)
+			if err != nil {
+				data = []byte("error")
+			}
+			it.Then(t).Should(
+				it.Equal(string(data), tc.expect),
+			)
+		}
+	})
+
+	t.Run("Orientation", func(t *testing.T) {
+		img := mockDecoder{}.image()
+
+		for o, expect := range map[int]image.Point{
+			1: {0, 0},
+			2: {3, 0},
+			3: {3, 1},
+			4: {0, 1},
+			5: {0, 0},
+			6: {1, 0},
+			7: {1, 3},
+			8: {0, 3},
+		} {
+			out := orient(img, o)
+			it.Then(t).Should(
+				it.Equiv(color.RGBAModel.Convert(out.At(expect.X, expect.Y)), color.Color(color.RGBA{255, 0, 0, 255})),
+			)
+		}
+	})
+
+	t.Run("Codec", func(t *testing.T) {
+		profile := medium.On("a", "").Process(medium.Replica("origin"))
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.heic", newMockHeif(1, []byte("icc-profile"), 1))
+
+		codec := NewCodec(profile, rfs, wfs, Emitters{}, WithDecoder(MEDIA_HEIF, mockDecoder{}))
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.heic"))),
+		)
+
+		data := wfs.files["/a/b.origin.jpg"]
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 2, 4)),
+			it.True(bytes.Contains(data, []byte("ICC_PROFILE\x00\x01\x01icc-profile"))),
+		)
+	})
+
+	t.Run("ExifOrientation", func(t *testing.T) {
+		profile := medium.On("a", "").Process(medium.Replica("origin"))
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.heic", newMockHeif(6, nil, 0))
+
+		codec := NewCodec(profile, rfs, wfs, Emitters{}, WithDecoder(MEDIA_HEIF, mockDecoder{}))
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.heic"))),
+		)
+
+		// EXIF orientation is not applied without irot
+		img, err := jpeg.Decode(bytes.NewReader(wfs.files["/a/b.origin.jpg"]))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 4, 2)),
+		)
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		profile := medium.On("a", "").Process(medium.Replica("origin"))
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.avif", newMockHeif(1, nil, 0))
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.avif"))
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("avif"),
+		)
+	})
+}
+
+func TestHeifDec(t *testing.T) {
+	heifdec := func(script string) *HeifDec {
+		dir := t.TempDir()
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, mockDecoder{}.image()); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "image.png"), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		bin := filepath.Join(dir, "heif-dec")
+		script = strings.ReplaceAll(script, "{image}", filepath.Join(dir, "image.png"))
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return NewHeifDec(bin)
+	}
+
+	t.Run("Decode", func(t *testing.T) {
+		f := heifdec(`[ "$(cat "$1")" = "heic" ] && cp {image} "$2"`)
+
+		img, err := f.Decode(strings.NewReader("heic"))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 4, 2)),
+		)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		f := heifdec(`echo "unsupported codec" >&2; exit 1`)
+
+		_, err := f.Decode(strings.NewReader("heic"))
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("unsupported codec"),
+		)
+	})
+
+	t.Run("Oriented", func(t *testing.T) {
+		profile := medium.On("a", "").Process(medium.Replica("origin"))
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.heic", newMockHeif(1, nil, 1))
+
+		f := heifdec(`cp {image} "$2"`)
+		codec := NewCodec(profile, rfs, wfs, Emitters{}, WithDecoder(MEDIA_HEIF, f))
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.heic"))),
+		)
+
+		// irot is applied by libheif
+		img, err := jpeg.Decode(bytes.NewReader(wfs.files["/a/b.origin.jpg"]))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 4, 2)),
+		)
+	})
+}
+
+// decoder of 4x2 image, pixel (0, 0) is red
+type mockDecoder struct{}
+
+func (mockDecoder) image() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	return img
+}
+
+func (d mockDecoder) Decode(r io.Reader) (image.Image, error) {
+	if _, err := io.ReadAll(r); err != nil {
+		return nil, err
+	}
+	return d.image(), nil
+}
+
+// HEIF container with primary item (1) and EXIF item (2) stored at mdat
+func newMockHeif(orientation int, icc []byte, irot int) []byte {
+	exif := newMockExif(orientation)
+
+	// primary item is 4000x3000 camera image
+	props := [][]byte{mockBox("ispe", []byte{0, 0, 0, 0, 0, 0, 0x0f, 0xa0, 0, 0, 0x0b, 0xb8})}
+	if icc != nil {
+		props = append(props, mockBox("colr", append([]byte("prof"), icc...)))
+	}
+	if irot != 0 {
+		props = append(props, mockBox("irot", []byte{byte(irot)}))
+	}
+
+	assoc := []byte{0, 1, byte(len(props))}
+	for i := range props {
+		assoc = append(assoc, byte(i+1))
+	}
+
+	build := func(offset uint32) ([]byte, []byte) {
+		ftyp := mockBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+
+		iloc := []byte{0, 0, 0, 0, 0x44, 0x00, 0, 1, 0, 2, 0, 0, 0, 1}
+		iloc = binary.BigEndian.AppendUint32(iloc, offset)
+		iloc = binary.BigEndian.AppendUint32(iloc, uint32(len(exif)))
+
+		meta := mockBox("meta", bytes.Join([][]byte{
+			{0, 0, 0, 0},
+			mockBox("hdlr", []byte("\x00\x00\x00\x00\x00\x00\x00\x00pict\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")),
+			mockBox("pitm", []byte{0, 0, 0, 0, 0, 1}),
+			mockBox("iinf", bytes.Join([][]byte{
+				{0, 0, 0, 0, 0, 2},
+				mockBox("infe", []byte("\x02\x00\x00\x00\x00\x01\x00\x00hvc1\x00")),
+				mockBox("infe", []byte("\x02\x00\x00\x00\x00\x02\x00\x00Exif\x00")),
+			}, nil)),
+			mockBox("iloc", iloc),
+			mockBox("iprp", bytes.Join([][]byte{
+				mockBox("ipco", bytes.Join(props, nil)),
+				mockBox("ipma", append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, assoc...)),
+			}, nil)),
+		}, nil))
+
+		return ftyp, meta
+	}
+
+	ftyp, meta := build(0)
+	ftyp, meta = build(uint32(len(ftyp) + len(meta) + 8))
+
+	return bytes.Join([][]byte{ftyp, meta, mockBox("mdat", exif)}, nil)
+}
+
+// EXIF item payload: offset to TIFF header, Exif header and TIFF
+func newMockExif(orientation int) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = append(tiff, 0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(orientation), 0x00, 0x00)
+	tiff = append(tiff, 0, 0, 0, 0)
+
+	return append([]byte("\x00\x00\x00\x06Exif\x00\x00"), tiff...)
+}
+
+func mockBox(kind string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(b, kind...), data...)
+}
@@ -0,0 +1,215 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Index of published media, it is kept at private storage. Each entry is
+// an individual object, concurrent writers never update the same object.
+type Index struct {
+	writer *Writer
+}
+
+func NewIndex(fsys WriterFS) *Index {
+	return &Index{
+		writer: NewWriter(fsys),
+	}
+}
+
+// Sources published from the content with given hash
+func (idx *Index) Sources(hash string) ([]string, error) {
+	entries, err := fs.ReadDir(idx.writer.fsys, hashIndexPath(hash))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errCodecIO.With(err)
+	}
+
+	seq := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		source, err := base64.RawURLEncoding.DecodeString(entry.Name())
+		if err != nil {
+			continue
+		}
+		seq = append(seq, string(source))
+	}
+
+	return seq, nil
+}
+
+// Put source to the hash index
+func (idx *Index) Put(ctx context.Context, hash string, source string) error {
+	return idx.writer.write(hashEntryPath(hash, source), &Meta{ContentType: "text/plain"}, []byte(source))
+}
+
+// Remove source from the hash index
+func (idx *Index) Remove(ctx context.Context, hash string, source string) error {
+	if err := idx.writer.Remove(ctx, hashEntryPath(hash, source)); err != nil {
+		return errCodecIO.With(err)
+	}
+
+	return nil
+}
+
+func hashIndexPath(hash string) string {
+	return "/sha256/" + hash + "/"
+}
+
+// source is encoded into the name of entry, listing of index requires no reads
+func hashEntryPath(hash string, source string) string {
+	return hashIndexPath(hash) + base64.RawURLEncoding.EncodeToString([]byte(source))
+}
+
+//------------------------------------------------------------------------------
+
+// The perceptual hash index is split into bands of 16 bits. Hashes within
+// the distance of 3 share at least one band (pigeonhole), larger distances
+// are approximated.
+const phashBands = 4
+
+// Capacity of the band chunk, published media is not indexed by the full
+// chunk. Banned content is always indexed.
+const phashChunkCapacity = 256
+
+// Similar looks up the index for hashes within the distance
+func (idx *Index) Similar(hash PHash, distance int) ([]Similar, error) {
+	var seq []Similar
+
+	for band := 0; band < phashBands; band++ {
+		entries, err := idx.chunk(hash, band)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			h, err := ParsePHash(strings.SplitN(entry.Name(), ".", 2)[0])
+			if err != nil || hash.Distance(h) > distance {
+				continue
+			}
+
+			x, err := idx.similar(similarChunkPath(hash, band) + entry.Name())
+			if err != nil {
+				return nil, err
+			}
+
+			if !slices.Contains(seq, x) {
+				seq = append(seq, x)
+			}
+		}
+	}
+
+	return seq, nil
+}
+
+// PutSimilar adds entry to perceptual hash index
+func (idx *Index) PutSimilar(ctx context.Context, entry Similar) error {
+	hash, err := ParsePHash(entry.PHash)
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+
+	for band := 0; band < phashBands; band++ {
+		if entry.Reason == "" {
+			entries, err := idx.chunk(hash, band)
+			if err != nil {
+				return err
+			}
+
+			if len(entries) >= phashChunkCapacity {
+				slog.Warn("perceptual hash index is full",
+					slog.String("source", entry.Source),
+					slog.Int("band", band),
+				)
+				continue
+			}
+		}
+
+		path := similarEntryPath(hash, band, entry)
+		if err := idx.writer.write(path, &Meta{ContentType: "application/json"}, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveSimilar removes source from perceptual hash index, banned entries are kept
+func (idx *Index) RemoveSimilar(ctx context.Context, phash string, source string) error {
+	hash, err := ParsePHash(phash)
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+
+	for band := 0; band < phashBands; band++ {
+		path := similarEntryPath(hash, band, Similar{PHash: phash, Source: source})
+		if err := idx.writer.Remove(ctx, path); err != nil {
+			return errCodecIO.With(err)
+		}
+	}
+
+	return nil
+}
+
+func (idx *Index) chunk(hash PHash, band int) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(idx.writer.fsys, similarChunkPath(hash, band))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errCodecIO.With(err)
+	}
+
+	return entries, nil
+}
+
+func (idx *Index) similar(path string) (Similar, error) {
+	fd, err := idx.writer.fsys.Open(path)
+	if err != nil {
+		return Similar{}, errCodecIO.With(err)
+	}
+	defer fd.Close()
+
+	var entry Similar
+	if err := json.NewDecoder(fd).Decode(&entry); err != nil {
+		return Similar{}, errCodecIO.With(err)
+	}
+
+	return entry, nil
+}
+
+func similarChunkPath(hash PHash, band int) string {
+	chunk := uint16(hash >> (16 * (phashBands - 1 - band)))
+	return fmt.Sprintf("/phash/%d/%04x/", band, chunk)
+}
+
+// name of entry is {phash}.{source}, banned entries are kept apart from
+// published ones, removal of published media keeps the ban.
+func similarEntryPath(hash PHash, band int, entry Similar) string {
+	path := similarChunkPath(hash, band) + hash.String() + "." + base64.RawURLEncoding.EncodeToString([]byte(entry.Source))
+	if entry.Reason != "" {
+		path += ".banned"
+	}
+	return path
+}
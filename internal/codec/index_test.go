@@ -0,0 +1,75 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+)
+
+func TestIndex(t *testing.T) {
+	t.Run("Sources", func(t *testing.T) {
+		idx := NewIndex(newMockFS())
+
+		it.Then(t).Should(
+			it.Nil(idx.Put(context.Background(), "abc", "/a/b.jpg")),
+			it.Nil(idx.Put(context.Background(), "abc", "/a/c.jpg")),
+			it.Nil(idx.Put(context.Background(), "abc", "/a/c.jpg")),
+			it.Nil(idx.Put(context.Background(), "def", "/a/d.jpg")),
+		)
+
+		seq, err := idx.Sources("abc")
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Seq(seq).Equal("/a/b.jpg", "/a/c.jpg"),
+		)
+
+		it.Then(t).Should(
+			it.Nil(idx.Remove(context.Background(), "abc", "/a/b.jpg")),
+		)
+
+		seq, err = idx.Sources("abc")
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Seq(seq).Equal("/a/c.jpg"),
+		)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		seq, err := NewIndex(newMockFS()).Sources("abc")
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(len(seq), 0),
+		)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		idx := NewIndex(newMockFS())
+		sources := []string{"/a/1.jpg", "/a/2.jpg", "/a/3.jpg", "/a/4.jpg"}
+
+		var wg sync.WaitGroup
+		for _, source := range sources {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				idx.Put(context.Background(), "abc", source)
+			}()
+		}
+		wg.Wait()
+
+		seq, err := idx.Sources("abc")
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Seq(seq).Equal(sources...),
+		)
+	})
+}
@@ -0,0 +1,157 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Box of ISO base media file format (ISO/IEC 14496-12), used by HEIF and MP4
+type box struct {
+	kind string
+	data []byte
+}
+
+func parseBoxes(data []byte) ([]box, error) {
+	var seq []box
+
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("isobmff: truncated box")
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		kind := string(data[4:8])
+		head := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("isobmff: truncated box")
+			}
+			size, head = binary.BigEndian.Uint64(data[8:16]), 16
+		}
+
+		if size < head || size > uint64(len(data)) {
+			return nil, fmt.Errorf("isobmff: invalid box size (%s)", kind)
+		}
+
+		seq = append(seq, box{kind: kind, data: data[head:size]})
+		data = data[size:]
+	}
+
+	return seq, nil
+}
+
+// findBox finds content of nested box following the path of box types
+func findBox(data []byte, path ...string) ([]byte, error) {
+	for _, kind := range path {
+		boxes, err := parseBoxes(data)
+		if err != nil {
+			return nil, err
+		}
+
+		data = nil
+		for _, b := range boxes {
+			if b.kind == kind {
+				data = b.data
+				break
+			}
+		}
+
+		if data == nil {
+			return nil, nil
+		}
+	}
+
+	return data, nil
+}
+
+// sticky-error reader of box fields
+type boxReader struct {
+	data  []byte
+	flags uint32
+	err   error
+}
+
+func newBoxReader(data []byte) *boxReader { return &boxReader{data: data} }
+
+func (r *boxReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = fmt.Errorf("isobmff: truncated box")
+		return nil
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *boxReader) fullbox() byte {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+	r.flags = uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	return b[0]
+}
+
+func (r *boxReader) u8() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *boxReader) u16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *boxReader) u32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *boxReader) uint(size int) uint64 {
+	switch size {
+	case 0:
+		return 0
+	case 4:
+		return uint64(r.u32())
+	case 8:
+		if b := r.take(8); b != nil {
+			return binary.BigEndian.Uint64(b)
+		}
+		return 0
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("isobmff: field size %d is not supported", size)
+		}
+		return 0
+	}
+}
+
+func (r *boxReader) fourcc() string { return string(r.take(4)) }
+
+func (r *boxReader) rest() []byte {
+	b := r.data
+	r.data = nil
+	return b
+}
@@ -0,0 +1,229 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// JPEG markers
+const (
+	jpegSOI  = 0xd8
+	jpegEOI  = 0xd9
+	jpegSOS  = 0xda
+	jpegAPP0 = 0xe0
+	jpegAPP1 = 0xe1
+	jpegAPP2 = 0xe2
+	jpegAPPE = 0xee
+	jpegAPPF = 0xef
+	jpegCOM  = 0xfe
+)
+
+var jpegExif = []byte("Exif\x00\x00")
+
+// jpegRewriter rewrites segments of JPEG without re-encoding, the entropy
+// coded data is copied as-is. APP1 (EXIF, XMP), APP13 (IPTC), other
+// application segments and comments are removed. JFIF and Adobe color
+// transform define decoding of the image, they are kept. The orientation is
+// kept by EXIF segment with the orientation tag only, pixels are not rotated.
+type jpegRewriter struct {
+	icc bool // ICC profile (APP2) is kept
+}
+
+func (rw jpegRewriter) rewrite(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return fmt.Errorf("jpeg: %w", err)
+	}
+	if soi[0] != 0xff || soi[1] != jpegSOI {
+		return fmt.Errorf("jpeg: invalid header")
+	}
+
+	// segments are buffered till the start of scan, the orientation is known
+	orientation := 1
+	var segments [][]byte
+
+	for {
+		marker, err := jpegMarker(br)
+		if err != nil {
+			return err
+		}
+
+		// standalone markers
+		if marker == jpegEOI {
+			return fmt.Errorf("jpeg: image not found")
+		}
+		if (marker >= 0xd0 && marker <= 0xd7) || marker == 0x01 {
+			segments = append(segments, []byte{0xff, marker})
+			continue
+		}
+
+		segment, err := jpegSegment(br, marker)
+		if err != nil {
+			return err
+		}
+
+		payload := segment[4:]
+		if marker == jpegAPP1 && orientation == 1 && bytes.HasPrefix(payload, jpegExif) {
+			orientation = exifOrientation(payload[len(jpegExif):])
+		}
+
+		if rw.keep(marker, payload) {
+			segments = append(segments, segment)
+		}
+
+		if marker == jpegSOS {
+			break
+		}
+	}
+
+	if err := rw.writeHeader(w, segments, orientation); err != nil {
+		return err
+	}
+
+	// entropy coded data and the rest of image
+	return rw.copyScan(w, br)
+}
+
+// writes segments of JPEG header, EXIF follows JFIF segment
+func (rw jpegRewriter) writeHeader(w io.Writer, segments [][]byte, orientation int) error {
+	header := [][]byte{{0xff, jpegSOI}}
+	if len(segments) > 0 && segments[0][1] == jpegAPP0 {
+		header = append(header, segments[0])
+		segments = segments[1:]
+	}
+
+	if orientation != 1 {
+		exif := append(append([]byte{}, jpegExif...), newExifOrientation(orientation)...)
+		header = append(header, binary.BigEndian.AppendUint16([]byte{0xff, jpegAPP1}, uint16(len(exif)+2)), exif)
+	}
+
+	for _, b := range append(header, segments...) {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (rw jpegRewriter) keep(marker byte, payload []byte) bool {
+	switch {
+	case marker == jpegAPP0:
+		return bytes.HasPrefix(payload, []byte("JFIF\x00"))
+	case marker == jpegAPP2:
+		return rw.icc && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == jpegAPPE:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= jpegAPP0 && marker <= jpegAPPF:
+		return false
+	case marker == jpegCOM:
+		return false
+	default:
+		return true
+	}
+}
+
+// reads marker, fill bytes are skipped
+func jpegMarker(br *bufio.Reader) (byte, error) {
+	c, err := br.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("jpeg: %w", err)
+	}
+	if c != 0xff {
+		return 0, fmt.Errorf("jpeg: marker not found")
+	}
+
+	for {
+		c, err = br.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("jpeg: %w", err)
+		}
+		if c != 0xff {
+			return c, nil
+		}
+	}
+}
+
+// reads segment of the marker, the segment includes marker and length
+func jpegSegment(br *bufio.Reader, marker byte) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(br, size[:]); err != nil {
+		return nil, fmt.Errorf("jpeg: %w", err)
+	}
+	length := int(binary.BigEndian.Uint16(size[:]))
+	if length < 2 {
+		return nil, fmt.Errorf("jpeg: invalid segment")
+	}
+
+	segment := make([]byte, length+2)
+	copy(segment, []byte{0xff, marker, size[0], size[1]})
+	if _, err := io.ReadFull(br, segment[4:]); err != nil {
+		return nil, fmt.Errorf("jpeg: %w", err)
+	}
+
+	return segment, nil
+}
+
+// copies scans of image till the end of image marker. Segments between
+// scans (e.g. tables of progressive JPEG) are filtered as header segments,
+// data after the end of image (e.g. appended previews) is dropped.
+func (rw jpegRewriter) copyScan(w io.Writer, br *bufio.Reader) error {
+	bw := bufio.NewWriter(w)
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("jpeg: %w", err)
+		}
+		if c != 0xff {
+			if err := bw.WriteByte(c); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// fill bytes might precede the marker
+		m := byte(0xff)
+		for m == 0xff {
+			if m, err = br.ReadByte(); err != nil {
+				return fmt.Errorf("jpeg: %w", err)
+			}
+		}
+
+		switch {
+		case m == 0x00 || (m >= 0xd0 && m <= 0xd7):
+			// stuffed byte and restart markers of entropy coded data
+			if _, err := bw.Write([]byte{0xff, m}); err != nil {
+				return err
+			}
+		case m == jpegEOI:
+			if _, err := bw.Write([]byte{0xff, m}); err != nil {
+				return err
+			}
+			return bw.Flush()
+		default:
+			segment, err := jpegSegment(br, m)
+			if err != nil {
+				return err
+			}
+			if !rw.keep(m, segment[4:]) {
+				continue
+			}
+			if _, err := bw.Write(segment); err != nil {
+				return err
+			}
+		}
+	}
+}
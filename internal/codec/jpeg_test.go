@@ -0,0 +1,113 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"image/jpeg"
+	"io"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+)
+
+func TestJpegRewriter(t *testing.T) {
+	plain := newMockJpeg(t, 16, 12)
+	jfif := mockSegment(0xe0, []byte("JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00"))
+	icc := mockSegment(0xe2, []byte("ICC_PROFILE\x00\x01\x01icc"))
+	source := func(exif []byte) []byte {
+		return bytes.Join([][]byte{
+			plain[:2],
+			jfif,
+			exif,
+			mockSegment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")),
+			icc,
+			mockSegment(0xed, []byte("Photoshop 3.0\x00IPTC")),
+			mockSegment(0xfe, []byte("comment")),
+			plain[2:],
+			[]byte("trailing preview"),
+		}, nil)
+	}
+	rewrite := func(rw jpegRewriter, data []byte) ([]byte, error) {
+		var out bytes.Buffer
+		err := rw.rewrite(&out, bytes.NewReader(data))
+		return out.Bytes(), err
+	}
+
+	t.Run("Strip", func(t *testing.T) {
+		out, err := rewrite(jpegRewriter{}, source(mockSegment(0xe1, newMockExif(1)[4:])))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(string(out), string(bytes.Join([][]byte{plain[:2], jfif, plain[2:]}, nil))),
+		)
+	})
+
+	t.Run("KeepICC", func(t *testing.T) {
+		out, err := rewrite(jpegRewriter{icc: true}, source(nil))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(string(out), string(bytes.Join([][]byte{plain[:2], jfif, icc, plain[2:]}, nil))),
+		)
+	})
+
+	t.Run("Orientation", func(t *testing.T) {
+		out, err := rewrite(jpegRewriter{}, source(mockSegment(0xe1, newMockExif(6)[4:])))
+		exif := mockSegment(0xe1, append([]byte("Exif\x00\x00"), newExifOrientation(6)...))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(string(out), string(bytes.Join([][]byte{plain[:2], jfif, exif, plain[2:]}, nil))),
+			it.Equal(exifOrientation(exif[10:]), 6),
+		)
+
+		img, err := jpeg.Decode(bytes.NewReader(out))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds().Dx(), 16),
+		)
+	})
+
+	t.Run("BetweenScans", func(t *testing.T) {
+		scan, eoi := plain[2:len(plain)-2], plain[len(plain)-2:]
+		dri := mockSegment(0xdd, []byte{0x00, 0x00})
+		data := bytes.Join([][]byte{
+			plain[:2],
+			scan,
+			mockSegment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")),
+			mockSegment(0xfe, []byte("comment")),
+			dri,
+			eoi,
+		}, nil)
+
+		out, err := rewrite(jpegRewriter{}, data)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(string(out), string(bytes.Join([][]byte{plain[:2], scan, dri, eoi}, nil))),
+		)
+
+		_, err = jpeg.Decode(bytes.NewReader(out))
+		it.Then(t).Should(it.Nil(err))
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		for _, data := range [][]byte{
+			[]byte("GIF89a"),
+			plain[:len(plain)/2],
+			append(append([]byte{}, plain[:2]...), 0xff, 0xe1, 0x00),
+			append(append([]byte{}, plain[:2]...), 0xff, 0xd9),
+		} {
+			err := jpegRewriter{}.rewrite(io.Discard, bytes.NewReader(data))
+			it.Then(t).ShouldNot(
+				it.Nil(err),
+			)
+		}
+	})
+}
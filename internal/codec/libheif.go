@@ -0,0 +1,65 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// HeifDec decodes HEIF/AVIF using heif-dec subprocess of libheif. The image
+// is transformed (irot, imir) by libheif.
+type HeifDec struct {
+	bin string
+}
+
+// NewHeifDec creates decoder using heif-dec binary (e.g. /opt/bin/heif-dec)
+func NewHeifDec(bin string) *HeifDec {
+	return &HeifDec{bin: bin}
+}
+
+func (d *HeifDec) Decode(r io.Reader) (image.Image, error) {
+	dir, err := os.MkdirTemp("", "heif-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// heif-dec reads the container from file, the output format is defined by extension
+	source, target := filepath.Join(dir, "source"), filepath.Join(dir, "image.png")
+	if err := copyToFile(source, r); err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(d.bin, source, target)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("heif-dec: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	fd, err := os.Open(target)
+	if err != nil {
+		return nil, fmt.Errorf("heif-dec: %w", err)
+	}
+	defer fd.Close()
+
+	return png.Decode(fd)
+}
+
+// Oriented image is produced by the decoder
+func (d *HeifDec) Oriented() bool { return true }
@@ -0,0 +1,208 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/fogfish/medium"
+)
+
+const (
+	// memory reserved for runtime, i/o buffers and sdk clients
+	memoryReserve = 32 << 20
+
+	// lambda allocates vCPU proportionally to memory, 1769 MB is one vCPU
+	memoryPerWorker = 1769
+
+	// transcoder runs as subprocess, its memory is not visible to the codec,
+	// the cost is a rough estimate of the process and frames it holds
+	memorySubprocess = 128 << 20
+
+	// frames of source held by the decoder and frames of rendition held by
+	// libx264 lookahead and threads (preset veryfast), yuv420p frames.
+	memorySourceFrames    = 16
+	memoryRenditionFrames = 48
+)
+
+// Memory budget of the codec
+type budget struct {
+	limit int64 // bytes available for media, 0 is unlimited
+}
+
+// budget of lambda function with given memory in MB
+func newBudget(mb int) budget {
+	return budget{limit: max(1, int64(mb)<<20-memoryReserve)}
+}
+
+// fails if the required memory does not fit into the budget
+func (b budget) fit(required int64) error {
+	if b.limit == 0 || required <= b.limit {
+		return nil
+	}
+
+	return ErrMemoryBudget.With(nil, int(required>>20), int(b.limit>>20))
+}
+
+// reads the source held in memory, it is not read beyond the budget
+func (b budget) readAll(r io.Reader) ([]byte, error) {
+	limit := int64(math.MaxInt64 - 1)
+	if b.limit != 0 {
+		limit = b.limit
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	if err := b.fit(int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// memory available for variants while given memory is held
+func (b budget) available(held int64) int64 {
+	if b.limit == 0 {
+		return math.MaxInt64
+	}
+
+	return max(0, b.limit-held)
+}
+
+// Estimates memory required to publish media. The decoded media and variants
+// used by the cascade are held until all variants are produced, each variant
+// costs memory while it is produced.
+func (codec *Codec) memoryOf(media *Media, parent []int) (held int64, costs []int64) {
+	held = sizeOfMedia(media)
+	costs = make([]int64, len(codec.scaler))
+
+	for i, s := range codec.scaler {
+		var larger image.Point
+		if p := parent[i]; p != -1 {
+			larger = codec.scaler[p].sizeOf(media.image.Bounds())
+		}
+
+		costs[i] = s.memoryOf(media, larger)
+
+		if slices.Contains(parent, i) {
+			size := s.sizeOf(media.image.Bounds())
+			held += 4 * int64(size.X) * int64(size.Y)
+		}
+	}
+
+	return held, costs
+}
+
+// Estimates memory required by the scaler to produce the variant from the
+// media or from the larger variant if its size is defined.
+func (s Scaler) memoryOf(media *Media, larger image.Point) int64 {
+	switch {
+	case s.resolution.Bitrate != 0:
+		// 1080p rendition of 1080p source costs about 330 MB
+		bounds := media.image.Bounds()
+		return memorySubprocess +
+			memorySourceFrames*3/2*int64(bounds.Dx())*int64(bounds.Dy()) +
+			memoryRenditionFrames*3/2*int64(s.resolution.Width)*int64(s.resolution.Height)
+	case s.replicates() && s.originOf(media) != nil:
+		// replica streams bytes of the source
+		return 0
+	case s.replicates():
+		// replica encodes the decoded image
+		bounds := media.image.Bounds()
+		return int64(bounds.Dx()) * int64(bounds.Dy())
+	}
+
+	target := s.sizeOf(media.image.Bounds())
+	area := int64(target.X) * int64(target.Y)
+
+	// waveform is rendered at target size
+	if media.audio != nil {
+		return 5 * area
+	}
+
+	source := image.Point{X: media.image.Bounds().Dx(), Y: media.image.Bounds().Dy()}
+	if larger != (image.Point{}) {
+		source = larger
+	}
+	region := cropRegion(source, target)
+
+	// the crop region is copied, resampled horizontally, vertically and
+	// encoded, the source is converted to RGBA unless it is variant or vector.
+	// Nearest neighbour samples the crop region in single pass.
+	cost := 4*int64(region.X)*int64(region.Y) + 5*area
+	if s.resolution.Filter != medium.Nearest {
+		cost += 4 * int64(target.X) * int64(region.Y)
+	}
+	if _, ok := media.image.(*image.RGBA); !ok && larger == (image.Point{}) && media.vector == nil {
+		cost += 4 * int64(source.X) * int64(source.Y)
+	}
+
+	if media.vector != nil {
+		cost += 4 * area
+	}
+
+	if media.animation != nil {
+		cost *= int64(len(media.animation.Frames))
+	}
+
+	return cost
+}
+
+// size of decoded media in memory
+func sizeOfMedia(media *Media) int64 {
+	size := sizeOfImage(media.image)
+	if media.animation != nil {
+		for _, frame := range media.animation.Frames[1:] {
+			size += sizeOfImage(frame)
+		}
+	}
+
+	return size
+}
+
+func sizeOfImage(img image.Image) int64 {
+	switch v := img.(type) {
+	case *image.YCbCr:
+		return int64(len(v.Y) + len(v.Cb) + len(v.Cr))
+	case *image.Gray:
+		return int64(len(v.Pix))
+	case *image.RGBA:
+		return int64(len(v.Pix))
+	case *image.NRGBA:
+		return int64(len(v.Pix))
+	case *image.CMYK:
+		return int64(len(v.Pix))
+	case *image.Paletted:
+		return int64(len(v.Pix))
+	case nil:
+		return 0
+	default:
+		bounds := img.Bounds()
+		return 4 * int64(bounds.Dx()) * int64(bounds.Dy())
+	}
+}
+
+// bytes per pixel of decoded image of the color model
+func bytesPerPixel(model color.Model) int64 {
+	switch model {
+	case color.GrayModel:
+		return 1
+	case color.YCbCrModel:
+		return 3
+	default:
+		return 4
+	}
+}
@@ -0,0 +1,180 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestMemory(t *testing.T) {
+	profile := medium.On("a", "").Process(
+		medium.ScaleTo("large", 400, 300),
+		medium.ScaleTo("small", 100, 75),
+		medium.Replica("origin"),
+	)
+
+	t.Run("Budget", func(t *testing.T) {
+		b := newBudget(64)
+
+		it.Then(t).Should(
+			it.Equal(b.limit, int64(32<<20)),
+			it.Nil(b.fit(32<<20)),
+			it.Fail(func() error { return b.fit(33 << 20) }).Contain("requires 33 MB, available 32 MB"),
+			it.Equal(b.available(8<<20), int64(24<<20)),
+			it.Nil(budget{}.fit(1<<40)),
+		)
+	})
+
+	t.Run("Workers", func(t *testing.T) {
+		small := NewCodec(profile, newMockFS(), newMockFS(), Emitters{}, WithConcurrency(4), WithMemoryBudget(128))
+		large := NewCodec(profile, newMockFS(), newMockFS(), Emitters{}, WithConcurrency(4), WithMemoryBudget(3538))
+
+		it.Then(t).Should(
+			it.Equal(small.workers, 1),
+			it.Equal(large.workers, 2),
+		)
+	})
+
+	t.Run("Estimate", func(t *testing.T) {
+		codec := NewCodec(profile, newMockFS(), newMockFS(), Emitters{})
+		media := &Media{image: image.NewGray(image.Rect(0, 0, 800, 600))}
+
+		held, costs := codec.memoryOf(media, []int{-1, -1, -1})
+		_, cascaded := codec.memoryOf(media, codec.cascade(media))
+
+		it.Then(t).Should(
+			it.Equal(held, int64(800*600)),
+			it.Equal(costs[0], int64(4*800*600+4*800*600+4*400*600+5*400*300)),
+			it.Equal(costs[2], int64(800*600)),
+			it.Less(cascaded[1], costs[1]),
+		)
+	})
+
+	t.Run("Transcoder", func(t *testing.T) {
+		scaler := NewScaler(profile, medium.Rendition("hd", 1920, 1080, 5000))
+		media := &Media{image: image.NewGray(image.Rect(0, 0, 1920, 1080))}
+
+		it.Then(t).Should(
+			it.Greater(scaler.memoryOf(media, image.Point{}), int64(300<<20)),
+		)
+	})
+
+	t.Run("DecodeExceeded", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.jpg", newMockJpeg(t, 1600, 1200))
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}, WithMemoryBudget(33)).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.True(errors.Is(err, ErrMemoryBudget)),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("ScaleExceeded", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.jpg", newMockJpeg(t, 800, 600))
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}, WithMemoryBudget(33)).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.True(errors.Is(err, ErrMemoryBudget)),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("HeaderExceeded", func(t *testing.T) {
+		// header is not within the peek, truncated scan is not decoded
+		jpg := newMockJpeg(t, 1600, 1200)
+		app := append([]byte{0xff, 0xe2, 0xff, 0xff}, make([]byte, 0xfffd)...)
+		data := append([]byte{}, jpg[:2]...)
+		for i := 0; i < 5; i++ {
+			data = append(data, app...)
+		}
+		data = append(data, jpg[2:len(jpg)/2]...)
+
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.jpg", data)
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}, WithMemoryBudget(33)).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.True(errors.Is(err, ErrMemoryBudget)),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("GifExceeded", func(t *testing.T) {
+		// canvas of 2000x2000 with single frame of 1x1
+		var buf bytes.Buffer
+		gif.EncodeAll(&buf, &gif.GIF{
+			Image:  []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9)},
+			Delay:  []int{0},
+			Config: image.Config{Width: 2000, Height: 2000, ColorModel: color.Palette(palette.Plan9)},
+		})
+
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.gif", buf.Bytes())
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}, WithMemoryBudget(33)).Process(context.Background(), newMockEvent("a/b.gif"))
+
+		it.Then(t).Should(
+			it.True(errors.Is(err, ErrMemoryBudget)),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("WebpExceeded", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.webp", newMockWebpStill(1024, 1024))
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}, WithMemoryBudget(33)).Process(context.Background(), newMockEvent("a/b.webp"))
+
+		it.Then(t).Should(
+			it.True(errors.Is(err, ErrMemoryBudget)),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("HeifExceeded", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.heic", newMockHeif(1, nil, 0))
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}, WithMemoryBudget(33), WithDecoder(MEDIA_HEIF, mockDecoder{})).Process(context.Background(), newMockEvent("a/b.heic"))
+
+		it.Then(t).Should(
+			it.True(errors.Is(err, ErrMemoryBudget)),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("Fit", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.jpg", newMockJpeg(t, 800, 600))
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}, WithMemoryBudget(40)).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.True(wfs.Has("/a/b.large-400x300.jpg")),
+			it.True(wfs.Has("/a/b.small-100x75.jpg")),
+			it.True(wfs.Has("/a/b.origin.jpg")),
+		)
+	})
+}
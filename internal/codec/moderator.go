@@ -0,0 +1,168 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"image/jpeg"
+	"slices"
+
+	"github.com/fogfish/gurl/v2/http"
+	ƒ "github.com/fogfish/gurl/v2/http/recv"
+	ø "github.com/fogfish/gurl/v2/http/send"
+	"github.com/fogfish/medium"
+)
+
+const (
+	MODERATION_ALLOW  = "allow"
+	MODERATION_DENY   = "deny"
+	MODERATION_REVIEW = "review"
+)
+
+// Decision of media moderation
+type Decision struct {
+	Verdict string   `json:"verdict"`
+	Labels  []string `json:"labels,omitempty"`
+}
+
+// Moderator makes decision about media before any variant is published.
+type Moderator interface {
+	Moderate(context.Context, *Media) (Decision, error)
+}
+
+// Moderators chains moderation. Deny wins over review, review wins over allow.
+// Labels of all non-allow decisions are merged.
+type Moderators []Moderator
+
+func (seq Moderators) Moderate(ctx context.Context, media *Media) (Decision, error) {
+	decision := Decision{Verdict: MODERATION_ALLOW}
+
+	for _, moderator := range seq {
+		d, err := moderator.Moderate(ctx, media)
+		if err != nil {
+			return Decision{}, err
+		}
+
+		switch d.Verdict {
+		case MODERATION_DENY:
+			decision.Verdict = MODERATION_DENY
+			decision.Labels = append(decision.Labels, d.Labels...)
+			return decision, nil
+		case MODERATION_REVIEW:
+			decision.Verdict = MODERATION_REVIEW
+			decision.Labels = append(decision.Labels, d.Labels...)
+		}
+	}
+
+	return decision, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Rules is a local rule-based moderation. Zero value of the rule disables it.
+type Rules struct {
+	medium.Rules
+
+	// Media content is denied if it is listed by blocklist
+	Blocklist interface {
+		Has(context.Context, string) (bool, error)
+	} `json:"-"`
+}
+
+func (r Rules) Moderate(ctx context.Context, media *Media) (Decision, error) {
+	var labels []string
+
+	// image of other media is poster, waveform or page, its geometry is not
+	// the geometry of media
+	if media.video == nil && media.audio == nil && media.document == nil {
+		labels = r.geometry(media.image.Bounds().Dx(), media.image.Bounds().Dy())
+	}
+
+	if r.Blocklist != nil {
+		blocked, err := r.Blocklist.Has(ctx, media.hash)
+		if err != nil {
+			return Decision{}, err
+		}
+		if blocked {
+			labels = append(labels, "blocked")
+		}
+	}
+
+	if len(labels) != 0 {
+		return Decision{Verdict: MODERATION_DENY, Labels: labels}, nil
+	}
+
+	return Decision{Verdict: MODERATION_ALLOW}, nil
+}
+
+// labels of geometry rules violated by the image
+func (r Rules) geometry(w, h int) []string {
+	var labels []string
+
+	if (r.MinWidth > 0 && w < r.MinWidth) || (r.MinHeight > 0 && h < r.MinHeight) {
+		labels = append(labels, "too-small")
+	}
+
+	if (r.MaxWidth > 0 && w > r.MaxWidth) || (r.MaxHeight > 0 && h > r.MaxHeight) {
+		labels = append(labels, "too-large")
+	}
+
+	if h > 0 {
+		aspect := float64(w) / float64(h)
+		if (r.MinAspect > 0 && aspect < r.MinAspect) || (r.MaxAspect > 0 && aspect > r.MaxAspect) {
+			labels = append(labels, "aspect")
+		}
+	}
+
+	return labels
+}
+
+//------------------------------------------------------------------------------
+
+// Classifier is moderation by remote classifier. It sends media as JPEG to
+// HTTP endpoint, which responds with JSON encoded Decision.
+type Classifier struct {
+	http.Stack
+	url string
+}
+
+func NewClassifier(stack http.Stack, url string) *Classifier {
+	return &Classifier{
+		Stack: stack,
+		url:   url,
+	}
+}
+
+func (c *Classifier) Moderate(ctx context.Context, media *Media) (Decision, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, media.image, &jpeg.Options{Quality: 90}); err != nil {
+		return Decision{}, errCodecIO.With(err)
+	}
+
+	decision, err := http.IO[Decision](c.WithContext(ctx),
+		http.POST(
+			ø.URI(c.url),
+			ø.Accept.JSON,
+			ø.ContentType.Set("image/jpeg"),
+			ø.Header("X-Media-Hash", media.hash),
+			ø.Send(buf.Bytes()),
+			ƒ.Status.OK,
+		),
+	)
+	if err != nil {
+		return Decision{}, errCodecIO.With(err)
+	}
+
+	if !slices.Contains([]string{MODERATION_ALLOW, MODERATION_DENY, MODERATION_REVIEW}, decision.Verdict) {
+		return Decision{}, errCodecNotSupported.With(nil, "verdict "+decision.Verdict)
+	}
+
+	return *decision, nil
+}
@@ -0,0 +1,203 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+
+	µ "github.com/fogfish/gurl/v2/http"
+)
+
+func TestRules(t *testing.T) {
+	media := &Media{path: "/a/b.jpg", hash: "cafe", image: image.NewGray(image.Rect(0, 0, 16, 8))}
+
+	for expect, rules := range map[string]Rules{
+		"":          {Rules: medium.Rules{MinWidth: 16, MinHeight: 8, MaxWidth: 16, MaxHeight: 8, MinAspect: 2, MaxAspect: 2}},
+		"too-small": {Rules: medium.Rules{MinWidth: 32}},
+		"too-large": {Rules: medium.Rules{MaxHeight: 4}},
+		"aspect":    {Rules: medium.Rules{MaxAspect: 1}},
+		"blocked":   {Blocklist: mockBlocklist{"cafe"}},
+	} {
+		decision, err := rules.Moderate(context.Background(), media)
+		if expect == "" {
+			it.Then(t).Should(
+				it.Nil(err),
+				it.Equal(decision.Verdict, MODERATION_ALLOW),
+			)
+			continue
+		}
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(decision.Verdict, MODERATION_DENY),
+			it.Seq(decision.Labels).Equal(expect),
+		)
+	}
+
+	t.Run("Audio", func(t *testing.T) {
+		wave := &Media{path: "/a/b.wav", hash: "cafe", image: image.NewGray(image.Rect(0, 0, 2, 1)), audio: &Audio{}}
+
+		decision, err := Rules{Rules: medium.Rules{MinWidth: 32, MaxAspect: 1}}.Moderate(context.Background(), wave)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(decision.Verdict, MODERATION_ALLOW),
+		)
+
+		decision, err = Rules{Rules: medium.Rules{MinWidth: 32}, Blocklist: mockBlocklist{"cafe"}}.Moderate(context.Background(), wave)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(decision.Verdict, MODERATION_DENY),
+			it.Seq(decision.Labels).Equal("blocked"),
+		)
+	})
+
+	t.Run("Document", func(t *testing.T) {
+		page := &Media{path: "/a/b.pdf", image: image.NewGray(image.Rect(0, 0, 2, 1)), document: &Document{}}
+
+		decision, err := Rules{Rules: medium.Rules{MinWidth: 32}}.Moderate(context.Background(), page)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(decision.Verdict, MODERATION_ALLOW),
+		)
+	})
+}
+
+func TestModerators(t *testing.T) {
+	media := &Media{path: "/a/b.jpg", image: image.NewGray(image.Rect(0, 0, 16, 8))}
+
+	allow := mockModerator{Verdict: MODERATION_ALLOW}
+	review := mockModerator{Verdict: MODERATION_REVIEW, Labels: []string{"r"}}
+	deny := mockModerator{Verdict: MODERATION_DENY, Labels: []string{"d"}}
+
+	for expect, seq := range map[string]Moderators{
+		MODERATION_ALLOW:  {allow, allow},
+		MODERATION_REVIEW: {allow, review},
+		MODERATION_DENY:   {review, deny, allow},
+	} {
+		decision, err := seq.Moderate(context.Background(), media)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(decision.Verdict, expect),
+		)
+	}
+}
+
+func TestClassifier(t *testing.T) {
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			img, err := jpeg.Decode(r.Body)
+			if err != nil || r.Header.Get("X-Media-Hash") != "cafe" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			decision := Decision{Verdict: MODERATION_ALLOW}
+			if img.Bounds().Dx() > 8 {
+				decision = Decision{Verdict: MODERATION_REVIEW, Labels: []string{"nsfw"}}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(decision)
+		}),
+	)
+	defer ts.Close()
+
+	classifier := NewClassifier(µ.New(), ts.URL)
+
+	t.Run("Allow", func(t *testing.T) {
+		media := &Media{path: "/a/b.jpg", hash: "cafe", image: image.NewGray(image.Rect(0, 0, 8, 8))}
+		decision, err := classifier.Moderate(context.Background(), media)
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(decision.Verdict, MODERATION_ALLOW),
+		)
+	})
+
+	t.Run("Review", func(t *testing.T) {
+		media := &Media{path: "/a/b.jpg", hash: "cafe", image: image.NewGray(image.Rect(0, 0, 16, 8))}
+		decision, err := classifier.Moderate(context.Background(), media)
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(decision.Verdict, MODERATION_REVIEW),
+			it.Seq(decision.Labels).Equal("nsfw"),
+		)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		media := &Media{path: "/a/b.jpg", hash: "beef", image: image.NewGray(image.Rect(0, 0, 8, 8))}
+		_, err := classifier.Moderate(context.Background(), media)
+
+		it.Then(t).ShouldNot(
+			it.Nil(err),
+		)
+	})
+}
+
+func TestCodecModeration(t *testing.T) {
+	profile := medium.On("a", "").Process(medium.ScaleTo("small", 4, 4))
+
+	for verdict, expect := range map[string][4]int{
+		MODERATION_ALLOW:  {2, 0, 0, 0},
+		MODERATION_REVIEW: {0, 2, 0, 1},
+		MODERATION_DENY:   {0, 0, 1, 0},
+	} {
+		rfs, wfs, qfs := newMockInbox(t, "/a/b.jpg"), newMockFS(), newMockFS()
+		rejected, pending := &mockEmitter[MediaRejected]{}, &mockEmitter[MediaPendingReview]{}
+		codec := NewCodec(profile, rfs, wfs, Emitters{Rejected: rejected, PendingReview: pending},
+			WithModerator(mockModerator{Verdict: verdict, Labels: []string{"label"}}),
+			WithQuarantine(qfs),
+		)
+		err := codec.Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(wfs.Len(), expect[0]),
+			it.Equal(qfs.Len(), expect[1]),
+			it.Equal(len(rejected.events), expect[2]),
+			it.Equal(len(pending.events), expect[3]),
+		)
+
+		if verdict == MODERATION_DENY {
+			it.Then(t).Should(
+				it.Equal(rejected.events[0].Reason, "moderation"),
+				it.Seq(rejected.events[0].Labels).Equal("label"),
+			)
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type mockBlocklist []string
+
+func (b mockBlocklist) Has(_ context.Context, hash string) (bool, error) {
+	for _, x := range b {
+		if x == hash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type mockModerator Decision
+
+func (m mockModerator) Moderate(context.Context, *Media) (Decision, error) {
+	return Decision(m), nil
+}
@@ -0,0 +1,112 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// EXIF orientation tag
+const exifTagOrientation = 0x0112
+
+// exifOrientation reads orientation (1..8) from TIFF structure of EXIF,
+// it returns 1 if orientation is not defined.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+
+	n := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		at := ifd + 2 + i*12
+		if at+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[at:]) == exifTagOrientation {
+			o := int(order.Uint16(tiff[at+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+
+	return 1
+}
+
+// newExifOrientation builds TIFF structure of EXIF with orientation tag only
+func newExifOrientation(o int) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, exifTagOrientation)
+	tiff = append(tiff, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(o))
+	return append(tiff, 0, 0, 0, 0, 0, 0)
+}
+
+// orient image according to EXIF orientation, the image is transformed
+// into "top-left" (1) orientation.
+func orient(img image.Image, o int) image.Image {
+	switch o {
+	case 2:
+		return remap(img, false, func(x, y, w, h int) (int, int) { return w - 1 - x, y })
+	case 3:
+		return remap(img, false, func(x, y, w, h int) (int, int) { return w - 1 - x, h - 1 - y })
+	case 4:
+		return remap(img, false, func(x, y, w, h int) (int, int) { return x, h - 1 - y })
+	case 5:
+		return remap(img, true, func(x, y, w, h int) (int, int) { return y, x })
+	case 6:
+		return remap(img, true, func(x, y, w, h int) (int, int) { return h - 1 - y, x })
+	case 7:
+		return remap(img, true, func(x, y, w, h int) (int, int) { return h - 1 - y, w - 1 - x })
+	case 8:
+		return remap(img, true, func(x, y, w, h int) (int, int) { return y, w - 1 - x })
+	default:
+		return img
+	}
+}
+
+// remap pixels of the image, f maps source pixel to target one
+func remap(img image.Image, transpose bool, f func(x, y, w, h int) (int, int)) image.Image {
+	src := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if transpose {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			tx, ty := f(x, y, w, h)
+			copy(dst.Pix[dst.PixOffset(tx, ty):dst.PixOffset(tx, ty)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,152 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"strconv"
+)
+
+// PHash is a perceptual hash (dHash) of the image. Visually similar images
+// have hashes within small Hamming distance.
+type PHash uint64
+
+// PerceptualHash computes difference hash of the image: the image is reduced
+// to 9x8 gray scale, each bit tells if the pixel is darker than its neighbour.
+func PerceptualHash(img image.Image) PHash {
+	small := thumbnailOf(img, 9, 8)
+
+	var hash PHash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small[y][x] < small[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// number of samples along each axis of thumbnail cell
+const phashSamples = 8
+
+// Luma thumbnail of the image, each cell averages a grid of samples. The image
+// is not copied, the cost does not depend on the size of image.
+func thumbnailOf(img image.Image, w, h int) [][]uint64 {
+	bounds := img.Bounds()
+	thumb := make([][]uint64, h)
+	for y := range thumb {
+		thumb[y] = make([]uint64, w)
+		for x := range thumb[y] {
+			var sum uint64
+			for sy := 0; sy < phashSamples; sy++ {
+				for sx := 0; sx < phashSamples; sx++ {
+					px := bounds.Min.X + ((x*phashSamples+sx)*bounds.Dx()+bounds.Dx()/2)/(w*phashSamples)
+					py := bounds.Min.Y + ((y*phashSamples+sy)*bounds.Dy()+bounds.Dy()/2)/(h*phashSamples)
+					sum += luma(img.At(px, py))
+				}
+			}
+			thumb[y][x] = sum
+		}
+	}
+
+	return thumb
+}
+
+func luma(c color.Color) uint64 {
+	r, g, b, _ := c.RGBA()
+	return 299*uint64(r) + 587*uint64(g) + 114*uint64(b)
+}
+
+// perceptual hash of still image is computed if duplicates are detected,
+// waveforms, posters and pages of other media are not compared
+func (codec *Codec) perceptualHash(media *Media) {
+	if codec.duplicate == nil || !media.still() {
+		return
+	}
+
+	hash := PerceptualHash(media.image)
+	media.phash = &hash
+}
+
+func ParsePHash(s string) (PHash, error) {
+	hash, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return PHash(hash), nil
+}
+
+func (hash PHash) String() string { return fmt.Sprintf("%016x", uint64(hash)) }
+
+// Distance is Hamming distance between hashes
+func (hash PHash) Distance(other PHash) int {
+	return bits.OnesCount64(uint64(hash ^ other))
+}
+
+//------------------------------------------------------------------------------
+
+// Similar is entry of perceptual hash index
+type Similar struct {
+	PHash  string `json:"phash"`
+	Source string `json:"source"`
+	Reason string `json:"reason,omitempty"` // content is banned if reason is defined
+}
+
+// Duplicates moderates near-duplicates of known content. Banned content is
+// denied, other duplicates receive the configured verdict.
+type Duplicates struct {
+	index    *Index
+	distance int
+	verdict  string
+}
+
+// NewDuplicates detects media within the Hamming distance of perceptual hash,
+// the verdict is either review or deny.
+func NewDuplicates(index *Index, distance int, verdict string) (*Duplicates, error) {
+	if verdict != MODERATION_REVIEW && verdict != MODERATION_DENY {
+		return nil, errCodecNotSupported.With(nil, "verdict "+verdict)
+	}
+
+	return &Duplicates{
+		index:    index,
+		distance: distance,
+		verdict:  verdict,
+	}, nil
+}
+
+func (d *Duplicates) Moderate(ctx context.Context, media *Media) (Decision, error) {
+	if media.phash == nil {
+		return Decision{Verdict: MODERATION_ALLOW}, nil
+	}
+
+	seq, err := d.index.Similar(*media.phash, d.distance)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := Decision{Verdict: MODERATION_ALLOW}
+	for _, x := range seq {
+		switch {
+		case x.Reason != "":
+			return Decision{Verdict: MODERATION_DENY, Labels: []string{"banned"}}, nil
+		case x.Source != media.path:
+			decision = Decision{Verdict: d.verdict, Labels: []string{"duplicate"}}
+		}
+	}
+
+	return decision, nil
+}
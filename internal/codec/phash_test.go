@@ -0,0 +1,203 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestPerceptualHash(t *testing.T) {
+	a := PerceptualHash(newMockGradient(64, 48, false))
+	b := PerceptualHash(newMockGradient(640, 480, false))
+	c := PerceptualHash(newMockGradient(64, 48, true))
+
+	h, err := ParsePHash(a.String())
+
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(h, a),
+		it.Less(a.Distance(b), 4),
+		it.Greater(a.Distance(c), 32),
+	)
+}
+
+func TestSimilar(t *testing.T) {
+	wrt := NewIndex(newMockFS())
+	a := PerceptualHash(newMockGradient(64, 48, false))
+	b := a ^ 0x0101 // distance 2
+	c := ^a
+
+	it.Then(t).Should(
+		it.Nil(wrt.PutSimilar(context.Background(), Similar{PHash: a.String(), Source: "/a"})),
+		it.Nil(wrt.PutSimilar(context.Background(), Similar{PHash: c.String(), Source: "/c", Reason: "spam"})),
+	)
+
+	seq, err := wrt.Similar(b, 2)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Seq(seq).Equal(Similar{PHash: a.String(), Source: "/a"}),
+	)
+
+	seq, err = wrt.Similar(b, 1)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(seq), 0),
+	)
+
+	it.Then(t).Should(
+		it.Nil(wrt.RemoveSimilar(context.Background(), a.String(), "/a")),
+		it.Nil(wrt.RemoveSimilar(context.Background(), c.String(), "/c")),
+	)
+
+	seq, err = wrt.Similar(c, 0)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Seq(seq).Equal(Similar{PHash: c.String(), Source: "/c", Reason: "spam"}),
+	)
+}
+
+func TestCodecDuplicates(t *testing.T) {
+	profile := medium.On("a", "").Process(medium.ScaleTo("small", 4, 4))
+
+	rfs, wfs, ifs := newMockFS(), newMockFS(), newMockFS()
+	rfs.Put("/a/b.jpg", newMockGradientJpeg(t, 64, 48))
+	rfs.Put("/a/c.jpg", newMockGradientJpeg(t, 320, 240))
+
+	duplicates, err := NewDuplicates(NewIndex(ifs), 4, MODERATION_DENY)
+	it.Then(t).Should(it.Nil(err))
+
+	emitter := &mockEmitter[MediaPublished]{}
+	codec := NewCodec(profile, rfs, wfs, Emitters{Published: emitter}, WithDuplicates(duplicates))
+
+	it.Then(t).Should(
+		it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+		it.Nil(codec.Process(context.Background(), newMockEvent("a/c.jpg"))),
+		it.True(wfs.Has("/a/b.small-4x4.jpg")),
+		it.Equal(wfs.Has("/a/c.small-4x4.jpg"), false),
+		it.Equal(len(emitter.events), 1),
+		it.Equal(emitter.events[0].PHash, PerceptualHash(newMockGradient(64, 48, false)).String()),
+	)
+
+	// re-upload of similar content to the same key is not a duplicate
+	rfs.Put("/a/b.jpg", newMockGradientJpeg(t, 320, 240))
+	it.Then(t).Should(
+		it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+		it.Equal(len(emitter.events), 2),
+	)
+
+	// index is kept apart from published media
+	for path := range wfs.files {
+		it.Then(t).ShouldNot(
+			it.String(path).Contain("phash"),
+		)
+	}
+}
+
+func TestCodecPerceptualHash(t *testing.T) {
+	profile := medium.On("a", "").Process(medium.Replica("origin"))
+
+	process := func(t *testing.T, path string, data []byte, opts ...Option) string {
+		t.Helper()
+
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/"+path, data)
+		emitter := &mockEmitter[MediaPublished]{}
+
+		codec := NewCodec(profile, rfs, wfs, Emitters{Published: emitter}, opts...)
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent(path))),
+			it.Equal(len(emitter.events), 1),
+		)
+
+		return emitter.events[0].PHash
+	}
+
+	duplicates, err := NewDuplicates(NewIndex(newMockFS()), 4, MODERATION_DENY)
+	it.Then(t).Should(it.Nil(err))
+
+	it.Then(t).Should(
+		it.Equal(process(t, "a/b.jpg", newMockGradientJpeg(t, 64, 48)), ""),
+		it.Equal(process(t, "a/b.wav", newMockWav(wavPCM, 16, 1, 8000, 800, false), WithDuplicates(duplicates)), ""),
+		it.Equal(len(process(t, "a/b.jpg", newMockGradientJpeg(t, 64, 48), WithDuplicates(duplicates))), 16),
+	)
+}
+
+func TestDuplicatesVerdict(t *testing.T) {
+	for verdict, valid := range map[string]bool{
+		MODERATION_REVIEW: true,
+		MODERATION_DENY:   true,
+		MODERATION_ALLOW:  false,
+		"":                false,
+		"bogus":           false,
+	} {
+		_, err := NewDuplicates(NewIndex(newMockFS()), 3, verdict)
+		it.Then(t).Should(
+			it.Equal(err == nil, valid),
+		)
+	}
+}
+
+func TestSimilarCapacity(t *testing.T) {
+	idx := NewIndex(newMockFS())
+	a := PerceptualHash(newMockGradient(64, 48, false))
+
+	for i := 0; i < phashChunkCapacity+1; i++ {
+		it.Then(t).Should(
+			it.Nil(idx.PutSimilar(context.Background(), Similar{PHash: a.String(), Source: fmt.Sprintf("/%d", i)})),
+		)
+	}
+
+	it.Then(t).Should(
+		it.Nil(idx.PutSimilar(context.Background(), Similar{PHash: a.String(), Source: "/banned", Reason: "spam"})),
+	)
+
+	seq, err := idx.Similar(a, 0)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(seq), phashChunkCapacity+1),
+	)
+}
+
+func newMockGradient(w, h int, inverse bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// non-monotonic pattern, each row has distinct gradient
+			v := uint8((x*255/w + y*64/h) % 256)
+			if (x*4/w)%2 == 1 {
+				v = 255 - v
+			}
+			if inverse {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+func newMockGradientJpeg(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, newMockGradient(w, h, false), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,63 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// size limit of rendered page, A1 poster at 150 dpi fits the limit
+const popplerPixelLimit = 32 << 20
+
+// Poppler renders page of PDF document using pdftoppm subprocess
+type Poppler struct {
+	bin string
+	dpi int
+}
+
+// NewPoppler creates rasterizer using pdftoppm binary (e.g. /opt/bin/pdftoppm)
+func NewPoppler(bin string) *Poppler {
+	return &Poppler{bin: bin, dpi: 150}
+}
+
+func (p *Poppler) Page(ctx context.Context, pdf io.Reader, page int) (image.Image, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.bin,
+		"-f", strconv.Itoa(page), "-l", strconv.Itoa(page),
+		"-r", strconv.Itoa(p.dpi),
+		"-png", "-singlefile",
+		"-",
+	)
+	cmd.Stdin = pdf
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pdftoppm: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// page size is defined by the document, the raster is checked before decode
+	config, err := png.DecodeConfig(bytes.NewReader(stdout.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("pdftoppm: %w", err)
+	}
+	if int64(config.Width)*int64(config.Height) > popplerPixelLimit {
+		return nil, fmt.Errorf("pdftoppm: page %dx%d exceeds %d pixels", config.Width, config.Height, popplerPixelLimit)
+	}
+
+	return png.Decode(&stdout)
+}
@@ -0,0 +1,185 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/fogfish/swarm"
+)
+
+// State of quarantined media
+//
+//	pending ⟼ approved : variants are promoted to media storage
+//	pending ⟼ rejected : variants are removed
+const (
+	REVIEW_PENDING  = "pending"
+	REVIEW_APPROVED = "approved"
+	REVIEW_REJECTED = "rejected"
+)
+
+// Review of quarantined media, it is stored next to quarantined variants
+type Review struct {
+	Manifest
+	State   string    `json:"state"`
+	Labels  []string  `json:"labels,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// holds media in quarantine until review
+func (codec *Codec) hold(ctx context.Context, evt swarm.Msg[*events.S3EventRecord], media *Media, labels []string) error {
+	if codec.quarantor == nil {
+		return errCodecNotSupported.With(nil, "quarantine")
+	}
+
+	slog.Info("media is quarantined",
+		slog.String("path", media.path),
+		slog.Any("labels", labels),
+	)
+
+	variants, err := codec.publish(ctx, media, codec.quarantor)
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+
+	review := &Review{
+		Manifest: *codec.manifest(media, variants),
+		State:    REVIEW_PENDING,
+		Labels:   labels,
+		Updated:  time.Now().UTC(),
+	}
+	if err := codec.quarantor.PutReview(ctx, review); err != nil {
+		return err
+	}
+
+	codec.sinkPendingReview(ctx, evt, review)
+
+	return nil
+}
+
+// releases quarantined media
+func (codec *Codec) release(ctx context.Context, source string) error {
+	review, err := codec.quarantor.Review(source)
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	default:
+		return err
+	}
+
+	for _, variant := range review.Objects() {
+		if err := codec.quarantor.Remove(ctx, variant); err != nil {
+			return err
+		}
+	}
+
+	return codec.quarantor.Remove(ctx, reviewPath(source))
+}
+
+func (codec *Codec) sinkPendingReview(ctx context.Context, evt swarm.Msg[*events.S3EventRecord], review *Review) {
+	if codec.emitter.PendingReview == nil {
+		return
+	}
+
+	event := MediaPendingReview{
+		S3EventRecord: *evt.Object,
+		Labels:        review.Labels,
+		Keys:          make([]string, len(review.Variants)),
+	}
+
+	for i, path := range review.Variants {
+		event.Keys[i] = strings.TrimPrefix(path, "/")
+	}
+
+	codec.emitter.PendingReview.Enq(ctx, event)
+}
+
+//------------------------------------------------------------------------------
+
+// Review of quarantined media
+func (wrt Writer) Review(source string) (*Review, error) {
+	fd, err := wrt.fsys.Open(reviewPath(source))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var review Review
+	if err := json.NewDecoder(fd).Decode(&review); err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	return &review, nil
+}
+
+func (wrt Writer) PutReview(ctx context.Context, review *Review) error {
+	data, err := json.Marshal(review)
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+
+	return wrt.write(reviewPath(review.Source), &Meta{ContentType: "application/json"}, data)
+}
+
+// Promote copies variants listed by manifest from other storage and
+// publishes the manifest. Variants are promoted all-or-nothing, objects
+// published previously are restored if any of copy fails.
+func (wrt Writer) Promote(ctx context.Context, from *Writer, manifest *Manifest) error {
+	tx, err := wrt.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range manifest.Objects() {
+		if err := tx.copyFrom(from, path); err != nil {
+			tx.Rollback(ctx)
+			return errCodecIO.With(err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return wrt.PutManifest(ctx, manifest)
+}
+
+// stages object copied from other storage
+func (tx *Tx) copyFrom(from *Writer, path string) error {
+	fd, err := from.fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	meta := &Meta{ContentType: contentTypeOf(path)}
+	if err := tx.writer.stream(tx.stage+path, meta, fd); err != nil {
+		return err
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.staged[path] = meta
+
+	return nil
+}
+
+func reviewPath(source string) string {
+	return strings.TrimSuffix(source, filepath.Ext(source)) + ".review.json"
+}
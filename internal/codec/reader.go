@@ -9,39 +9,67 @@
 package codec
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"image"
-	_ "image/gif"
+	"image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"net/url"
 
 	"log/slog"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/fogfish/gurl/v2/http"
 	ƒ "github.com/fogfish/gurl/v2/http/recv"
 	ø "github.com/fogfish/gurl/v2/http/send"
 	"github.com/fogfish/swarm"
+	"golang.org/x/image/webp"
 )
 
+// Decoder of media format not supported by Go standard library
+// (e.g. HEIF, AVIF). The decoder receives the entire media file.
+type Decoder interface {
+	Decode(io.Reader) (image.Image, error)
+}
+
 type Reader struct {
 	http.Stack
-	fsys ReaderFS
+	fsys       ReaderFS
+	decoders   map[string]Decoder
+	rasterizer Rasterizer
+	frames     FrameDecoder
+	pages      PageRasterizer
+	page       int           // page of document rendered as image
+	posterAt   time.Duration // timestamp of video poster
+	budget     budget        // memory budget of decoded image
+	storage    int64         // local storage available for video, bytes
+	scaled     ScaledDecoder
+	scale      func(image.Point) int // scale of JPEG decode for the source size
+
+	// animation is kept if frames and duration are within limits
+	animates func(path string, frames int, duration time.Duration) bool
 }
 
 func NewReader(stack http.Stack, fsys ReaderFS) *Reader {
 	return &Reader{
-		Stack: stack,
-		fsys:  fsys,
+		Stack:    stack,
+		fsys:     fsys,
+		decoders: map[string]Decoder{},
 	}
 }
 
 func (r Reader) Get(ctx context.Context, evt swarm.Msg[*events.S3EventRecord]) (*Media, error) {
-	path, err := url.QueryUnescape(evt.Object.S3.Object.Key)
+	path, err := pathOf(evt.Object)
 	if err != nil {
 		return nil, err
 	}
@@ -56,10 +84,23 @@ func (r Reader) Get(ctx context.Context, evt swarm.Msg[*events.S3EventRecord]) (
 		slog.String("key", evt.Object.S3.Object.Key),
 	)
 
-	path = filepath.Join("/", path)
 	switch format {
-	case MEDIA_JPEG:
-		return r.fetchMediaJpeg(ctx, path)
+	case MEDIA_JPEG, MEDIA_PNG:
+		return r.fetchMediaImage(ctx, path)
+	case MEDIA_GIF:
+		return r.fetchMediaGif(ctx, path)
+	case MEDIA_WEBP:
+		return r.fetchMediaWebp(ctx, path)
+	case MEDIA_HEIF, MEDIA_AVIF:
+		return r.fetchMediaHeif(ctx, path, format)
+	case MEDIA_SVG:
+		return r.fetchMediaSvg(ctx, path)
+	case MEDIA_VIDEO:
+		return r.fetchMediaVideo(ctx, path)
+	case MEDIA_AUDIO:
+		return r.fetchMediaAudio(ctx, path)
+	case MEDIA_DOCUMENT:
+		return r.fetchMediaDocument(ctx, path)
 	case MEDIA_LINK:
 		return r.fetchMediaLink(ctx, path)
 	}
@@ -67,11 +108,39 @@ func (r Reader) Get(ctx context.Context, evt swarm.Msg[*events.S3EventRecord]) (
 	return nil, errCodecNotSupported.With(nil, format)
 }
 
+// path of S3 object
+func pathOf(evt *events.S3EventRecord) (string, error) {
+	path, err := url.QueryUnescape(evt.S3.Object.Key)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join("/", path), nil
+}
+
 func (r Reader) isSupported(path string) (string, bool) {
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
 	case ".jpg":
 		return MEDIA_JPEG, true
+	case ".png":
+		return MEDIA_PNG, true
+	case ".gif":
+		return MEDIA_GIF, true
+	case ".webp":
+		return MEDIA_WEBP, true
+	case ".heic", ".heif":
+		return MEDIA_HEIF, true
+	case ".avif":
+		return MEDIA_AVIF, true
+	case ".svg":
+		return MEDIA_SVG, true
+	case ".mp4", ".mov", ".webm":
+		return MEDIA_VIDEO, true
+	case ".wav", ".mp3", ".ogg":
+		return MEDIA_AUDIO, true
+	case ".pdf":
+		return MEDIA_DOCUMENT, true
 	case ".json":
 		return MEDIA_LINK, true
 	default:
@@ -79,24 +148,243 @@ func (r Reader) isSupported(path string) (string, bool) {
 	}
 }
 
-func (r Reader) fetchMediaJpeg(_ context.Context, path string) (*Media, error) {
+// JPEG is decoded at scale if scaled decoder is defined, the full scale
+// decode is used if scaled decoder fails (e.g. unsupported color space).
+func (r Reader) fetchMediaImage(ctx context.Context, path string) (*Media, error) {
+	media, err := r.decodeImage(ctx, path, r.scaled != nil)
+	if errors.Is(err, errCodecScaled) {
+		slog.Warn("failed to decode media at scale",
+			slog.String("path", path),
+			"error", err,
+		)
+		return r.decodeImage(ctx, path, false)
+	}
+
+	return media, err
+}
+
+func (r Reader) decodeImage(ctx context.Context, path string, scaled bool) (*Media, error) {
 	fd, err := r.fsys.Open(path)
 	if err != nil {
 		return nil, errCodecIO.With(err)
 	}
 	defer fd.Close()
 
-	img, _, err := image.Decode(fd)
+	stream := bufio.NewReaderSize(fd, imageHeaderLimit)
+	header, _ := stream.Peek(imageHeaderLimit)
+	config, format, err := image.DecodeConfig(bytes.NewReader(header))
+	if err != nil && len(header) == imageHeaderLimit {
+		// header is not within the limit (e.g. large EXIF), it is streamed
+		config, format, err = r.decodeConfig(path)
+	}
 	if err != nil {
 		return nil, errCodecIO.With(err)
 	}
+	source := image.Point{X: config.Width, Y: config.Height}
+
+	denom := 1
+	if scaled && format == MEDIA_JPEG && r.scale != nil {
+		denom = r.scale(source)
+	}
+
+	decoded := int64(source.X) * int64(source.Y) * bytesPerPixel(config.ColorModel)
+	if err := r.budget.fit(decoded / int64(denom*denom)); err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	var img image.Image
+	if denom > 1 {
+		slog.Debug("decoding media at scale",
+			slog.String("path", path),
+			slog.Int("denom", denom),
+		)
+
+		img, err = r.scaled.DecodeScaled(ctx, io.TeeReader(stream, hash), denom)
+		if err != nil {
+			return nil, errCodecScaled.With(err)
+		}
+	} else {
+		img, format, err = image.Decode(io.TeeReader(stream, hash))
+		if err != nil {
+			return nil, errCodecIO.With(err)
+		}
+	}
+
+	// decoder might not consume trailing bytes
+	if _, err := io.Copy(hash, stream); err != nil {
+		return nil, errCodecIO.With(err)
+	}
 
 	return &Media{
-		path:  path,
-		image: img,
+		path:   path,
+		hash:   hex.EncodeToString(hash.Sum(nil)),
+		image:  img,
+		origin: newOrigin(r.fsys, path, format),
 	}, nil
 }
 
+// size limit of image header (including EXIF thumbnail) peeked before decoding
+const imageHeaderLimit = 256 << 10
+
+// config of image, the header is streamed from the source
+func (r Reader) decodeConfig(path string) (image.Config, string, error) {
+	fd, err := r.fsys.Open(path)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	defer fd.Close()
+
+	return image.DecodeConfig(bufio.NewReader(fd))
+}
+
+// frames of animated GIF are decoded, the first one is the poster
+func (r Reader) fetchMediaGif(_ context.Context, path string) (*Media, error) {
+	fd, err := r.fsys.Open(path)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	defer fd.Close()
+
+	data, err := r.budget.readAll(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	// limits are checked before frames are decoded
+	delay, err := gifFrames(data)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	n := 1
+	if r.animates != nil && r.animates(path, len(delay), (&Animation{Delay: delay}).Duration()) {
+		n = len(delay)
+	}
+
+	// frames are within the canvas, paletted frames are coalesced into RGBA
+	canvas := int64(config.Width) * int64(config.Height)
+	if err := r.budget.fit(int64(len(data)) + int64(n)*canvas*5); err != nil {
+		return nil, err
+	}
+
+	var g *gif.GIF
+	if n > 1 {
+		g, err = gif.DecodeAll(bytes.NewReader(data))
+	} else {
+		g, err = decodeGifPoster(data, config)
+	}
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	hash := sha256.Sum256(data)
+	anim := coalesce(g, min(n, len(g.Image)))
+	media := &Media{
+		path:   path,
+		hash:   hex.EncodeToString(hash[:]),
+		image:  anim.Frames[0],
+		origin: newOrigin(r.fsys, path, MEDIA_GIF),
+	}
+
+	if len(anim.Frames) > 1 {
+		media.animation = anim
+	}
+
+	return media, nil
+}
+
+// the first frame of GIF is decoded only
+func decodeGifPoster(data []byte, config image.Config) (*gif.GIF, error) {
+	img, err := gif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	frame, ok := img.(*image.Paletted)
+	if !ok {
+		return nil, errors.New("gif: invalid frame")
+	}
+
+	return &gif.GIF{Image: []*image.Paletted{frame}, Delay: []int{0}, Config: config}, nil
+}
+
+// frames of animated WebP are decoded, the first one is the poster
+func (r Reader) fetchMediaWebp(_ context.Context, path string) (*Media, error) {
+	fd, err := r.fsys.Open(path)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	defer fd.Close()
+
+	data, err := io.ReadAll(io.LimitReader(fd, webpLimit+1))
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	if len(data) > webpLimit {
+		return nil, errCodecNotSupported.With(nil, "webp > 64MB")
+	}
+
+	container, err := parseWebp(data)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	hash := sha256.Sum256(data)
+	media := &Media{
+		path:   path,
+		hash:   hex.EncodeToString(hash[:]),
+		origin: newOrigin(r.fsys, path, MEDIA_WEBP),
+	}
+
+	if len(container.frames) == 0 {
+		config, err := webp.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, errCodecIO.With(err)
+		}
+
+		decoded := int64(config.Width) * int64(config.Height) * bytesPerPixel(config.ColorModel)
+		if err := r.budget.fit(int64(len(data)) + decoded); err != nil {
+			return nil, err
+		}
+
+		img, err := webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, errCodecIO.With(err)
+		}
+		media.image = img
+		return media, nil
+	}
+
+	// limits are checked before frames are decoded
+	n := 1
+	if r.animates != nil && r.animates(path, len(container.frames), container.Duration()) {
+		n = len(container.frames)
+	}
+
+	canvas := int64(container.width) * int64(container.height) * 4
+	if err := r.budget.fit(int64(len(data)) + int64(n)*canvas); err != nil {
+		return nil, err
+	}
+
+	anim, err := container.coalesce(n)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	media.image = anim.Frames[0]
+	if len(anim.Frames) > 1 {
+		media.animation = anim
+	}
+
+	return media, nil
+}
+
 func (r Reader) fetchMediaLink(ctx context.Context, path string) (*Media, error) {
 	fd, err := r.fsys.Open(path)
 	if err != nil {
@@ -104,8 +392,10 @@ func (r Reader) fetchMediaLink(ctx context.Context, path string) (*Media, error)
 	}
 	defer fd.Close()
 
+	// the link is identified by the hash of its descriptor
+	hash := sha256.New()
 	var link Link
-	if err := json.NewDecoder(fd).Decode(&link); err != nil {
+	if err := json.NewDecoder(io.TeeReader(fd, hash)).Decode(&link); err != nil {
 		return nil, err
 	}
 
@@ -116,6 +406,7 @@ func (r Reader) fetchMediaLink(ctx context.Context, path string) (*Media, error)
 
 	return &Media{
 		path:  path,
+		hash:  hex.EncodeToString(hash.Sum(nil)),
 		image: *img,
 	}, nil
 }
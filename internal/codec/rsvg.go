@@ -0,0 +1,51 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Rsvg rasterizes SVG using rsvg-convert subprocess of librsvg
+type Rsvg struct {
+	bin string
+}
+
+// NewRsvg creates rasterizer using rsvg-convert binary (e.g. /opt/bin/rsvg-convert)
+func NewRsvg(bin string) *Rsvg {
+	return &Rsvg{bin: bin}
+}
+
+func (r *Rsvg) Rasterize(svg []byte, width, height int) (image.Image, error) {
+	args := []string{"--format", "png"}
+	if width != 0 && height != 0 {
+		args = append(args,
+			"--width", strconv.Itoa(width),
+			"--height", strconv.Itoa(height),
+		)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(r.bin, args...)
+	cmd.Stdin = bytes.NewReader(svg)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("rsvg-convert: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return png.Decode(&stdout)
+}
@@ -0,0 +1,105 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Sanitizer copies media without metadata, the coded image is not changed
+type sanitizer func(w io.Writer, r io.Reader) error
+
+// sanitizer of media format, nil if the format is re-encoded. The ICC
+// profile is metadata, it is removed unless kept for color rendering.
+func sanitizerOf(format string, icc bool) sanitizer {
+	switch format {
+	case MEDIA_JPEG:
+		return jpegRewriter{icc: icc}.rewrite
+	case MEDIA_PNG:
+		return pngRewriter{icc: icc}.rewrite
+	default:
+		return nil
+	}
+}
+
+// sanitized stream of media
+func sanitize(f sanitizer, r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(f(pw, r))
+	}()
+
+	return pr
+}
+
+//------------------------------------------------------------------------------
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// ancillary chunks that define rendering of the image (color space,
+// transparency, animation), other ancillary chunks are metadata.
+var pngRendering = map[string]bool{
+	"gAMA": true, "cHRM": true, "sRGB": true, "cICP": true,
+	"sBIT": true, "tRNS": true, "bKGD": true, "pHYs": true, "hIST": true,
+	"sPLT": true, "acTL": true, "fcTL": true, "fdAT": true,
+}
+
+// pngRewriter copies PNG without metadata chunks (e.g. tEXt, iTXt, zTXt,
+// eXIf, tIME), the image data is copied as-is.
+type pngRewriter struct {
+	icc bool // ICC profile (iCCP) is kept
+}
+
+func (rw pngRewriter) rewrite(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(br, signature); err != nil {
+		return fmt.Errorf("png: %w", err)
+	}
+	if !bytes.Equal(signature, pngSignature) {
+		return fmt.Errorf("png: invalid header")
+	}
+	if _, err := w.Write(signature); err != nil {
+		return err
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return fmt.Errorf("png: %w", err)
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		kind := string(header[4:8])
+
+		// critical chunks are upper case
+		keep := kind[0] >= 'A' && kind[0] <= 'Z' || pngRendering[kind] || (rw.icc && kind == "iCCP")
+		if !keep {
+			if _, err := io.CopyN(io.Discard, br, length+4); err != nil {
+				return fmt.Errorf("png: %w", err)
+			}
+			continue
+		}
+
+		if _, err := w.Write(header[:]); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, br, length+4); err != nil {
+			return fmt.Errorf("png: %w", err)
+		}
+
+		if kind == "IEND" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,186 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestPngRewriter(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)))
+	plain := buf.Bytes()
+
+	// IHDR chunk follows the signature
+	ihdr := 8 + 8 + 13 + 4
+	gama := mockChunk("gAMA", []byte{0, 0, 0xb1, 0x8f})
+	iccp := mockChunk("iCCP", []byte("icc\x00\x00profile"))
+	source := bytes.Join([][]byte{
+		plain[:ihdr],
+		gama,
+		iccp,
+		mockChunk("tEXt", []byte("Author\x00someone")),
+		mockChunk("eXIf", []byte("MM\x00\x2a")),
+		plain[ihdr:],
+		[]byte("trailing"),
+	}, nil)
+
+	t.Run("Strip", func(t *testing.T) {
+		var out bytes.Buffer
+		err := pngRewriter{}.rewrite(&out, bytes.NewReader(source))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(out.String(), string(bytes.Join([][]byte{plain[:ihdr], gama, plain[ihdr:]}, nil))),
+		)
+	})
+
+	t.Run("KeepICC", func(t *testing.T) {
+		var out bytes.Buffer
+		err := pngRewriter{icc: true}.rewrite(&out, bytes.NewReader(source))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(out.String(), string(bytes.Join([][]byte{plain[:ihdr], gama, iccp, plain[ihdr:]}, nil))),
+		)
+	})
+}
+
+func TestCodecReplica(t *testing.T) {
+	plain := newMockJpeg(t, 16, 12)
+	exif := bytes.Join([][]byte{plain[:2], mockSegment(0xe1, []byte("Exif\x00\x00MM\x00\x2a")), plain[2:]}, nil)
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 12)))
+	pngData := buf.Bytes()
+
+	process := func(t *testing.T, replica medium.Resolution, path string, data []byte, opts ...func(medium.Profile) medium.Profile) *mockFS {
+		t.Helper()
+
+		profile := medium.On("a", "").Process(medium.ScaleTo("small", 4, 4), replica)
+		for _, opt := range opts {
+			profile = opt(profile)
+		}
+
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put(path, data)
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent(path[1:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wfs
+	}
+
+	t.Run("Exact", func(t *testing.T) {
+		wfs := process(t, medium.ExactReplica("origin"), "/a/b.jpg", exif)
+
+		it.Then(t).Should(
+			it.Equal(string(wfs.files["/a/b.origin.jpg"]), string(exif)),
+			it.Equal(wfs.meta["/a/b.origin.jpg"].ContentType, "image/jpeg"),
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+		)
+	})
+
+	t.Run("ExactPng", func(t *testing.T) {
+		wfs := process(t, medium.ExactReplica("origin"), "/a/b.png", pngData)
+
+		it.Then(t).Should(
+			it.Equal(string(wfs.files["/a/b.origin.png"]), string(pngData)),
+			it.Equal(wfs.meta["/a/b.origin.png"].ContentType, "image/png"),
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+		)
+	})
+
+	t.Run("ExactAtomic", func(t *testing.T) {
+		wfs := process(t, medium.ExactReplica("origin"), "/a/b.jpg", exif, medium.Profile.Atomically)
+
+		it.Then(t).Should(
+			it.Equal(string(wfs.files["/a/b.origin.jpg"]), string(exif)),
+			it.Equal(wfs.meta["/a/b.origin.jpg"].ContentType, "image/jpeg"),
+		)
+	})
+
+	t.Run("Sanitized", func(t *testing.T) {
+		ihdr := 8 + 8 + 13 + 4
+		text := bytes.Join([][]byte{pngData[:ihdr], mockChunk("tEXt", []byte("Author\x00someone")), pngData[ihdr:]}, nil)
+
+		wfs := process(t, medium.SanitizedReplica("origin"), "/a/b.png", text)
+
+		it.Then(t).Should(
+			it.Equal(string(wfs.files["/a/b.origin.png"]), string(pngData)),
+			it.Equal(wfs.meta["/a/b.origin.png"].ContentType, "image/png"),
+		)
+	})
+
+	t.Run("SanitizedJpeg", func(t *testing.T) {
+		wfs := process(t, medium.SanitizedReplica("origin"), "/a/b.jpg", exif)
+
+		it.Then(t).Should(
+			it.Equal(string(wfs.files["/a/b.origin.jpg"]), string(plain)),
+			it.Equal(wfs.meta["/a/b.origin.jpg"].ContentType, "image/jpeg"),
+		)
+	})
+
+	t.Run("SanitizedOrientation", func(t *testing.T) {
+		icc := mockSegment(0xe2, []byte("ICC_PROFILE\x00\x01\x01icc"))
+		source := bytes.Join([][]byte{plain[:2], mockSegment(0xe1, newMockExif(6)[4:]), icc, plain[2:]}, nil)
+		expect := bytes.Join([][]byte{plain[:2], mockSegment(0xe1, append([]byte("Exif\x00\x00"), newExifOrientation(6)...)), icc, plain[2:]}, nil)
+
+		wfs := process(t, medium.SanitizedReplica("origin"), "/a/b.jpg", source, medium.Profile.KeepColorProfile)
+
+		it.Then(t).Should(
+			it.Equal(string(wfs.files["/a/b.origin.jpg"]), string(expect)),
+		)
+	})
+
+	t.Run("SanitizedGif", func(t *testing.T) {
+		var buf bytes.Buffer
+		gif.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 12)), nil)
+
+		wfs := process(t, medium.SanitizedReplica("origin"), "/a/b.gif", buf.Bytes())
+
+		it.Then(t).Should(
+			it.True(wfs.Has("/a/b.origin.jpg")),
+			it.Equal(wfs.meta["/a/b.origin.jpg"].ContentType, "image/jpeg"),
+		)
+	})
+
+	t.Run("Encoded", func(t *testing.T) {
+		wfs := process(t, medium.Replica("origin"), "/a/b.png", pngData)
+
+		it.Then(t).Should(
+			it.True(wfs.Has("/a/b.origin.jpg")),
+			it.Equal(wfs.meta["/a/b.origin.jpg"].ContentType, "image/jpeg"),
+		)
+	})
+}
+
+func mockSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func mockChunk(kind string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
@@ -0,0 +1,71 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"context"
+	"image"
+	"io"
+
+	"github.com/fogfish/medium"
+)
+
+// ScaledDecoder decodes JPEG at scale 1/denom (2, 4 or 8) in the DCT domain
+// (e.g. libjpeg's scale_denom), the full resolution image is never built.
+type ScaledDecoder interface {
+	DecodeScaled(ctx context.Context, jpeg io.Reader, denom int) (image.Image, error)
+}
+
+// scales supported by decoder, the largest one first
+var decodeScales = []int{8, 4, 2}
+
+// Scale of JPEG decode, the smallest decoded image still covers the crop
+// region of every still image variant. The source is decoded at full scale
+// if any replica or nearest neighbour variant requires pixels of the source,
+// replicas copying bytes of JPEG do not require pixels.
+func (codec *Codec) decodeScale(source image.Point) int {
+	if source.X == 0 || source.Y == 0 {
+		return 1
+	}
+
+	bounds := image.Rect(0, 0, source.X, source.Y)
+	regions := make([][2]image.Point, 0, len(codec.scaler))
+	for _, s := range codec.scaler {
+		switch {
+		case s.resolution.Bitrate != 0, s.copies(MEDIA_JPEG):
+			continue
+		case s.replicates(), s.resolution.Filter == medium.Nearest:
+			return 1
+		}
+
+		target := s.sizeOf(bounds)
+		regions = append(regions, [2]image.Point{cropRegion(source, target), target})
+	}
+
+	if len(regions) == 0 {
+		return 1
+	}
+
+	for _, denom := range decodeScales {
+		fit := true
+		for _, r := range regions {
+			region, target := r[0], r[1]
+			if region.X/denom < target.X || region.Y/denom < target.Y {
+				fit = false
+				break
+			}
+		}
+
+		if fit {
+			return denom
+		}
+	}
+
+	return 1
+}
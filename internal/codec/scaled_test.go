@@ -0,0 +1,206 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestDecodeScale(t *testing.T) {
+	scaleOf := func(source image.Point, resolutions ...medium.Resolution) int {
+		profile := medium.On("a", "").Process(resolutions...)
+		return NewCodec(profile, newMockFS(), newMockFS(), Emitters{}).decodeScale(source)
+	}
+	source := image.Point{X: 1600, Y: 1200}
+
+	it.Then(t).Should(
+		it.Equal(scaleOf(source, medium.ScaleTo("s", 200, 150)), 8),
+		it.Equal(scaleOf(source, medium.ScaleTo("s", 200, 150), medium.ScaleTo("m", 400, 300)), 4),
+		it.Equal(scaleOf(source, medium.WidthLadder("m", 401)...), 2),
+		it.Equal(scaleOf(source, medium.ScaleTo("l", 1024, 768)), 1),
+		it.Equal(scaleOf(source, medium.ScaleTo("sq", 150, 150)), 8),
+		it.Equal(scaleOf(source, medium.ScaleTo("sq", 160, 160)), 4),
+		it.Equal(scaleOf(source, medium.ScaleTo("s", 200, 150), medium.Replica("origin")), 1),
+		it.Equal(scaleOf(source, medium.Replica("origin")), 1),
+		it.Equal(scaleOf(source, medium.ScaleTo("s", 200, 150), medium.ExactReplica("origin")), 8),
+		it.Equal(scaleOf(source, medium.ScaleTo("s", 200, 150), medium.SanitizedReplica("origin")), 8),
+		it.Equal(scaleOf(source, medium.ScaleTo("qr", 200, 150).Resample(medium.Nearest)), 1),
+		it.Equal(scaleOf(source, medium.ScaleTo("s", 200, 150).Resample(medium.Linear)), 8),
+		it.Equal(scaleOf(image.Point{}, medium.ScaleTo("s", 200, 150)), 1),
+	)
+}
+
+func TestCodecScaled(t *testing.T) {
+	profile := medium.On("a", "").Process(
+		medium.ScaleTo("medium", 400, 300),
+		medium.ScaleTo("small", 100, 75),
+	)
+
+	t.Run("DecodeAtScale", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.jpg", newMockJpeg(t, 1600, 1200))
+		decoder := &mockScaledDecoder{}
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}, WithScaledDecoder(decoder)).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Seq(decoder.denoms).Equal(4),
+			it.Equal(decoder.size, len(rfs.files["/a/b.jpg"])),
+			it.Equal(sizeOfJpeg(t, wfs, "/a/b.medium-400x300.jpg"), image.Point{X: 400, Y: 300}),
+			it.Equal(sizeOfJpeg(t, wfs, "/a/b.small-100x75.jpg"), image.Point{X: 100, Y: 75}),
+		)
+	})
+
+	t.Run("Hash", func(t *testing.T) {
+		rfs := newMockFS()
+		rfs.Put("/a/b.jpg", newMockJpeg(t, 1600, 1200))
+
+		scaled, err1 := NewCodec(profile, rfs, newMockFS(), Emitters{}, WithScaledDecoder(&mockScaledDecoder{})).reader.Get(context.Background(), newMockEvent("a/b.jpg"))
+		full, err2 := NewCodec(profile, rfs, newMockFS(), Emitters{}).reader.Get(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.Nil(err1),
+			it.Nil(err2),
+			it.Equal(scaled.hash, full.hash),
+			it.Equal(scaled.hash, newMockHash(rfs, "/a/b.jpg")),
+			it.Equal(scaled.image.Bounds(), image.Rect(0, 0, 400, 300)),
+		)
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.jpg", newMockJpeg(t, 1600, 1200))
+		decoder := &mockScaledDecoder{err: errors.New("unsupported color space")}
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}, WithScaledDecoder(decoder)).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Seq(decoder.denoms).Equal(4),
+			it.Equal(sizeOfJpeg(t, wfs, "/a/b.medium-400x300.jpg"), image.Point{X: 400, Y: 300}),
+		)
+	})
+
+	t.Run("Budget", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.jpg", newMockJpeg(t, 3200, 2400))
+
+		err1 := NewCodec(profile, rfs, wfs, Emitters{}, WithMemoryBudget(35)).Process(context.Background(), newMockEvent("a/b.jpg"))
+		err2 := NewCodec(profile, rfs, wfs, Emitters{}, WithMemoryBudget(35), WithScaledDecoder(&mockScaledDecoder{})).Process(context.Background(), newMockEvent("a/b.jpg"))
+
+		it.Then(t).Should(
+			it.True(errors.Is(err1, ErrMemoryBudget)),
+			it.Nil(err2),
+			it.True(wfs.Has("/a/b.medium-400x300.jpg")),
+		)
+	})
+}
+
+func TestDjpeg(t *testing.T) {
+	djpeg := func(script string) *Djpeg {
+		bin := filepath.Join(t.TempDir(), "djpeg")
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return NewDjpeg(bin)
+	}
+
+	t.Run("DecodeScaled", func(t *testing.T) {
+		f := djpeg(`[ "$2" = "1/4" ] && [ "$3" = "-pnm" ] && cat >/dev/null && printf 'P6\n# scaled\n2 1\n255\n\001\002\003\004\005\006'`)
+
+		img, err := f.DecodeScaled(context.Background(), strings.NewReader("jpeg"), 4)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 2, 1)),
+			it.Seq(img.(*image.RGBA).Pix).Equal(1, 2, 3, 0xff, 4, 5, 6, 0xff),
+		)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		f := djpeg(`echo "unsupported color conversion" >&2; exit 1`)
+
+		_, err := f.DecodeScaled(context.Background(), strings.NewReader("jpeg"), 2)
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("unsupported color conversion"),
+		)
+	})
+}
+
+func TestDecodePnm(t *testing.T) {
+	decode := func(pnm string) (image.Image, error) {
+		return decodePnm(bufio.NewReader(strings.NewReader(pnm)))
+	}
+
+	t.Run("Gray", func(t *testing.T) {
+		img, err := decode("P5 2 2 255\n\x01\x02\x03\x04")
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Seq(img.(*image.Gray).Pix).Equal(1, 2, 3, 4),
+		)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		_, err := decode("P6 2 2 255\n\x01\x02\x03")
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("pnm"),
+		)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err1 := decode("P3 2 2 255\n1 2 3")
+		_, err2 := decode("P6 2 2 65535\n")
+		_, err3 := decode("P6 2 x 255\n")
+		it.Then(t).Should(
+			it.Fail(func() error { return err1 }).Contain("unsupported format"),
+			it.Fail(func() error { return err2 }).Contain("unsupported header"),
+			it.Fail(func() error { return err3 }).Contain("invalid header"),
+		)
+	})
+}
+
+// decoder produces image of source size scaled by 1/denom
+type mockScaledDecoder struct {
+	denoms []int
+	size   int
+	err    error
+}
+
+func (d *mockScaledDecoder) DecodeScaled(_ context.Context, jpeg io.Reader, denom int) (image.Image, error) {
+	d.denoms = append(d.denoms, denom)
+
+	data, err := io.ReadAll(jpeg)
+	if err != nil {
+		return nil, err
+	}
+	d.size = len(data)
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return image.NewRGBA(image.Rect(0, 0, config.Width/denom, config.Height/denom)), nil
+}
@@ -12,17 +12,22 @@ import (
 	"context"
 	"image"
 	"log/slog"
+	"math"
+	"strings"
 
 	"github.com/anthonynsimon/bild/transform"
 	"github.com/fogfish/medium"
 )
 
 type Scaler struct {
+	profile    medium.Profile
 	resolution medium.Resolution
+	transcoder Transcoder
 }
 
-func NewScaler(resolution medium.Resolution) *Scaler {
+func NewScaler(profile medium.Profile, resolution medium.Resolution) *Scaler {
 	return &Scaler{
+		profile:    profile,
 		resolution: resolution,
 	}
 }
@@ -34,7 +39,11 @@ func (s Scaler) Process(ctx context.Context, media *Media) (*Media, error) {
 		slog.Group("target", "x", s.resolution.Width, "y", s.resolution.Height),
 	)
 
-	if s.resolution.Width == 0 || s.resolution.Height == 0 {
+	if s.resolution.Bitrate != 0 {
+		return s.transcode(ctx, media)
+	}
+
+	if s.replicates() {
 		return s.replica(ctx, media)
 	}
 
@@ -42,39 +51,245 @@ func (s Scaler) Process(ctx context.Context, media *Media) (*Media, error) {
 }
 
 func (s Scaler) replica(_ context.Context, media *Media) (*Media, error) {
+	// video, audio and documents are replicated as-is
+	if media.video != nil || media.audio != nil || media.document != nil {
+		return &Media{
+			path:     s.pathOf(media),
+			format:   s.formatOf(media),
+			hash:     media.hash,
+			image:    media.image,
+			video:    media.video,
+			audio:    media.audio,
+			document: media.document,
+			origin:   media.origin,
+		}, nil
+	}
+
+	// source bytes are copied unless the image is re-encoded
+	if origin := s.originOf(media); origin != nil {
+		return &Media{
+			path:   s.pathOf(media),
+			format: s.formatOf(media),
+			hash:   media.hash,
+			image:  media.image,
+			origin: origin,
+		}, nil
+	}
+
 	return &Media{
-		path:  s.resolution.FileSuffix(media.path),
-		image: media.image,
+		path:      s.pathOf(media),
+		format:    s.formatOf(media),
+		hash:      media.hash,
+		image:     media.image,
+		animation: media.animation,
+		icc:       media.icc,
+		vector:    media.vector,
 	}, nil
 }
 
 func (s Scaler) scaleTo(_ context.Context, media *Media) (*Media, error) {
+	size := s.sizeOf(media.image.Bounds())
+
+	// waveform is rendered at target size
+	if media.audio != nil {
+		return &Media{
+			path:   s.pathOf(media),
+			format: s.formatOf(media),
+			hash:   media.hash,
+			image:  media.audio.peaks.render(size.X, size.Y),
+		}, nil
+	}
+
+	source := media.image
+	if media.vector != nil {
+		img, err := media.vector.rasterize(media.image.Bounds(), size.X, size.Y)
+		if err != nil {
+			return nil, errCodecIO.With(err)
+		}
+		source = img
+	}
+
+	variant := &Media{
+		path:   s.pathOf(media),
+		format: s.formatOf(media),
+		hash:   media.hash,
+		image:  s.resize(source, size),
+		icc:    media.icc,
+	}
+
+	if media.animation != nil {
+		frames := make([]image.Image, len(media.animation.Frames))
+		frames[0] = variant.image
+		for i := 1; i < len(frames); i++ {
+			frames[i] = s.resize(media.animation.Frames[i], size)
+		}
+
+		variant.animation = &Animation{
+			Frames: frames,
+			Delay:  media.animation.Delay,
+			Loop:   media.animation.Loop,
+		}
+	}
+
+	return variant, nil
+}
+
+// derives the variant from the larger variant of the same media, the size is
+// defined by the source so that the variant is identical to scaled source.
+func (s Scaler) scaleFrom(_ context.Context, media *Media, larger *Media) (*Media, error) {
+	return &Media{
+		path:   s.pathOf(media),
+		format: s.formatOf(media),
+		hash:   media.hash,
+		image:  s.resize(larger.image, s.sizeOf(media.image.Bounds())),
+		icc:    media.icc,
+	}, nil
+}
+
+// crops the image to aspect ratio of the size and resizes it
+func (s Scaler) resize(img image.Image, size image.Point) image.Image {
 	cropX, cropY := CropToScale(
 		image.Point{
-			X: media.image.Bounds().Dx(),
-			Y: media.image.Bounds().Dy(),
-		},
-		image.Point{
-			X: s.resolution.Width,
-			Y: s.resolution.Height,
+			X: img.Bounds().Dx(),
+			Y: img.Bounds().Dy(),
 		},
+		size,
 	)
 
-	cropped := transform.Crop(media.image,
+	cropped := transform.Crop(img,
 		image.Rect(
 			cropX/2,
 			cropY/2,
-			media.image.Bounds().Dx()-cropX/2,
-			media.image.Bounds().Dy()-cropY/2,
+			img.Bounds().Dx()-cropX/2,
+			img.Bounds().Dy()-cropY/2,
 		),
 	)
 
-	img := transform.Resize(cropped, s.resolution.Width, s.resolution.Height, transform.Lanczos)
+	return transform.Resize(cropped, size.X, size.Y, s.filter())
+}
 
-	return &Media{
-		path:  s.resolution.FileSuffix(media.path),
-		image: img,
-	}, nil
+// resampling filter of the resolution, Lanczos is default
+func (s Scaler) filter() transform.ResampleFilter {
+	switch s.resolution.Filter {
+	case medium.Nearest:
+		return transform.NearestNeighbor
+	case medium.Linear:
+		return transform.Linear
+	case medium.CatmullRom:
+		return transform.CatmullRom
+	default:
+		return transform.Lanczos
+	}
+}
+
+// resolution of single dimension (e.g. a-320x0) is replica of the source,
+// except widths of responsive image set that keep the aspect ratio (see
+// medium.WidthLadder).
+func (s Scaler) replicates() bool {
+	if s.resolution.Width != 0 && s.resolution.Height != 0 {
+		return false
+	}
+
+	_, descriptor, ok := s.resolution.Srcset()
+	return !ok || !strings.HasSuffix(descriptor, "w")
+}
+
+// size of the media object produced by the scaler, width of responsive image
+// set keeps the aspect ratio of the source.
+func (s Scaler) sizeOf(source image.Rectangle) image.Point {
+	w, h := s.resolution.Width, s.resolution.Height
+
+	switch {
+	case h == 0 && source.Dx() > 0:
+		h = max(1, int(math.Round(float64(w)*float64(source.Dy())/float64(source.Dx()))))
+	case w == 0 && source.Dy() > 0:
+		w = max(1, int(math.Round(float64(h)*float64(source.Dx())/float64(source.Dy()))))
+	}
+
+	return image.Point{X: w, Y: h}
+}
+
+// path of the media object produced by the scaler
+func (s Scaler) pathOf(media *Media) string {
+	return s.keyOf(media, s.formatOf(media))
+}
+
+// key of the object in given format produced by the scaler
+func (s Scaler) keyOf(media *Media, format string) string {
+	ext := format
+	if format == "jpeg" {
+		ext = "jpg"
+	}
+
+	return s.profile.OutputKey(
+		medium.OutputVars{
+			Path:       media.path,
+			Hash:       media.hash,
+			Resolution: s.resolution,
+			Format:     format,
+			Ext:        ext,
+		},
+	)
+}
+
+// origin of still image copied by the replica. Sanitized SVG is always
+// published, formats without sanitizer are re-encoded.
+func (s Scaler) originOf(media *Media) *Origin {
+	if media.origin == nil || media.vector != nil {
+		return nil
+	}
+
+	if !s.copies(media.origin.format) {
+		return nil
+	}
+
+	if s.resolution.Copy == medium.Sanitized {
+		origin := *media.origin
+		origin.sanitize = sanitizerOf(media.origin.format, s.profile.KeepICC)
+		return &origin
+	}
+
+	return media.origin
+}
+
+// replica copies bytes of the format, otherwise the image is re-encoded
+func (s Scaler) copies(format string) bool {
+	switch s.resolution.Copy {
+	case medium.Exact:
+		return true
+	case medium.Sanitized:
+		return sanitizerOf(format, s.profile.KeepICC) != nil
+	default:
+		return false
+	}
+}
+
+// format of the media object produced by the scaler
+func (s Scaler) formatOf(media *Media) string {
+	replica := s.replicates()
+
+	switch {
+	case s.resolution.Bitrate != 0:
+		return "mp4"
+	case media.video != nil && replica:
+		return media.video.Container
+	case media.audio != nil && replica:
+		return media.audio.Format
+	case media.audio != nil:
+		return "png"
+	case media.document != nil && replica:
+		return media.document.Format
+	case replica && s.originOf(media) != nil:
+		return media.origin.format
+	case media.vector != nil && replica:
+		return "svg"
+	case media.vector != nil:
+		return "png"
+	case media.animation != nil:
+		return "gif"
+	default:
+		return "jpeg"
+	}
 }
 
 // CropToScale calculates a new dimension of image
@@ -0,0 +1,49 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestScalerFilter(t *testing.T) {
+	// checkerboard of 2x2 cells
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (x/2+y/2)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 0xff})
+			}
+		}
+	}
+
+	// colors of resized image
+	colors := func(filter medium.Filter) map[uint8]bool {
+		s := NewScaler(medium.On("a", ""), medium.ScaleTo("p", 4, 4).Resample(filter))
+		out := s.resize(img, image.Point{X: 4, Y: 4}).(*image.RGBA)
+
+		seq := map[uint8]bool{}
+		for i := 0; i < len(out.Pix); i += 4 {
+			seq[out.Pix[i]] = true
+		}
+		return seq
+	}
+
+	it.Then(t).Should(
+		it.Equiv(colors(medium.Nearest), map[uint8]bool{0: true, 0xff: true}),
+		it.Greater(len(colors(medium.Linear)), 2),
+		it.Greater(len(colors(medium.CatmullRom)), 2),
+		it.Greater(len(colors("")), 2),
+	)
+}
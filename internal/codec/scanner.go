@@ -0,0 +1,193 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/fogfish/swarm"
+)
+
+// Scanner detects malware at raw bytes of media before decoding.
+// It returns the signature of detected malware or empty string.
+type Scanner interface {
+	Scan(context.Context, io.Reader) (string, error)
+}
+
+// scans source object, infected object is rejected
+func (codec *Codec) scan(ctx context.Context, evt swarm.Msg[*events.S3EventRecord]) error {
+	path, err := pathOf(evt.Object)
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+
+	// links are fetched from remote hosts, only uploaded files are scanned
+	if format, _ := codec.reader.isSupported(path); format == MEDIA_LINK {
+		return nil
+	}
+
+	fd, err := codec.reader.fsys.Open(path)
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+	defer fd.Close()
+
+	signature, err := codec.scanner.Scan(ctx, fd)
+	if errors.Is(err, ErrScanLimit) {
+		slog.Warn("media exceeds limit of scanner",
+			slog.String("path", path),
+			"error", err,
+		)
+		codec.sinkRejected(ctx, evt, "scan-limit", "")
+		return err
+	}
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+
+	if signature == "" {
+		return nil
+	}
+
+	slog.Warn("media is infected",
+		slog.String("path", path),
+		slog.String("signature", signature),
+	)
+
+	codec.sinkRejected(ctx, evt, "malware", signature)
+
+	return ErrMalware.With(nil, signature)
+}
+
+func (codec *Codec) sinkRejected(ctx context.Context, evt swarm.Msg[*events.S3EventRecord], reason, signature string, labels ...string) {
+	if codec.emitter.Rejected == nil {
+		return
+	}
+
+	codec.emitter.Rejected.Enq(ctx,
+		MediaRejected{
+			S3EventRecord: *evt.Object,
+			Reason:        reason,
+			Signature:     signature,
+			Labels:        labels,
+		},
+	)
+}
+
+//------------------------------------------------------------------------------
+
+// Clamd is a client of clamd-compatible scanner, it streams bytes using
+// INSTREAM command.
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// clamd INSTREAM chunk size
+const clamdChunk = 64 * 1024
+
+// NewClamd creates client of scanner at tcp://host:port or unix:///path
+func NewClamd(addr string) (*Clamd, error) {
+	uri, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch uri.Scheme {
+	case "tcp":
+		return &Clamd{network: "tcp", address: uri.Host, timeout: 30 * time.Second}, nil
+	case "unix":
+		return &Clamd{network: "unix", address: uri.Path, timeout: 30 * time.Second}, nil
+	default:
+		return nil, errCodecNotSupported.With(nil, uri.Scheme)
+	}
+}
+
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (string, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 4+clamdChunk)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// clamd replies and closes the stream exceeding its limit
+				if reply, _ := bufio.NewReader(conn).ReadString(0); reply != "" {
+					return clamdReply(strings.TrimRight(reply, "\x00\n"))
+				}
+				return "", err
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// zero-length chunk terminates the stream
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return clamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parses reply: "stream: OK", "stream: {signature} FOUND" or "{reason} ERROR"
+func clamdReply(reply string) (string, error) {
+	_, status, _ := strings.Cut(reply, ": ")
+
+	switch {
+	case strings.HasPrefix(reply, "INSTREAM size limit exceeded"):
+		return "", ErrScanLimit.With(nil, "clamd StreamMaxLength")
+	case status == "OK":
+		return "", nil
+	case strings.HasSuffix(status, " FOUND"):
+		return strings.TrimSuffix(status, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
@@ -0,0 +1,189 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+func TestClamd(t *testing.T) {
+	for network, addr := range map[string]string{
+		"tcp":  "127.0.0.1:0",
+		"unix": filepath.Join(t.TempDir(), "clamd.sock"),
+	} {
+		t.Run(network, func(t *testing.T) {
+			uri := newMockClamd(t, network, addr)
+			clamd, err := NewClamd(uri)
+			it.Then(t).Should(it.Nil(err))
+
+			clean, err := clamd.Scan(context.Background(), bytes.NewReader(make([]byte, 3*clamdChunk+7)))
+			it.Then(t).Should(
+				it.Nil(err),
+				it.Equal(clean, ""),
+			)
+
+			infected, err := clamd.Scan(context.Background(), strings.NewReader(eicar))
+			it.Then(t).Should(
+				it.Nil(err),
+				it.Equal(infected, "Eicar-Test-Signature"),
+			)
+		})
+	}
+
+	t.Run("NotSupported", func(t *testing.T) {
+		_, err := NewClamd("http://localhost:3310")
+		it.Then(t).ShouldNot(it.Nil(err))
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		_, err := clamdReply("Unknown ERROR")
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("clamd"),
+			it.Equal(errors.Is(err, ErrScanLimit), false),
+		)
+	})
+
+	t.Run("SizeLimit", func(t *testing.T) {
+		clamd, err := NewClamd(newMockClamd(t, "tcp", "127.0.0.1:0"))
+		it.Then(t).Should(it.Nil(err))
+
+		_, err = clamd.Scan(context.Background(), bytes.NewReader(make([]byte, mockClamdStreamMax+1)))
+		it.Then(t).Should(
+			it.True(errors.Is(err, ErrScanLimit)),
+		)
+	})
+}
+
+func TestCodecScanner(t *testing.T) {
+	profile := medium.On("a", "").Process(medium.ScaleTo("small", 4, 4))
+	clamd, err := NewClamd(newMockClamd(t, "tcp", "127.0.0.1:0"))
+	it.Then(t).Should(it.Nil(err))
+
+	t.Run("Clean", func(t *testing.T) {
+		rfs, wfs := newMockInbox(t, "/a/b.jpg"), newMockFS()
+		codec := NewCodec(profile, rfs, wfs, Emitters{}, WithScanner(clamd))
+
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+		)
+	})
+
+	t.Run("SizeLimit", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.jpg", append(newMockJpeg(t, 16, 12), make([]byte, mockClamdStreamMax)...))
+		emitter := &mockEmitter[MediaRejected]{}
+		codec := NewCodec(profile, rfs, wfs, Emitters{Rejected: emitter}, WithScanner(clamd))
+
+		err := codec.Process(context.Background(), newMockEvent("a/b.jpg"))
+		it.Then(t).Should(
+			it.True(errors.Is(err, ErrScanLimit)),
+			it.Equal(wfs.Len(), 0),
+			it.Equal(len(emitter.events), 1),
+			it.Equal(emitter.events[0].Reason, "scan-limit"),
+		)
+	})
+
+	t.Run("Infected", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.jpg", append(newMockJpeg(t, 16, 12), eicar...))
+		emitter := &mockEmitter[MediaRejected]{}
+		codec := NewCodec(profile, rfs, wfs, Emitters{Rejected: emitter}, WithScanner(clamd))
+
+		err := codec.Process(context.Background(), newMockEvent("a/b.jpg"))
+		it.Then(t).Should(
+			it.True(errors.Is(err, ErrMalware)),
+			it.Equal(wfs.Len(), 0),
+			it.Equal(len(emitter.events), 1),
+			it.Equal(emitter.events[0].Signature, "Eicar-Test-Signature"),
+		)
+	})
+}
+
+// stream limit of fake clamd
+const mockClamdStreamMax = 1 << 20
+
+// fake clamd server, it detects EICAR test signature
+func newMockClamd(t *testing.T, network, addr string) string {
+	t.Helper()
+
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go mockClamdSession(conn)
+		}
+	}()
+
+	if network == "unix" {
+		return "unix://" + ln.Addr().String()
+	}
+	return "tcp://" + ln.Addr().String()
+}
+
+func mockClamdSession(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	cmd, err := r.ReadString(0)
+	if err != nil || cmd != "zINSTREAM\x00" {
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+
+	var data bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+			return
+		}
+
+		// the rest of stream is drained, the connection is not reset
+		if data.Len() > mockClamdStreamMax {
+			conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			io.Copy(io.Discard, r)
+			return
+		}
+	}
+
+	if bytes.Contains(data.Bytes(), []byte(eicar)) {
+		conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		return
+	}
+
+	conn.Write([]byte("stream: OK\x00"))
+}
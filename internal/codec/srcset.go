@@ -0,0 +1,84 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// Candidate of responsive image set
+type Candidate struct {
+	Key        string `json:"key"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Descriptor string `json:"descriptor"` // e.g. 2x or 320w
+}
+
+// Srcset is responsive image set ready for the web client
+type Srcset struct {
+	Srcset     string      `json:"srcset"`          // value of srcset attribute
+	Sizes      string      `json:"sizes,omitempty"` // value of sizes attribute, width descriptors only
+	Candidates []Candidate `json:"candidates"`
+}
+
+// candidates of responsive image sets defined by profile
+func (codec *Codec) srcset(media *Media) map[string][]Candidate {
+	var sets map[string][]Candidate
+	for _, s := range codec.scaler {
+		set, descriptor, ok := s.resolution.Srcset()
+		if !ok {
+			continue
+		}
+
+		var size image.Point
+		if media.image != nil {
+			size = s.sizeOf(media.image.Bounds())
+		}
+
+		if sets == nil {
+			sets = map[string][]Candidate{}
+		}
+
+		sets[set] = append(sets[set], Candidate{
+			Key:        s.pathOf(media),
+			Width:      size.X,
+			Height:     size.Y,
+			Descriptor: descriptor,
+		})
+	}
+
+	return sets
+}
+
+// responsive image set of candidates. The image of width descriptors fills
+// the viewport up to the largest width.
+func newSrcset(candidates []Candidate) *Srcset {
+	srcset := &Srcset{Candidates: make([]Candidate, len(candidates))}
+
+	seq := make([]string, len(candidates))
+	largest := 0
+	for i, c := range candidates {
+		c.Key = strings.TrimPrefix(c.Key, "/")
+		srcset.Candidates[i] = c
+		seq[i] = c.Key + " " + c.Descriptor
+
+		if strings.HasSuffix(c.Descriptor, "w") {
+			largest = max(largest, c.Width)
+		}
+	}
+	srcset.Srcset = strings.Join(seq, ", ")
+
+	if largest != 0 {
+		srcset.Sizes = fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", largest, largest)
+	}
+
+	return srcset
+}
@@ -0,0 +1,89 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestCodecSrcset(t *testing.T) {
+	profile := medium.On("a", "").
+		Process(medium.Replica("origin")).
+		Responsive(
+			medium.Srcset("thumb", 8, 8, 1, 2),
+			medium.WidthLadder("w", 16, 32),
+		)
+
+	rfs, wfs := newMockFS(), newMockFS()
+	rfs.Put("/a/b.jpg", newMockJpeg(t, 64, 32))
+	emitter := &mockEmitter[MediaPublished]{}
+
+	codec := NewCodec(profile, rfs, wfs, Emitters{Published: emitter})
+	it.Then(t).Should(
+		it.Nil(codec.Process(context.Background(), newMockEvent("a/b.jpg"))),
+		it.True(wfs.Has("/a/b.origin.jpg")),
+		it.Equal(len(emitter.events), 1),
+		it.Equiv(emitter.events[0].Srcset["thumb"], &Srcset{
+			Srcset: "a/b.thumb.1x-8x8.jpg 1x, a/b.thumb.2x-16x16.jpg 2x",
+			Candidates: []Candidate{
+				{Key: "a/b.thumb.1x-8x8.jpg", Width: 8, Height: 8, Descriptor: "1x"},
+				{Key: "a/b.thumb.2x-16x16.jpg", Width: 16, Height: 16, Descriptor: "2x"},
+			},
+		}),
+		it.Equiv(emitter.events[0].Srcset["w"], &Srcset{
+			Srcset: "a/b.w.16w-16x0.jpg 16w, a/b.w.32w-32x0.jpg 32w",
+			Sizes:  "(max-width: 32px) 100vw, 32px",
+			Candidates: []Candidate{
+				{Key: "a/b.w.16w-16x0.jpg", Width: 16, Height: 8, Descriptor: "16w"},
+				{Key: "a/b.w.32w-32x0.jpg", Width: 32, Height: 16, Descriptor: "32w"},
+			},
+		}),
+	)
+
+	// width ladder keeps aspect ratio
+	for path, size := range map[string]image.Rectangle{
+		"/a/b.thumb.2x-16x16.jpg": image.Rect(0, 0, 16, 16),
+		"/a/b.w.32w-32x0.jpg":     image.Rect(0, 0, 32, 16),
+	} {
+		img, _, err := image.Decode(bytes.NewReader(wfs.files[path]))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), size),
+		)
+	}
+}
+
+func TestCodecSingleDimension(t *testing.T) {
+	// resolution of single dimension outside of width ladder is replica
+	a, err := medium.NewResolution("a-16x0")
+	it.Then(t).Should(it.Nil(err))
+
+	profile := medium.On("a", "").Process(a, medium.ScaleTo("b", 0, 16))
+
+	rfs, wfs := newMockFS(), newMockFS()
+	rfs.Put("/a/b.jpg", newMockJpeg(t, 64, 32))
+
+	it.Then(t).Should(
+		it.Nil(NewCodec(profile, rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.jpg"))),
+	)
+
+	for _, path := range []string{"/a/b.a-16x0.jpg", "/a/b.b-0x16.jpg"} {
+		img, _, err := image.Decode(bytes.NewReader(wfs.files[path]))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 64, 32)),
+		)
+	}
+}
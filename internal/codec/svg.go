@@ -0,0 +1,262 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Rasterizer renders SVG into image of the given size, zero size is
+// the intrinsic size of SVG.
+type Rasterizer interface {
+	Rasterize(svg []byte, width, height int) (image.Image, error)
+}
+
+// elements removed from SVG with its content
+var svgUnsafeElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"handler":       true,
+	"listener":      true,
+	// animation sets attributes (e.g. href) to javascript: via to/values
+	"set":              true,
+	"animate":          true,
+	"animatetransform": true,
+	"animatemotion":    true,
+}
+
+var (
+	// url(...) of CSS, except fragment references
+	svgExternalURL = regexp.MustCompile(`(?i)url\(\s*['"]?\s*[^#'"\s)][^)]*\)`)
+	svgImport      = regexp.MustCompile(`(?i)@import[^;]*;?`)
+	svgExpression  = regexp.MustCompile(`(?i)expression\s*\(`)
+
+	// escapes, image-set and @font-face might hide external references
+	svgUnsafeCSS = regexp.MustCompile(`(?i)\\|image-set|@font-face`)
+)
+
+// SanitizeSVG removes scripts, animations, event handlers and external
+// references from SVG. Comments, processing instructions and DTD are removed
+// as well.
+func SanitizeSVG(data []byte) ([]byte, error) {
+	var (
+		buf   bytes.Buffer
+		skip  int // depth of removed element
+		root  bool
+		stack []xml.Name // raw tokens are not verified by decoder
+		style []byte     // content of style element, CDATA might split it
+	)
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = true
+
+	for {
+		token, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+			if skip > 0 {
+				skip++
+				continue
+			}
+
+			if !root {
+				if !strings.EqualFold(t.Name.Local, "svg") {
+					return nil, fmt.Errorf("svg: root element is %s", t.Name.Local)
+				}
+				root = true
+			}
+
+			if svgUnsafeElements[strings.ToLower(t.Name.Local)] {
+				skip = 1
+				continue
+			}
+
+			buf.WriteString("<" + svgName(t.Name))
+			for _, attr := range t.Attr {
+				if value, ok := svgAttr(attr); ok {
+					buf.WriteString(" " + svgName(attr.Name) + `="`)
+					xml.EscapeText(&buf, []byte(value))
+					buf.WriteString(`"`)
+				}
+			}
+			buf.WriteString(">")
+
+		case xml.EndElement:
+			if len(stack) == 0 || stack[len(stack)-1] != t.Name {
+				return nil, fmt.Errorf("svg: unexpected end element %s", t.Name.Local)
+			}
+			stack = stack[:len(stack)-1]
+
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if strings.EqualFold(t.Name.Local, "style") {
+				xml.EscapeText(&buf, svgStyle(style))
+				style = nil
+			}
+			buf.WriteString("</" + svgName(t.Name) + ">")
+
+		case xml.CharData:
+			if skip > 0 || !root {
+				continue
+			}
+			if len(stack) > 0 && strings.EqualFold(stack[len(stack)-1].Local, "style") {
+				style = append(style, t...)
+				continue
+			}
+			xml.EscapeText(&buf, svgText(t))
+		}
+	}
+
+	if !root || len(stack) != 0 {
+		return nil, fmt.Errorf("svg: root element is not found")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func svgName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// sanitizes attribute, returns false if attribute is removed
+func svgAttr(attr xml.Attr) (string, bool) {
+	name := strings.ToLower(attr.Name.Local)
+	value := strings.TrimSpace(attr.Value)
+
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return "", false
+	case name == "href" || name == "src":
+		lower := strings.ToLower(value)
+		safe := strings.HasPrefix(lower, "#") ||
+			strings.HasPrefix(lower, "data:image/png") ||
+			strings.HasPrefix(lower, "data:image/jpeg") ||
+			strings.HasPrefix(lower, "data:image/gif")
+		return attr.Value, safe
+	case name == "style":
+		css := svgStyle([]byte(attr.Value))
+		return string(css), len(css) != 0
+	}
+
+	// presentation attributes might refer external resources
+	// e.g. fill="url(https://...)"
+	if svgExternalURL.MatchString(value) || svgUnsafeCSS.MatchString(value) {
+		return "", false
+	}
+
+	return attr.Value, true
+}
+
+// sanitizes CSS, the style is removed if it might hide external references
+func svgStyle(css []byte) []byte {
+	if svgUnsafeCSS.Match(css) {
+		return nil
+	}
+	return svgText(css)
+}
+
+// removes external references from text
+func svgText(text []byte) []byte {
+	text = svgImport.ReplaceAll(text, nil)
+	text = svgExternalURL.ReplaceAll(text, []byte("none"))
+	text = svgExpression.ReplaceAll(text, []byte("("))
+	return text
+}
+
+//------------------------------------------------------------------------------
+
+// fetches SVG media, the sanitized SVG is kept along with its intrinsic
+// raster, variants are rasterized at target size.
+func (r Reader) fetchMediaSvg(_ context.Context, path string) (*Media, error) {
+	if r.rasterizer == nil {
+		return nil, errCodecNotSupported.With(nil, MEDIA_SVG)
+	}
+
+	fd, err := r.fsys.Open(path)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	defer fd.Close()
+
+	data, err := r.budget.readAll(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	svg, err := SanitizeSVG(data)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	img, err := r.rasterizer.Rasterize(svg, 0, 0)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	// intrinsic size is known after rasterization
+	if err := r.budget.fit(int64(len(data)+len(svg)) + sizeOfImage(img)); err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(data)
+
+	return &Media{
+		path:   path,
+		hash:   hex.EncodeToString(hash[:]),
+		image:  img,
+		vector: &Vector{svg: svg, rasterizer: r.rasterizer},
+	}, nil
+}
+
+// Vector media
+type Vector struct {
+	svg        []byte
+	rasterizer Rasterizer
+}
+
+func (v *Vector) SVG() []byte { return v.svg }
+
+// rasterize vector to cover the target size preserving aspect ratio
+func (v *Vector) rasterize(intrinsic image.Rectangle, width, height int) (image.Image, error) {
+	scale := math.Max(
+		float64(width)/float64(intrinsic.Dx()),
+		float64(height)/float64(intrinsic.Dy()),
+	)
+
+	return v.rasterizer.Rasterize(v.svg,
+		int(math.Ceil(float64(intrinsic.Dx())*scale)),
+		int(math.Ceil(float64(intrinsic.Dy())*scale)),
+	)
+}
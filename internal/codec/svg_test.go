@@ -0,0 +1,183 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	t.Run("Sanitize", func(t *testing.T) {
+		for input, expect := range map[string]string{
+			`<svg><rect width="1"/></svg>`:                                                                `<svg><rect width="1"></rect></svg>`,
+			`<?xml version="1.0"?><!DOCTYPE svg><!-- x --><svg/>`:                                         `<svg></svg>`,
+			`<svg onload="alert(1)"><rect onclick="x()"/></svg>`:                                          `<svg><rect></rect></svg>`,
+			`<svg><script>alert(1)</script><g/></svg>`:                                                    `<svg><g></g></svg>`,
+			`<svg><foreignObject><iframe/></foreignObject></svg>`:                                         `<svg></svg>`,
+			`<svg><use xlink:href="#a"/><use href="https://x/y.svg#a"/></svg>`:                            `<svg><use xlink:href="#a"></use><use></use></svg>`,
+			`<svg><image href="data:image/png;base64,AA"/><image href="data:image/svg+xml,x"/></svg>`:     `<svg><image href="data:image/png;base64,AA"></image><image></image></svg>`,
+			`<svg><a href="javascript:alert(1)"><g/></a></svg>`:                                           `<svg><a><g></g></a></svg>`,
+			`<svg><rect fill="url(#g)" stroke="url(https://x/y#g)"/></svg>`:                               `<svg><rect fill="url(#g)"></rect></svg>`,
+			`<svg><rect style="fill:url(https://x/y)"/></svg>`:                                            `<svg><rect style="fill:none"></rect></svg>`,
+			`<svg><a><set attributeName="href" to="javascript:alert(1)"/><g/></a></svg>`:                  `<svg><a><g></g></a></svg>`,
+			`<svg><a><animate attributeName="href" values="javascript:alert(1)"/></a></svg>`:              `<svg><a></a></svg>`,
+			`<svg><g><animateTransform attributeName="transform"/><animateMotion path="M0,0"/></g></svg>`: `<svg><g></g></svg>`,
+			`<svg><style>@import url(https://x/y.css); .a{fill:url(#g)}</style></svg>`:                    `<svg><style> .a{fill:url(#g)}</style></svg>`,
+		} {
+			svg, err := SanitizeSVG([]byte(input))
+			it.Then(t).Should(
+				it.Nil(err),
+				it.Equal(string(svg), expect),
+			)
+		}
+	})
+
+	t.Run("UnsafeCSS", func(t *testing.T) {
+		for input, expect := range map[string]string{
+			`<svg><style>.a{fill:\75 rl(http://x/y)}</style></svg>`:                          `<svg><style></style></svg>`,
+			`<svg><style>.a{fill:u\rl(http://x/y)}</style></svg>`:                            `<svg><style></style></svg>`,
+			`<svg><style>.a{fill:&#92;75 rl(http://x/y)}</style></svg>`:                      `<svg><style></style></svg>`,
+			`<svg><style>u<![CDATA[rl(http://x/y)]]></style></svg>`:                          `<svg><style>none</style></svg>`,
+			`<svg><style>.a{background:image-set("http://x/y" 1x)}</style></svg>`:            `<svg><style></style></svg>`,
+			`<svg><style>@font-face{font-family:a;src:url(http://x/y)}</style></svg>`:        `<svg><style></style></svg>`,
+			`<svg><style>@FONT-FACE{font-family:a;src:local(a)}</style></svg>`:               `<svg><style></style></svg>`,
+			`<svg><rect style="fill:\75 rl(http://x/y)"/><rect style="fill:u\rl(x)"/></svg>`: `<svg><rect></rect><rect></rect></svg>`,
+			`<svg><rect style="fill:image-set('http://x/y' 1x)"/></svg>`:                     `<svg><rect></rect></svg>`,
+			`<svg><rect fill="\75 rl(http://x/y)"/></svg>`:                                   `<svg><rect></rect></svg>`,
+		} {
+			svg, err := SanitizeSVG([]byte(input))
+			it.Then(t).Should(
+				it.Nil(err),
+				it.Equal(string(svg), expect),
+			)
+		}
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		for _, input := range []string{
+			``,
+			`<html><svg/></html>`,
+			`<svg><rect></svg>`,
+			`<!DOCTYPE svg [<!ENTITY x "y">]><svg>&x;</svg>`,
+		} {
+			_, err := SanitizeSVG([]byte(input))
+			it.Then(t).ShouldNot(it.Nil(err))
+		}
+	})
+}
+
+func TestCodecSVG(t *testing.T) {
+	profile := medium.On("a", "").Process(
+		medium.ScaleTo("small", 4, 4),
+		medium.Replica("origin"),
+	)
+
+	t.Run("Rasterize", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.svg", []byte(`<svg onload="x()" width="64" height="32"><script/></svg>`))
+		raster := &mockRasterizer{}
+
+		codec := NewCodec(profile, rfs, wfs, Emitters{}, WithRasterizer(raster))
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.svg"))),
+			it.Equal(string(wfs.files["/a/b.origin.svg"]), `<svg width="64" height="32"></svg>`),
+			// raster covers the target size
+			it.Seq(raster.sizes).Equal(image.Pt(0, 0), image.Pt(8, 4)),
+		)
+
+		img, err := png.Decode(bytes.NewReader(wfs.files["/a/b.small-4x4.png"]))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 4, 4)),
+		)
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.svg", []byte(`<svg/>`))
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.svg"))
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("svg"),
+		)
+	})
+}
+
+func TestRsvg(t *testing.T) {
+	rsvg := func(script string) *Rsvg {
+		dir := t.TempDir()
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 8, 4))); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "image.png"), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		bin := filepath.Join(dir, "rsvg-convert")
+		script = strings.ReplaceAll(script, "{image}", filepath.Join(dir, "image.png"))
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return NewRsvg(bin)
+	}
+
+	t.Run("Rasterize", func(t *testing.T) {
+		f := rsvg(`[ "$*" = "--format png --width 8 --height 4" ] && [ "$(cat)" = "<svg/>" ] && cat {image}`)
+
+		img, err := f.Rasterize([]byte("<svg/>"), 8, 4)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 8, 4)),
+		)
+	})
+
+	t.Run("Intrinsic", func(t *testing.T) {
+		f := rsvg(`[ "$*" = "--format png" ] && cat >/dev/null && cat {image}`)
+
+		img, err := f.Rasterize([]byte("<svg/>"), 0, 0)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 8, 4)),
+		)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		f := rsvg(`echo "invalid svg" >&2; exit 1`)
+
+		_, err := f.Rasterize([]byte("<svg/>"), 0, 0)
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("invalid svg"),
+		)
+	})
+}
+
+// rasterizer of 64x32 intrinsic size
+type mockRasterizer struct {
+	sizes []image.Point
+}
+
+func (r *mockRasterizer) Rasterize(svg []byte, w, h int) (image.Image, error) {
+	r.sizes = append(r.sizes, image.Pt(w, h))
+	if w == 0 || h == 0 {
+		w, h = 64, 32
+	}
+	return image.NewNRGBA(image.Rect(0, 0, w, h)), nil
+}
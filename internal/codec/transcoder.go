@@ -0,0 +1,208 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fogfish/medium"
+)
+
+// Transcoder encodes video into the rendition. The rendition is written into
+// the directory as H.264/AAC fragmented MP4 (video.mp4) along with HLS media
+// playlist (video.m3u8) that addresses the video by its name.
+type Transcoder interface {
+	Transcode(ctx context.Context, video io.Reader, rendition medium.Resolution, dir string) error
+}
+
+// Files produced by the transcoder
+const (
+	renditionVideo    = "video.mp4"
+	renditionPlaylist = "video.m3u8"
+)
+
+const contentTypeHLS = "application/vnd.apple.mpegurl"
+
+// bitrate of audio track, kbit/s
+const renditionAudioBitrate = 128
+
+// Rendition of video media
+type Rendition struct {
+	Label    string `json:"label"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Bitrate  int    `json:"bitrate"`  // kbit/s
+	Key      string `json:"key"`      // S3 key of video
+	Playlist string `json:"playlist"` // S3 key of HLS media playlist
+}
+
+// transcodes video into the rendition, the video is kept at local directory
+// until it is published.
+func (s Scaler) transcode(ctx context.Context, media *Media) (*Media, error) {
+	if media.video == nil || media.origin == nil {
+		return nil, errCodecNotSupported.With(nil, "rendition of "+media.format)
+	}
+
+	if s.transcoder == nil {
+		return nil, errCodecNotSupported.With(nil, "rendition")
+	}
+
+	source, err := media.origin.fsys.Open(media.origin.path)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	defer source.Close()
+
+	dir, err := os.MkdirTemp("", "rendition-")
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	if err := s.transcoder.Transcode(ctx, source, s.resolution, dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, errCodecIO.With(err)
+	}
+
+	m3u8, err := os.ReadFile(filepath.Join(dir, renditionPlaylist))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, errCodecIO.With(err)
+	}
+
+	path := s.pathOf(media)
+	playlist := s.keyOf(media, "m3u8")
+
+	return &Media{
+		path:   path,
+		format: s.formatOf(media),
+		hash:   media.hash,
+		image:  media.image,
+		origin: &Origin{fsys: os.DirFS(dir), path: renditionVideo, contentType: "video/mp4"},
+		sidecars: []Sidecar{
+			{
+				path: playlist,
+				meta: &Meta{ContentType: contentTypeHLS},
+				data: relocatePlaylist(m3u8, renditionVideo, relativeKey(playlist, path)),
+			},
+		},
+		scratch: dir,
+	}, nil
+}
+
+// fails if the source and renditions of video do not fit into local storage,
+// the size of rendition is estimated from its bitrate, renditions are
+// transcoded concurrently.
+func (codec *Codec) fitStorage(media *Media) error {
+	if codec.reader.storage == 0 || media.video == nil {
+		return nil
+	}
+
+	seconds := int64(math.Ceil(media.video.Duration.Seconds()))
+	required := media.video.size
+	for _, r := range codec.renditions(media) {
+		required += int64(r.Bitrate+renditionAudioBitrate) * 1000 / 8 * seconds
+	}
+
+	if required > codec.reader.storage {
+		return ErrStorageBudget.With(nil, int(required>>20), int(codec.reader.storage>>20))
+	}
+
+	return nil
+}
+
+// renditions of media published by the codec
+func (codec *Codec) renditions(media *Media) []Rendition {
+	var seq []Rendition
+	for _, s := range codec.scaler {
+		if s.resolution.Bitrate == 0 {
+			continue
+		}
+
+		seq = append(seq, Rendition{
+			Label:    s.resolution.Label,
+			Width:    s.resolution.Width,
+			Height:   s.resolution.Height,
+			Bitrate:  s.resolution.Bitrate,
+			Key:      s.pathOf(media),
+			Playlist: s.keyOf(media, "m3u8"),
+		})
+	}
+
+	return seq
+}
+
+// HLS master playlist of renditions
+func (codec *Codec) playlist(media *Media) *Sidecar {
+	renditions := codec.renditions(media)
+	if len(renditions) == 0 {
+		return nil
+	}
+
+	path := codec.keyOf(media, "hls", "m3u8")
+
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, r := range renditions {
+		fmt.Fprintf(&buf, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n",
+			(r.Bitrate+renditionAudioBitrate)*1000, r.Width, r.Height,
+		)
+		buf.WriteString(relativeKey(path, r.Playlist) + "\n")
+	}
+
+	return &Sidecar{
+		path: path,
+		meta: &Meta{ContentType: contentTypeHLS},
+		data: buf.Bytes(),
+	}
+}
+
+// Media playlist addresses the video by its local name, the name is replaced
+// with URI relative to the playlist.
+func relocatePlaylist(m3u8 []byte, name, uri string) []byte {
+	var buf bytes.Buffer
+
+	scanner := bufio.NewScanner(bytes.NewReader(m3u8))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == name:
+			line = uri
+		case strings.HasPrefix(line, "#"):
+			line = strings.ReplaceAll(line, `URI="`+name+`"`, `URI="`+uri+`"`)
+		}
+		buf.WriteString(line + "\n")
+	}
+
+	return buf.Bytes()
+}
+
+// key of object relative to the key of playlist
+func relativeKey(playlist, key string) string {
+	rel, err := filepath.Rel(filepath.Dir(playlist), key)
+	if err != nil {
+		return key
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// removes local directory of transcoded media
+func (media *Media) release() {
+	if media.scratch != "" {
+		os.RemoveAll(media.scratch)
+	}
+}
@@ -0,0 +1,305 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestCodecRendition(t *testing.T) {
+	profile := medium.On("a", "").
+		Process(
+			medium.ScaleTo("small", 4, 4),
+			medium.Rendition("sd", 8, 4, 500),
+			medium.Rendition("hd", 16, 8, 1500),
+		)
+
+	t.Run("Transcode", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.mp4", newMockMp4("isom", 0))
+		transcoder := &mockTranscoder{}
+		emitter := &mockEmitter[MediaPublished]{}
+
+		codec := NewCodec(profile, rfs, wfs, Emitters{Published: emitter},
+			WithFrameDecoder(&mockFrameDecoder{}),
+			WithTranscoder(transcoder),
+		)
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.mp4"))),
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+			it.Equal(string(wfs.files["/a/b.sd-8x4.mp4"]), "video 8x4 500k"),
+			it.Equal(string(wfs.files["/a/b.hd-16x8.mp4"]), "video 16x8 1500k"),
+			it.Equal(wfs.meta["/a/b.hd-16x8.mp4"].ContentType, "video/mp4"),
+			it.Equal(string(wfs.files["/a/b.hd-16x8.m3u8"]),
+				"#EXTM3U\n#EXT-X-MAP:URI=\"b.hd-16x8.mp4\",BYTERANGE=\"8@0\"\n#EXTINF:6.0,\nb.hd-16x8.mp4\n#EXT-X-ENDLIST\n",
+			),
+			it.Equal(string(wfs.files["/a/b.hls.m3u8"]),
+				"#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n"+
+					"#EXT-X-STREAM-INF:BANDWIDTH=628000,RESOLUTION=8x4\nb.sd-8x4.m3u8\n"+
+					"#EXT-X-STREAM-INF:BANDWIDTH=1628000,RESOLUTION=16x8\nb.hd-16x8.m3u8\n",
+			),
+			it.Equal(wfs.meta["/a/b.hls.m3u8"].ContentType, "application/vnd.apple.mpegurl"),
+			it.Equal(len(emitter.events), 1),
+			it.Equal(emitter.events[0].Playlist, "a/b.hls.m3u8"),
+			it.Seq(emitter.events[0].Renditions).Equal(
+				Rendition{Label: "sd", Width: 8, Height: 4, Bitrate: 500, Key: "a/b.sd-8x4.mp4", Playlist: "a/b.sd-8x4.m3u8"},
+				Rendition{Label: "hd", Width: 16, Height: 8, Bitrate: 1500, Key: "a/b.hd-16x8.mp4", Playlist: "a/b.hd-16x8.m3u8"},
+			),
+		)
+
+		// local files of renditions are removed
+		for _, dir := range transcoder.dirs {
+			_, err := os.Stat(dir)
+			it.Then(t).Should(it.True(os.IsNotExist(err)))
+		}
+
+		// all objects are removed
+		it.Then(t).Should(
+			it.Nil(codec.Remove(context.Background(), newMockEvent("a/b.mp4"))),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("Atomic", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.mp4", newMockMp4("isom", 0))
+
+		codec := NewCodec(profile.Atomically(), rfs, wfs, Emitters{},
+			WithFrameDecoder(&mockFrameDecoder{}),
+			WithTranscoder(&mockTranscoder{}),
+		)
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.mp4"))),
+			it.True(wfs.Has("/a/b.hd-16x8.mp4")),
+			it.True(wfs.Has("/a/b.hd-16x8.m3u8")),
+			it.True(wfs.Has("/a/b.hls.m3u8")),
+		)
+	})
+
+	t.Run("OutputTo", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.mp4", newMockMp4("isom", 0))
+
+		codec := NewCodec(profile.OutputTo("{dir}/{name}/{label}.{ext}"), rfs, wfs, Emitters{},
+			WithFrameDecoder(&mockFrameDecoder{}),
+			WithTranscoder(&mockTranscoder{}),
+		)
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.mp4"))),
+			it.True(wfs.Has("/a/b/hd.mp4")),
+			it.Equal(string(wfs.files["/a/b/hls.m3u8"]),
+				"#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n"+
+					"#EXT-X-STREAM-INF:BANDWIDTH=628000,RESOLUTION=8x4\nsd.m3u8\n"+
+					"#EXT-X-STREAM-INF:BANDWIDTH=1628000,RESOLUTION=16x8\nhd.m3u8\n",
+			),
+		)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.mp4", newMockMp4("isom", 0))
+
+		codec := NewCodec(profile.Atomically(), rfs, wfs, Emitters{},
+			WithFrameDecoder(&mockFrameDecoder{}),
+			WithTranscoder(&mockTranscoder{fail: "hd"}),
+		)
+		it.Then(t).Should(
+			it.Fail(func() error { return codec.Process(context.Background(), newMockEvent("a/b.mp4")) }).Contain("transcoder failed"),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("LocalSource", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.mp4", newMockMp4("isom", 0))
+		frames, transcoder := &mockFrameDecoder{}, &mockTranscoder{}
+
+		codec := NewCodec(profile, rfs, wfs, Emitters{},
+			WithFrameDecoder(frames),
+			WithTranscoder(transcoder),
+		)
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.mp4"))),
+			it.Equal(len(frames.sources), 1),
+			it.Seq(transcoder.sources).Equal(frames.sources[0], frames.sources[0]),
+		)
+
+		// the source is copied once and removed after processing
+		_, err := os.Stat(frames.sources[0])
+		it.Then(t).Should(it.True(os.IsNotExist(err)))
+	})
+
+	t.Run("StorageExceeded", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.mp4", newMockMp4("isom", 0))
+		rfs.Put("/a/c.mp4", append(newMockMp4("isom", 0), mockBox("free", make([]byte, 1<<20))...))
+
+		// 4 MB of renditions: 2.5 seconds at 12.5 MB/s
+		hd := medium.On("a", "").Process(medium.Rendition("hd", 16, 8, 100000))
+		codec := NewCodec(hd, rfs, wfs, Emitters{},
+			WithFrameDecoder(&mockFrameDecoder{}),
+			WithTranscoder(&mockTranscoder{}),
+			WithStorageBudget(1),
+		)
+
+		err1 := codec.Process(context.Background(), newMockEvent("a/b.mp4"))
+		err2 := codec.Process(context.Background(), newMockEvent("a/c.mp4"))
+		it.Then(t).Should(
+			it.True(errors.Is(err1, ErrStorageBudget)),
+			it.True(errors.Is(err2, ErrStorageBudget)),
+			it.Equal(wfs.Len(), 0),
+		)
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.mp4", newMockMp4("isom", 0))
+		rfs.Put("/a/b.jpg", newMockJpeg(t, 32, 32))
+
+		codec := NewCodec(profile, rfs, wfs, Emitters{}, WithFrameDecoder(&mockFrameDecoder{}))
+		it.Then(t).Should(
+			it.Fail(func() error { return codec.Process(context.Background(), newMockEvent("a/b.mp4")) }).Contain("rendition"),
+		)
+
+		codec = NewCodec(profile, rfs, wfs, Emitters{}, WithTranscoder(&mockTranscoder{}))
+		it.Then(t).Should(
+			it.Fail(func() error { return codec.Process(context.Background(), newMockEvent("a/b.jpg")) }).Contain("rendition"),
+		)
+	})
+}
+
+func TestFFmpeg(t *testing.T) {
+	ffmpeg := func(script string) *FFmpeg {
+		bin := filepath.Join(t.TempDir(), "ffmpeg")
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return NewFFmpeg(bin)
+	}
+
+	t.Run("Transcode", func(t *testing.T) {
+		dir := t.TempDir()
+		f := ffmpeg(`
+for playlist; do :; done
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -i) source="$2" ;;
+    -hls_segment_filename) segment="$2" ;;
+    -b:v) bitrate="$2" ;;
+    -vf) filter="$2" ;;
+  esac
+  shift
+done
+printf '%s %s %s' "$(cat "$source")" "$filter" "$bitrate" > "$segment"
+printf '#EXTM3U\nvideo.mp4\n' > "$playlist"
+`)
+
+		err := f.Transcode(context.Background(), strings.NewReader("mp4"), medium.Rendition("hd", 16, 8, 1500), dir)
+		video, _ := os.ReadFile(filepath.Join(dir, "video.mp4"))
+		_, source := os.Stat(filepath.Join(dir, "source"))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(string(video), "mp4 scale=16:8:force_original_aspect_ratio=increase,crop=16:8,setsar=1 1500k"),
+			it.True(os.IsNotExist(source)),
+		)
+	})
+
+	t.Run("LocalSource", func(t *testing.T) {
+		dir := t.TempDir()
+		local := filepath.Join(t.TempDir(), "video")
+		os.WriteFile(local, []byte("mp4"), 0644)
+
+		f := ffmpeg(`[ "$7" = "` + local + `" ] && printf '#EXTM3U\n' > "$(for playlist; do :; done; echo "$playlist")"`)
+
+		fd, err := os.Open(local)
+		it.Then(t).Should(it.Nil(err))
+		defer fd.Close()
+
+		err = f.Transcode(context.Background(), fd, medium.Rendition("hd", 16, 8, 1500), dir)
+		_, source := os.Stat(filepath.Join(dir, "source"))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.True(os.IsNotExist(source)),
+		)
+	})
+
+	t.Run("Frame", func(t *testing.T) {
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 2)))
+		frame := filepath.Join(t.TempDir(), "frame.png")
+		os.WriteFile(frame, buf.Bytes(), 0644)
+
+		f := ffmpeg(`[ "$5" = "-noautorotate" ] && [ "$7" = "1.500" ] && cat ` + frame)
+
+		img, err := f.Frame(context.Background(), strings.NewReader("mp4"), 1500*time.Millisecond)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(img.Bounds(), image.Rect(0, 0, 4, 2)),
+		)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		f := ffmpeg(`echo "invalid input" >&2; exit 1`)
+
+		err := f.Transcode(context.Background(), strings.NewReader("mp4"), medium.Rendition("hd", 16, 8, 1500), t.TempDir())
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("invalid input"),
+		)
+	})
+}
+
+// transcoder writes description of the rendition as video
+type mockTranscoder struct {
+	sync.Mutex
+	fail    string
+	dirs    []string
+	sources []string // local files
+}
+
+func (m *mockTranscoder) Transcode(_ context.Context, video io.Reader, r medium.Resolution, dir string) error {
+	m.Lock()
+	m.dirs = append(m.dirs, dir)
+	if fd, ok := video.(*os.File); ok {
+		m.sources = append(m.sources, fd.Name())
+	}
+	m.Unlock()
+
+	if _, err := io.ReadAll(video); err != nil {
+		return err
+	}
+
+	if r.Label == m.fail {
+		return fmt.Errorf("transcoder failed")
+	}
+
+	data := fmt.Sprintf("video %dx%d %dk", r.Width, r.Height, r.Bitrate)
+	if err := os.WriteFile(filepath.Join(dir, "video.mp4"), []byte(data), 0644); err != nil {
+		return err
+	}
+
+	playlist := "#EXTM3U\n#EXT-X-MAP:URI=\"video.mp4\",BYTERANGE=\"8@0\"\n#EXTINF:6.0,\nvideo.mp4\n#EXT-X-ENDLIST\n"
+	return os.WriteFile(filepath.Join(dir, "video.m3u8"), []byte(playlist), 0644)
+}
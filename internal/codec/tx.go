@@ -0,0 +1,209 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sync"
+)
+
+// Staging area for media objects published by transaction
+const stagingPrefix = "/.staging"
+
+// Objects published previously are copied aside within the staging area
+const previousPrefix = "/.previous"
+
+// Transaction publishes media objects all-or-nothing. Objects are written
+// into the staging area and promoted to the final location on commit.
+type Tx struct {
+	writer   Writer
+	stage    string
+	mu       sync.Mutex
+	staged   map[string]*Meta
+	previous map[string]bool
+}
+
+// Begin the transaction
+func (wrt Writer) Begin() (*Tx, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	return &Tx{
+		writer:   wrt,
+		stage:    filepath.Join(stagingPrefix, hex.EncodeToString(id)),
+		staged:   map[string]*Meta{},
+		previous: map[string]bool{},
+	}, nil
+}
+
+// Put media object into staging area
+func (tx *Tx) Put(ctx context.Context, media *Media) error {
+	path, meta, err := tx.put(media)
+	if err != nil {
+		return err
+	}
+
+	tx.mu.Lock()
+	tx.staged[path] = meta
+	tx.mu.Unlock()
+
+	for _, sidecar := range media.sidecars {
+		if err := tx.PutSidecar(ctx, sidecar); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PutSidecar object into staging area
+func (tx *Tx) PutSidecar(ctx context.Context, sidecar Sidecar) error {
+	if err := tx.writer.write(tx.stage+sidecar.path, sidecar.meta, sidecar.data); err != nil {
+		return err
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.staged[sidecar.path] = sidecar.meta
+
+	return nil
+}
+
+func (tx *Tx) put(media *Media) (string, *Meta, error) {
+	if media.origin != nil {
+		meta, err := tx.writer.replicate(tx.stage+media.path, media.origin)
+		return media.path, meta, err
+	}
+
+	path, meta, data, err := tx.writer.encode(media)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return path, meta, tx.writer.write(tx.stage+path, meta, data)
+}
+
+// Commit promotes staged media objects. Objects published previously are
+// copied aside before the promotion, they are restored if any of promotion
+// fails. New objects are removed in this case.
+func (tx *Tx) Commit(ctx context.Context) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	defer tx.rollback()
+
+	if err := tx.copyAside(); err != nil {
+		return errCodecIO.With(err)
+	}
+
+	promoted := make([]string, 0, len(tx.staged))
+	for path, meta := range tx.staged {
+		promoted = append(promoted, path)
+		if err := tx.copy(tx.stage+path, path, meta); err != nil {
+			tx.restore(promoted)
+			return errCodecIO.With(err)
+		}
+	}
+
+	return nil
+}
+
+// Rollback discards staged media objects
+func (tx *Tx) Rollback(ctx context.Context) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	tx.rollback()
+}
+
+func (tx *Tx) rollback() {
+	for path := range tx.staged {
+		tx.writer.remove(tx.stage + path)
+	}
+	for path := range tx.previous {
+		tx.writer.remove(tx.aside(path))
+	}
+	tx.staged = map[string]*Meta{}
+	tx.previous = map[string]bool{}
+}
+
+// location of previously published object within the staging area
+func (tx *Tx) aside(path string) string {
+	return tx.stage + previousPrefix + path
+}
+
+// copies aside objects published previously at the paths of staged objects
+func (tx *Tx) copyAside() error {
+	for path := range tx.staged {
+		if _, err := fs.Stat(tx.writer.fsys, path); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+
+		if err := tx.copy(path, tx.aside(path), nil); err != nil {
+			return err
+		}
+		tx.previous[path] = true
+	}
+
+	return nil
+}
+
+// restores objects published previously, new objects are removed
+func (tx *Tx) restore(promoted []string) {
+	for _, path := range promoted {
+		if !tx.previous[path] {
+			tx.writer.remove(path)
+			continue
+		}
+
+		if err := tx.copy(tx.aside(path), path, nil); err != nil {
+			slog.Error("failed to restore media object",
+				slog.String("stage", tx.stage),
+				slog.String("path", path),
+				"error", err,
+			)
+		}
+	}
+}
+
+func (tx *Tx) copy(source, target string, meta *Meta) error {
+	slog.Debug("copy media object",
+		slog.String("source", source),
+		slog.String("target", target),
+	)
+
+	// server side copy is preferred if file system supports it, it keeps
+	// metadata of the object
+	if fsys, ok := tx.writer.fsys.(interface{ Copy(string, string) error }); ok {
+		return fsys.Copy(source, target)
+	}
+
+	fd, err := tx.writer.fsys.Open(source)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	// metadata of the source is kept by the copy
+	if meta == nil {
+		meta = tx.writer.metaOf(fd)
+	}
+
+	return tx.writer.stream(target, meta, fd)
+}
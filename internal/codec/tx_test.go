@@ -0,0 +1,126 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+)
+
+func TestTx(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+
+	t.Run("Commit", func(t *testing.T) {
+		fsys := newMockFS()
+		tx, _ := NewWriter(fsys).Begin()
+
+		it.Then(t).Should(
+			it.Nil(tx.Put(context.Background(), &Media{path: "/a/b.x.jpg", image: img})),
+			it.Nil(tx.Put(context.Background(), &Media{path: "/a/b.y.jpg", image: img})),
+			it.Equal(fsys.Has("/a/b.x.jpg"), false),
+			it.Equal(fsys.Has("/a/b.y.jpg"), false),
+			it.Equal(fsys.Len(), 2),
+		)
+
+		err := tx.Commit(context.Background())
+		it.Then(t).Should(
+			it.Nil(err),
+			it.True(fsys.Has("/a/b.x.jpg")),
+			it.True(fsys.Has("/a/b.y.jpg")),
+			it.Equal(fsys.Len(), 2),
+		)
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		fsys := newMockFS()
+		tx, _ := NewWriter(fsys).Begin()
+
+		it.Then(t).Should(
+			it.Nil(tx.Put(context.Background(), &Media{path: "/a/b.x.jpg", image: img})),
+		)
+
+		tx.Rollback(context.Background())
+		it.Then(t).Should(
+			it.Equal(fsys.Len(), 0),
+		)
+	})
+
+	t.Run("CommitFailed", func(t *testing.T) {
+		fsys := newMockFS()
+		tx, _ := NewWriter(fsys).Begin()
+
+		it.Then(t).Should(
+			it.Nil(tx.Put(context.Background(), &Media{path: "/a/b.x.jpg", image: img})),
+			it.Nil(tx.Put(context.Background(), &Media{path: "/a/b.y.jpg", image: img})),
+		)
+
+		fsys.failAt = "/a/b.y.jpg"
+		fsys.failClose = errors.New("close")
+
+		err := tx.Commit(context.Background())
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("codec I/O error"),
+			it.Equal(fsys.Len(), 0),
+		)
+	})
+
+	t.Run("CommitRestoresPrevious", func(t *testing.T) {
+		fsys := newMockFS()
+		fsys.Put("/a/b.x.jpg", []byte("x"))
+		fsys.Put("/a/b.y.jpg", []byte("y"))
+		fsys.meta["/a/b.x.jpg"] = Meta{ContentType: "image/x-previous"}
+		fsys.meta["/a/b.y.jpg"] = Meta{ContentType: "image/y-previous"}
+		tx, _ := NewWriter(fsys).Begin()
+
+		it.Then(t).Should(
+			it.Nil(tx.Put(context.Background(), &Media{path: "/a/b.x.jpg", image: img})),
+			it.Nil(tx.Put(context.Background(), &Media{path: "/a/b.y.jpg", image: img})),
+			it.Nil(tx.Put(context.Background(), &Media{path: "/a/b.z.jpg", image: img})),
+		)
+
+		fsys.failPath = "/a/b.y.jpg"
+		fsys.failCount = 1
+		fsys.failClose = errors.New("close")
+
+		err := tx.Commit(context.Background())
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("codec I/O error"),
+			it.Equal(string(fsys.files["/a/b.x.jpg"]), "x"),
+			it.Equal(string(fsys.files["/a/b.y.jpg"]), "y"),
+			it.Equal(fsys.meta["/a/b.x.jpg"].ContentType, "image/x-previous"),
+			it.Equal(fsys.meta["/a/b.y.jpg"].ContentType, "image/y-previous"),
+			it.Equal(fsys.Has("/a/b.z.jpg"), false),
+			it.Equal(fsys.Len(), 2),
+		)
+	})
+
+	t.Run("CopyAsideFailed", func(t *testing.T) {
+		fsys := newMockFS()
+		fsys.Put("/a/b.x.jpg", []byte("x"))
+		tx, _ := NewWriter(fsys).Begin()
+
+		it.Then(t).Should(
+			it.Nil(tx.Put(context.Background(), &Media{path: "/a/b.x.jpg", image: img})),
+		)
+
+		fsys.failAt = previousPrefix
+		fsys.failWrite = errors.New("write")
+
+		err := tx.Commit(context.Background())
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("codec I/O error"),
+			it.Equal(string(fsys.files["/a/b.x.jpg"]), "x"),
+			it.Equal(fsys.Len(), 1),
+		)
+	})
+}
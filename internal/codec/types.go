@@ -30,22 +30,186 @@ type Meta struct {
 type MediaPublished struct {
 	events.S3EventRecord
 	Variants []string
+	Keys     []string // S3 keys of published variants
+	PHash    string   // perceptual hash of media
+	Video    *Video   `json:",omitempty"`
+
+	Renditions []Rendition `json:",omitempty"` // renditions of video media
+	Playlist   string      `json:",omitempty"` // S3 key of HLS playlist
+
+	Audio *Audio `json:",omitempty"`
+	Peaks string `json:",omitempty"` // S3 key of waveform peaks
+
+	Document *Document `json:",omitempty"`
+
+	Srcset map[string]*Srcset `json:",omitempty"` // responsive image sets
+}
+
+type MediaPendingReview struct {
+	events.S3EventRecord
+	Labels []string
+	Keys   []string // S3 keys of quarantined variants
+}
+
+type MediaRejected struct {
+	events.S3EventRecord
+	Reason    string   // malware, blocked or moderation
+	Signature string   `json:",omitempty"` // signature of detected malware
+	Labels    []string `json:",omitempty"` // labels of moderation decision
+}
+
+type MediaRemoved struct {
+	events.S3EventRecord
+	Keys []string // S3 keys of removed variants
 }
 
 const (
 	errCodecIO           = faults.Type("codec I/O error")
 	errCodecNotSupported = faults.Safe1[string]("not supported (%s)")
+	errCodecScaled       = faults.Type("scaled decoder failed")
 )
 
+// ErrMalware is a fault of infected media, infected media is never processed.
+const ErrMalware = faults.Safe1[string]("malware detected (%s)")
+
+// ErrScanLimit is a fault of media that exceeds the stream limit of scanner
+// (e.g. StreamMaxLength of clamd), the media is not scanned and never processed.
+const ErrScanLimit = faults.Safe1[string]("media exceeds limit of scanner (%s)")
+
+// ErrManifestNotFound is a fault of removal of content addressed media that
+// has no manifest, the keys of variants cannot be derived from the path.
+const ErrManifestNotFound = faults.Safe1[string]("manifest not found (%s)")
+
+// ErrMemoryBudget is a fault of media that does not fit into memory of codec,
+// the media requires (MB) more than available (MB).
+const ErrMemoryBudget = faults.Safe2[int, int]("memory budget exceeded (requires %d MB, available %d MB)")
+
+// ErrStorageBudget is a fault of video that does not fit into local storage
+// of codec, the source and renditions require (MB) more than available (MB).
+const ErrStorageBudget = faults.Safe2[int, int]("storage budget exceeded (requires %d MB, available %d MB)")
+
 const (
-	MEDIA_JPEG = "jpeg"
-	MEDIA_LINK = "link"
+	MEDIA_JPEG     = "jpeg"
+	MEDIA_PNG      = "png"
+	MEDIA_GIF      = "gif"
+	MEDIA_WEBP     = "webp"
+	MEDIA_HEIF     = "heif"
+	MEDIA_AVIF     = "avif"
+	MEDIA_SVG      = "svg"
+	MEDIA_VIDEO    = "video"
+	MEDIA_AUDIO    = "audio"
+	MEDIA_DOCUMENT = "document"
+	MEDIA_LINK     = "link"
 )
 
 // Container for digital media
 type Media struct {
-	path  string
-	image image.Image
+	path   string
+	format string // output format of media: jpeg, gif, png or svg
+	hash   string // sha256 of source object
+	etag   string // ETag of source object
+	phash  *PHash // perceptual hash of still image, if duplicates are detected
+	image  image.Image
+
+	// frames of animated media, the image is the poster
+	animation *Animation
+
+	// ICC profile of the image
+	icc []byte
+
+	// sanitized source of vector media
+	vector *Vector
+
+	// source object, replica copies it as-is
+	origin *Origin
+
+	// metadata of video media, the image is the poster
+	video *Video
+
+	// metadata of audio media, the image is the waveform
+	audio *Audio
+
+	// metadata of document media, the image is the rendered page
+	document *Document
+
+	// objects published along with media (e.g. HLS playlist)
+	sidecars []Sidecar
+
+	// local directory of transcoded media, removed once media is published
+	scratch string
+}
+
+// Sidecar object published along with media
+type Sidecar struct {
+	path string
+	meta *Meta
+	data []byte
+}
+
+// Origin is the source object of media
+type Origin struct {
+	fsys        ReaderFS
+	path        string
+	contentType string
+	format      string    // format of still image (e.g. jpeg, png)
+	sanitize    sanitizer // metadata is stripped while origin is copied
+}
+
+// origin of still image in the format
+func newOrigin(fsys ReaderFS, path string, format string) *Origin {
+	return &Origin{fsys: fsys, path: path, contentType: "image/" + format, format: format}
+}
+
+func (media *Media) Path() string       { return media.path }
+func (media *Media) Hash() string       { return media.hash }
+func (media *Media) PHash() *PHash      { return media.phash }
+func (media *Media) Image() image.Image { return media.image }
+
+func (media *Media) Animation() *Animation { return media.animation }
+func (media *Media) Video() *Video         { return media.video }
+func (media *Media) Audio() *Audio         { return media.audio }
+func (media *Media) Document() *Document   { return media.document }
+
+// still image, the image is not a frame, waveform or page of other media
+func (media *Media) still() bool {
+	return media.animation == nil && media.video == nil && media.audio == nil && media.document == nil
+}
+
+// Manifest of published media, it is stored next to variants
+type Manifest struct {
+	Source   string   `json:"source"`
+	Hash     string   `json:"hash"`
+	ETag     string   `json:"etag,omitempty"`
+	PHash    string   `json:"phash,omitempty"`
+	Profile  string   `json:"profile"`
+	Variants []string `json:"variants"`
+	Video    *Video   `json:"video,omitempty"`
+
+	Renditions []Rendition `json:"renditions,omitempty"`
+	Playlist   string      `json:"playlist,omitempty"`
+
+	Audio *Audio `json:"audio,omitempty"`
+	Peaks string `json:"peaks,omitempty"`
+
+	Document *Document `json:"document,omitempty"`
+
+	Srcset map[string][]Candidate `json:"srcset,omitempty"`
+}
+
+// Objects published by manifest: variants and sidecars
+func (manifest *Manifest) Objects() []string {
+	seq := append([]string{}, manifest.Variants...)
+	for _, r := range manifest.Renditions {
+		seq = append(seq, r.Playlist)
+	}
+	if manifest.Playlist != "" {
+		seq = append(seq, manifest.Playlist)
+	}
+	if manifest.Peaks != "" {
+		seq = append(seq, manifest.Peaks)
+	}
+
+	return seq
 }
 
 type Link struct {
@@ -0,0 +1,172 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Video metadata parsed from the container
+type Video struct {
+	Container string        `json:"container"` // mp4, mov or webm
+	Codec     string        `json:"codec"`     // codec of video track (e.g. avc1, V_VP9)
+	Width     int           `json:"width"`
+	Height    int           `json:"height"`
+	Duration  time.Duration `json:"duration"`
+	Rotation  int           `json:"rotation,omitempty"` // clockwise degrees 0, 90, 180 or 270
+
+	size int64 // bytes of the source
+}
+
+func (v *Video) ContentType() string {
+	switch v.Container {
+	case "mov":
+		return "video/quicktime"
+	default:
+		return "video/" + v.Container
+	}
+}
+
+// FrameDecoder extracts the frame of video at the timestamp. The frame is
+// returned as coded, the rotation is applied by codec.
+type FrameDecoder interface {
+	Frame(ctx context.Context, video io.Reader, at time.Duration) (image.Image, error)
+}
+
+// size limit of metadata boxes/elements read into memory
+const videoMetaLimit = 32 << 20
+
+// local copy of video, it is shared by frame decoder and transcoder
+const videoSource = "source"
+
+// fetches video media, metadata is parsed while the object is copied into
+// local storage, the poster frame is extracted by the frame decoder.
+func (r Reader) fetchMediaVideo(ctx context.Context, path string) (*Media, error) {
+	if r.frames == nil {
+		return nil, errCodecNotSupported.With(nil, MEDIA_VIDEO)
+	}
+
+	dir, err := os.MkdirTemp("", "video-")
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	media, err := r.fetchVideo(ctx, path, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return media, nil
+}
+
+func (r Reader) fetchVideo(ctx context.Context, path string, dir string) (*Media, error) {
+	video, hash, err := r.probeVideo(path, filepath.Join(dir, videoSource))
+	if err != nil {
+		return nil, err
+	}
+
+	at := r.posterAt
+	if at > video.Duration {
+		at = 0
+	}
+
+	fd, err := os.Open(filepath.Join(dir, videoSource))
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	defer fd.Close()
+
+	frame, err := r.frames.Frame(ctx, fd, at)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	switch video.Rotation {
+	case 90:
+		frame = orient(frame, 6)
+	case 180:
+		frame = orient(frame, 3)
+	case 270:
+		frame = orient(frame, 8)
+	}
+
+	return &Media{
+		path:    path,
+		hash:    hash,
+		image:   frame,
+		video:   video,
+		origin:  &Origin{fsys: os.DirFS(dir), path: videoSource, contentType: video.ContentType()},
+		scratch: dir,
+	}, nil
+}
+
+// parses video metadata and computes hash of the object while it is copied
+// into the local file, the size of object is limited by the local storage.
+func (r Reader) probeVideo(path string, local string) (*Video, string, error) {
+	fd, err := r.fsys.Open(path)
+	if err != nil {
+		return nil, "", errCodecIO.With(err)
+	}
+	defer fd.Close()
+
+	file, err := os.Create(local)
+	if err != nil {
+		return nil, "", errCodecIO.With(err)
+	}
+	defer file.Close()
+
+	var source io.Reader = fd
+	if r.storage != 0 {
+		source = io.LimitReader(fd, r.storage+1)
+	}
+
+	hash := sha256.New()
+	stream := io.TeeReader(source, io.MultiWriter(hash, file))
+
+	var video *Video
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".webm":
+		video, err = probeWebm(stream)
+	default:
+		video, err = probeMp4(stream)
+	}
+	if err != nil {
+		return nil, "", errCodecIO.With(err)
+	}
+
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		return nil, "", errCodecIO.With(err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, "", errCodecIO.With(err)
+	}
+	if r.storage != 0 && info.Size() > r.storage {
+		return nil, "", ErrStorageBudget.With(nil, int(info.Size()>>20), int(r.storage>>20))
+	}
+	video.size = info.Size()
+
+	slog.Debug("video metadata",
+		slog.String("path", path),
+		slog.Any("video", video),
+	)
+
+	return video, hex.EncodeToString(hash.Sum(nil)), nil
+}
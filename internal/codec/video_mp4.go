@@ -0,0 +1,218 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// probeMp4 parses metadata of MP4 or QuickTime container. Top-level boxes
+// are streamed, only moov box is read into memory.
+func probeMp4(r io.Reader) (*Video, error) {
+	video := &Video{Container: "mp4"}
+	head := make([]byte, 16)
+
+	for {
+		if _, err := io.ReadFull(r, head[:8]); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("mp4: moov box is not found")
+			}
+			return nil, err
+		}
+
+		size := uint64(binary.BigEndian.Uint32(head[:4]))
+		kind := string(head[4:8])
+		hlen := uint64(8)
+
+		if size == 1 {
+			if _, err := io.ReadFull(r, head[8:16]); err != nil {
+				return nil, err
+			}
+			size, hlen = binary.BigEndian.Uint64(head[8:16]), 16
+		}
+
+		switch {
+		case kind == "ftyp" && size >= hlen+4:
+			data := make([]byte, size-hlen)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			if string(data[:4]) == "qt  " {
+				video.Container = "mov"
+			}
+		case kind == "moov" && size > hlen && size-hlen <= videoMetaLimit:
+			data := make([]byte, size-hlen)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			if err := parseMoov(data, video); err != nil {
+				return nil, err
+			}
+			return video, nil
+		case size == 0:
+			// box extends to the end of file
+			return nil, fmt.Errorf("mp4: moov box is not found")
+		case size < hlen:
+			return nil, fmt.Errorf("mp4: invalid box size (%s)", kind)
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size-hlen)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func parseMoov(data []byte, video *Video) error {
+	boxes, err := parseBoxes(data)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range boxes {
+		switch b.kind {
+		case "mvhd":
+			r := newBoxReader(b.data)
+			var timescale, duration uint64
+			if r.fullbox() == 1 {
+				r.take(16)
+				timescale, duration = uint64(r.u32()), r.uint(8)
+			} else {
+				r.take(8)
+				timescale, duration = uint64(r.u32()), uint64(r.u32())
+			}
+			if r.err != nil {
+				return r.err
+			}
+			if timescale != 0 {
+				video.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+			}
+		case "trak":
+			if err := parseTrak(b.data, video); err != nil {
+				return err
+			}
+		}
+	}
+
+	if video.Codec == "" {
+		return fmt.Errorf("mp4: video track is not found")
+	}
+
+	return nil
+}
+
+// parses video track, other tracks are ignored
+func parseTrak(data []byte, video *Video) error {
+	boxes, err := parseBoxes(data)
+	if err != nil {
+		return err
+	}
+
+	var (
+		tkhd  []byte
+		codec string
+	)
+
+	for _, b := range boxes {
+		switch b.kind {
+		case "tkhd":
+			tkhd = b.data
+		case "mdia":
+			codec, err = parseMdia(b.data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if codec == "" || tkhd == nil || video.Codec != "" {
+		return nil
+	}
+
+	r := newBoxReader(tkhd)
+	if r.fullbox() == 1 {
+		r.take(32)
+	} else {
+		r.take(20)
+	}
+	r.take(16) // reserved, layer, alternate group, volume, reserved
+
+	var matrix [9]int32
+	for i := range matrix {
+		matrix[i] = int32(r.u32())
+	}
+	width, height := r.u32(), r.u32()
+	if r.err != nil {
+		return r.err
+	}
+
+	video.Codec = codec
+	video.Width = int(width >> 16)
+	video.Height = int(height >> 16)
+	video.Rotation = matrixRotation(matrix)
+
+	return nil
+}
+
+// codec of video media, empty if media is not video
+func parseMdia(data []byte) (string, error) {
+	boxes, err := parseBoxes(data)
+	if err != nil {
+		return "", err
+	}
+
+	var handler string
+	var minf []byte
+	for _, b := range boxes {
+		switch b.kind {
+		case "hdlr":
+			r := newBoxReader(b.data)
+			r.fullbox()
+			r.u32()
+			handler = r.fourcc()
+		case "minf":
+			minf = b.data
+		}
+	}
+
+	if handler != "vide" || minf == nil {
+		return "", nil
+	}
+
+	stsd, err := findBox(minf, "stbl", "stsd")
+	if err != nil || stsd == nil {
+		return "", err
+	}
+
+	// full box, entry count, sample entry (its type is the codec)
+	if len(stsd) < 16 {
+		return "", fmt.Errorf("mp4: invalid stsd")
+	}
+
+	return string(stsd[12:16]), nil
+}
+
+// rotation of display matrix {a b u, c d v, x y w} in clockwise degrees
+func matrixRotation(m [9]int32) int {
+	const one = 1 << 16
+	a, b, c, d := m[0], m[1], m[3], m[4]
+
+	switch {
+	case a == 0 && b == one && c == -one && d == 0:
+		return 90
+	case a == -one && b == 0 && c == 0 && d == -one:
+		return 180
+	case a == 0 && b == -one && c == one && d == 0:
+		return 270
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,243 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestProbeVideo(t *testing.T) {
+	t.Run("MP4", func(t *testing.T) {
+		video, err := probeMp4(bytes.NewReader(newMockMp4("isom", 90)))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equiv(video, &Video{Container: "mp4", Codec: "avc1", Width: 640, Height: 360, Duration: 2500 * time.Millisecond, Rotation: 90}),
+		)
+	})
+
+	t.Run("MOV", func(t *testing.T) {
+		video, err := probeMp4(bytes.NewReader(newMockMp4("qt  ", 0)))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(video.Container, "mov"),
+			it.Equal(video.ContentType(), "video/quicktime"),
+		)
+	})
+
+	t.Run("WebM", func(t *testing.T) {
+		video, err := probeWebm(bytes.NewReader(newMockWebm()))
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equiv(video, &Video{Container: "webm", Codec: "V_VP9", Width: 320, Height: 240, Duration: 1500 * time.Millisecond, Rotation: 270}),
+		)
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		mp4, webm := newMockMp4("isom", 0), newMockWebm()
+
+		for _, input := range [][]byte{nil, mp4[:20], mp4[:len(mp4)-4]} {
+			_, err := probeMp4(bytes.NewReader(input))
+			it.Then(t).ShouldNot(it.Nil(err))
+		}
+
+		for _, input := range [][]byte{nil, webm[:20], mp4} {
+			_, err := probeWebm(bytes.NewReader(input))
+			it.Then(t).ShouldNot(it.Nil(err))
+		}
+	})
+}
+
+func TestCodecVideo(t *testing.T) {
+	profile := medium.On("a", "").
+		Process(
+			medium.ScaleTo("small", 4, 4),
+			medium.Replica("origin"),
+		).
+		PosterAt(time.Second)
+
+	t.Run("Poster", func(t *testing.T) {
+		source := newMockMp4("isom", 90)
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.mp4", source)
+		frames := &mockFrameDecoder{}
+		emitter := &mockEmitter[MediaPublished]{}
+
+		codec := NewCodec(profile, rfs, wfs, Emitters{Published: emitter}, WithFrameDecoder(frames))
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.mp4"))),
+			it.Seq(frames.at).Equal(time.Second),
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+			it.Equal(string(wfs.files["/a/b.origin.mp4"]), string(source)),
+			it.Equal(len(emitter.events), 1),
+			it.Equal(emitter.events[0].Video.Duration, 2500*time.Millisecond),
+		)
+
+		// poster is rotated
+		media, err := codec.reader.Get(context.Background(), newMockEvent("a/b.mp4"))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(media.Image().Bounds(), image.Rect(0, 0, 2, 4)),
+			it.Equal(media.Hash(), newMockHash(rfs, "/a/b.mp4")),
+		)
+	})
+
+	t.Run("PosterOutOfRange", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.webm", newMockWebm())
+		frames := &mockFrameDecoder{}
+
+		codec := NewCodec(profile.PosterAt(time.Hour), rfs, wfs, Emitters{}, WithFrameDecoder(frames))
+		it.Then(t).Should(
+			it.Nil(codec.Process(context.Background(), newMockEvent("a/b.webm"))),
+			it.Seq(frames.at).Equal(time.Duration(0)),
+			it.True(wfs.Has("/a/b.origin.webm")),
+		)
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.mp4", newMockMp4("isom", 0))
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.mp4"))
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("video"),
+		)
+	})
+}
+
+// frame decoder of 4x2 frames
+type mockFrameDecoder struct {
+	at      []time.Duration
+	sources []string // local files
+}
+
+func (d *mockFrameDecoder) Frame(_ context.Context, r io.Reader, at time.Duration) (image.Image, error) {
+	if _, err := io.ReadAll(r); err != nil {
+		return nil, err
+	}
+
+	if fd, ok := r.(*os.File); ok {
+		d.sources = append(d.sources, fd.Name())
+	}
+
+	d.at = append(d.at, at)
+	return image.NewRGBA(image.Rect(0, 0, 4, 2)), nil
+}
+
+// MP4 of 640x360 avc1 video track and audio track, 2.5 seconds,
+// moov box follows mdat.
+func newMockMp4(brand string, rotation int) []byte {
+	const one = 1 << 16
+
+	matrix := [9]int32{one, 0, 0, 0, one, 0, 0, 0, 1 << 30}
+	if rotation == 90 {
+		matrix[0], matrix[1], matrix[3], matrix[4] = 0, one, -one, 0
+	}
+
+	tkhd := make([]byte, 0, 84)
+	tkhd = append(tkhd, make([]byte, 4+20+16)...)
+	for _, x := range matrix {
+		tkhd = binary.BigEndian.AppendUint32(tkhd, uint32(x))
+	}
+	tkhd = binary.BigEndian.AppendUint32(tkhd, 640<<16)
+	tkhd = binary.BigEndian.AppendUint32(tkhd, 360<<16)
+
+	trak := func(handler, codec string) []byte {
+		return mockBox("trak", bytes.Join([][]byte{
+			mockBox("tkhd", tkhd),
+			mockBox("mdia", bytes.Join([][]byte{
+				mockBox("mdhd", make([]byte, 24)),
+				mockBox("hdlr", append(append(make([]byte, 8), handler...), make([]byte, 13)...)),
+				mockBox("minf", mockBox("stbl", bytes.Join([][]byte{
+					mockBox("stsd", append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, mockBox(codec, make([]byte, 8))...)),
+					mockBox("stts", make([]byte, 8)),
+				}, nil))),
+			}, nil)),
+		}, nil))
+	}
+
+	mvhd := make([]byte, 12)
+	mvhd = binary.BigEndian.AppendUint32(mvhd, 1000)
+	mvhd = binary.BigEndian.AppendUint32(mvhd, 2500)
+	mvhd = append(mvhd, make([]byte, 80)...)
+
+	return bytes.Join([][]byte{
+		mockBox("ftyp", []byte(brand+"\x00\x00\x02\x00isom")),
+		mockBox("mdat", bytes.Repeat([]byte{0xaa}, 1024)),
+		mockBox("moov", bytes.Join([][]byte{
+			mockBox("mvhd", mvhd),
+			trak("soun", "mp4a"),
+			trak("vide", "avc1"),
+		}, nil)),
+	}, nil)
+}
+
+// WebM of 320x240 VP9 video track, 1.5 seconds, rotated by 90 anticlockwise
+func newMockWebm() []byte {
+	duration := binary.BigEndian.AppendUint64(nil, math.Float64bits(1500))
+	roll := binary.BigEndian.AppendUint32(nil, math.Float32bits(90))
+
+	info := bytes.Join([][]byte{
+		mockEbml(ebmlTimecodeScale, []byte{0x0f, 0x42, 0x40}),
+		mockEbml(ebmlDuration, duration),
+	}, nil)
+
+	tracks := bytes.Join([][]byte{
+		mockEbml(ebmlTrackEntry, bytes.Join([][]byte{
+			mockEbml(ebmlTrackType, []byte{2}),
+			mockEbml(ebmlCodecID, []byte("A_OPUS")),
+		}, nil)),
+		mockEbml(ebmlTrackEntry, bytes.Join([][]byte{
+			mockEbml(ebmlTrackType, []byte{1}),
+			mockEbml(ebmlCodecID, []byte("V_VP9")),
+			mockEbml(ebmlVideo, bytes.Join([][]byte{
+				mockEbml(ebmlPixelWidth, []byte{0x01, 0x40}),
+				mockEbml(ebmlPixelHeight, []byte{0xf0}),
+				mockEbml(ebmlProjection, mockEbml(ebmlPoseRoll, roll)),
+			}, nil)),
+		}, nil)),
+	}, nil)
+
+	return bytes.Join([][]byte{
+		mockEbml(0x1A45DFA3, mockEbml(0x4282, []byte("webm"))),
+		// segment of unknown size
+		{0x18, 0x53, 0x80, 0x67, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		mockEbml(ebmlInfo, info),
+		mockEbml(ebmlTracks, tracks),
+		mockEbml(0x1F43B675, bytes.Repeat([]byte{0xaa}, 1024)),
+	}, nil)
+}
+
+// EBML element with 8 bytes size
+func mockEbml(id uint64, data []byte) []byte {
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if x := byte(id >> shift); x != 0 || len(b) > 0 {
+			b = append(b, x)
+		}
+	}
+
+	b = append(b, 0x01)
+	b = append(b, binary.BigEndian.AppendUint64(nil, uint64(len(data)))[1:]...)
+	return append(b, data...)
+}
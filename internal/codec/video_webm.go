@@ -0,0 +1,274 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// EBML elements of Matroska/WebM
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+	ebmlTracks        = 0x1654AE6B
+	ebmlTrackEntry    = 0xAE
+	ebmlTrackType     = 0x83
+	ebmlCodecID       = 0x86
+	ebmlVideo         = 0xE0
+	ebmlPixelWidth    = 0xB0
+	ebmlPixelHeight   = 0xBA
+	ebmlProjection    = 0x7670
+	ebmlPoseRoll      = 0x7675
+	ebmlUnknownSize   = math.MaxUint64
+)
+
+// probeWebm parses metadata of WebM container. Top-level elements are
+// streamed, only Info and Tracks are read into memory.
+func probeWebm(r io.Reader) (*Video, error) {
+	video := &Video{Container: "webm"}
+
+	var (
+		info, tracks []byte
+		segment      bool
+	)
+
+	for info == nil || tracks == nil {
+		id, err := ebmlID(r)
+		if err != nil {
+			return nil, fmt.Errorf("webm: info or tracks are not found")
+		}
+
+		size, err := ebmlSize(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case id == ebmlSegment:
+			// segment contains all elements, the parser descends into it
+			segment = true
+		case (id == ebmlInfo || id == ebmlTracks) && size <= videoMetaLimit:
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			if id == ebmlInfo {
+				info = data
+			} else {
+				tracks = data
+			}
+		case size == ebmlUnknownSize:
+			return nil, fmt.Errorf("webm: element %x of unknown size", id)
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !segment {
+		return nil, fmt.Errorf("webm: segment is not found")
+	}
+
+	if err := parseWebmInfo(info, video); err != nil {
+		return nil, err
+	}
+
+	if err := parseWebmTracks(tracks, video); err != nil {
+		return nil, err
+	}
+
+	return video, nil
+}
+
+func parseWebmInfo(data []byte, video *Video) error {
+	scale := uint64(1000000)
+	var duration float64
+
+	err := ebmlEach(data, func(id uint64, value []byte) error {
+		switch id {
+		case ebmlTimecodeScale:
+			scale = ebmlUint(value)
+		case ebmlDuration:
+			duration = ebmlFloat(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	video.Duration = time.Duration(duration * float64(scale))
+	return nil
+}
+
+// parses first video track
+func parseWebmTracks(data []byte, video *Video) error {
+	err := ebmlEach(data, func(id uint64, entry []byte) error {
+		if id != ebmlTrackEntry || video.Codec != "" {
+			return nil
+		}
+
+		var (
+			kind  uint64
+			codec string
+			track Video
+		)
+
+		err := ebmlEach(entry, func(id uint64, value []byte) error {
+			switch id {
+			case ebmlTrackType:
+				kind = ebmlUint(value)
+			case ebmlCodecID:
+				codec = string(value)
+			case ebmlVideo:
+				return ebmlEach(value, func(id uint64, value []byte) error {
+					switch id {
+					case ebmlPixelWidth:
+						track.Width = int(ebmlUint(value))
+					case ebmlPixelHeight:
+						track.Height = int(ebmlUint(value))
+					case ebmlProjection:
+						return ebmlEach(value, func(id uint64, value []byte) error {
+							if id == ebmlPoseRoll {
+								// roll is anticlockwise
+								track.Rotation = (360 - int(math.Round(ebmlFloat(value)))%360) % 360
+							}
+							return nil
+						})
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// track type 1 is video
+		if kind == 1 {
+			video.Codec = codec
+			video.Width = track.Width
+			video.Height = track.Height
+			video.Rotation = track.Rotation
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if video.Codec == "" {
+		return fmt.Errorf("webm: video track is not found")
+	}
+
+	return nil
+}
+
+// iterates over elements of EBML master element
+func ebmlEach(data []byte, f func(uint64, []byte) error) error {
+	r := bytes.NewReader(data)
+
+	for r.Len() > 0 {
+		id, err := ebmlID(r)
+		if err != nil {
+			return err
+		}
+
+		size, err := ebmlSize(r)
+		if err != nil {
+			return err
+		}
+
+		if size > uint64(r.Len()) {
+			return fmt.Errorf("webm: element %x is out of range", id)
+		}
+
+		at := len(data) - r.Len()
+		value := data[at : at+int(size)]
+		r.Seek(int64(size), io.SeekCurrent)
+
+		if err := f(id, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// element ID keeps the length marker
+func ebmlID(r io.Reader) (uint64, error) {
+	return ebmlVint(r, false)
+}
+
+// element size, unknown size is reported as ebmlUnknownSize
+func ebmlSize(r io.Reader) (uint64, error) {
+	return ebmlVint(r, true)
+}
+
+func ebmlVint(r io.Reader, strip bool) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return 0, err
+	}
+
+	n := 1
+	for mask := byte(0x80); n <= 8 && b[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > 8 {
+		return 0, fmt.Errorf("webm: invalid variable integer")
+	}
+
+	if _, err := io.ReadFull(r, b[1:n]); err != nil {
+		return 0, err
+	}
+
+	value := uint64(b[0])
+	if strip {
+		value &= uint64(0xff >> n)
+	}
+	for i := 1; i < n; i++ {
+		value = value<<8 | uint64(b[i])
+	}
+
+	if strip && value == 1<<(7*n)-1 {
+		return ebmlUnknownSize, nil
+	}
+
+	return value, nil
+}
+
+func ebmlUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,213 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+// size limit of WebP media
+const webpLimit = 64 << 20
+
+// WebP container (RIFF), frames of animation are kept compressed until
+// the animation is coalesced.
+type webpContainer struct {
+	width, height int
+	loop          int
+	frames        []webpFrame
+}
+
+// frame of animated WebP (ANMF chunk)
+type webpFrame struct {
+	rect    image.Rectangle
+	delay   int  // milliseconds
+	blend   bool // alpha-blending with previous canvas
+	dispose bool // frame area is disposed to background
+	data    []byte
+}
+
+// Delay of frames, in 100ths of a second
+func (c *webpContainer) Delay() []int {
+	delay := make([]int, len(c.frames))
+	for i, frame := range c.frames {
+		delay[i] = (frame.delay + 5) / 10
+	}
+	return delay
+}
+
+func (c *webpContainer) Duration() time.Duration {
+	var d time.Duration
+	for _, frame := range c.frames {
+		d += time.Duration(frame.delay) * time.Millisecond
+	}
+	return d
+}
+
+// parses WebP container, the still image is not animated
+func parseWebp(data []byte) (*webpContainer, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("webp: invalid header")
+	}
+
+	c := &webpContainer{}
+	animated := false
+
+	for b := data[12:]; len(b) >= 8; {
+		fourcc, size := string(b[0:4]), binary.LittleEndian.Uint32(b[4:8])
+		if uint64(size) > uint64(len(b)-8) {
+			return nil, fmt.Errorf("webp: invalid chunk %s", fourcc)
+		}
+		payload := b[8 : 8+size]
+
+		switch fourcc {
+		case "VP8X":
+			if len(payload) < 10 {
+				return nil, fmt.Errorf("webp: invalid chunk %s", fourcc)
+			}
+			animated = payload[0]&0x02 != 0
+			c.width, c.height = int(uint24(payload[4:]))+1, int(uint24(payload[7:]))+1
+		case "ANIM":
+			if len(payload) < 6 {
+				return nil, fmt.Errorf("webp: invalid chunk %s", fourcc)
+			}
+			c.loop = int(binary.LittleEndian.Uint16(payload[4:6]))
+		case "ANMF":
+			frame, err := parseWebpFrame(payload)
+			if err != nil {
+				return nil, err
+			}
+			c.frames = append(c.frames, frame)
+		}
+
+		// chunks are padded to even size
+		next := 8 + int(size) + int(size&1)
+		if next > len(b) {
+			break
+		}
+		b = b[next:]
+	}
+
+	if !animated {
+		return c, nil
+	}
+
+	if len(c.frames) == 0 {
+		return nil, fmt.Errorf("webp: frames not found")
+	}
+
+	canvas := image.Rect(0, 0, c.width, c.height)
+	for _, frame := range c.frames {
+		if !frame.rect.In(canvas) {
+			return nil, fmt.Errorf("webp: frame is out of canvas")
+		}
+	}
+
+	return c, nil
+}
+
+func parseWebpFrame(payload []byte) (webpFrame, error) {
+	if len(payload) < 16 {
+		return webpFrame{}, fmt.Errorf("webp: invalid chunk ANMF")
+	}
+
+	x, y := int(uint24(payload[0:]))*2, int(uint24(payload[3:]))*2
+	w, h := int(uint24(payload[6:]))+1, int(uint24(payload[9:]))+1
+
+	frame := webpFrame{
+		rect:    image.Rect(x, y, x+w, y+h),
+		delay:   int(uint24(payload[12:])),
+		blend:   payload[15]&0x02 == 0,
+		dispose: payload[15]&0x01 != 0,
+	}
+
+	// frame data is either VP8L or (ALPH) VP8, it is wrapped into standalone
+	// WebP, the alpha requires extended format.
+	chunks := payload[16:]
+	if len(chunks) >= 4 && string(chunks[0:4]) == "ALPH" {
+		vp8x := make([]byte, 10)
+		vp8x[0] = 0x10
+		putUint24(vp8x[4:], uint32(w-1))
+		putUint24(vp8x[7:], uint32(h-1))
+		chunks = append(webpChunk("VP8X", vp8x), chunks...)
+	}
+
+	riff := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+len(chunks)))...)
+	riff = append(append(riff, "WEBP"...), chunks...)
+	frame.data = riff
+
+	return frame, nil
+}
+
+// coalesces first n frames of animation applying blending and disposal
+// methods, each frame is rendered onto the full canvas.
+func (c *webpContainer) coalesce(n int) (*Animation, error) {
+	canvas := image.NewRGBA(image.Rect(0, 0, c.width, c.height))
+	frames := make([]image.Image, 0, n)
+
+	for _, frame := range c.frames[:n] {
+		img, err := webp.Decode(bytes.NewReader(frame.data))
+		if err != nil {
+			return nil, err
+		}
+
+		op := draw.Src
+		if frame.blend {
+			op = draw.Over
+		}
+		draw.Draw(canvas, frame.rect, img, img.Bounds().Min, op)
+		frames = append(frames, clone(canvas))
+
+		if frame.dispose {
+			draw.Draw(canvas, frame.rect, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+
+	return &Animation{
+		Frames: frames,
+		Delay:  c.Delay()[:n],
+		Loop:   webpLoop(c.loop),
+	}, nil
+}
+
+// WebP plays animation n times (0 is forever), image/gif loops it n+1 times
+// (0 is forever, -1 is once)
+func webpLoop(loop int) int {
+	switch loop {
+	case 0:
+		return 0
+	case 1:
+		return -1
+	default:
+		return loop - 1
+	}
+}
+
+func webpChunk(fourcc string, payload []byte) []byte {
+	chunk := append([]byte(fourcc), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	chunk = append(chunk, payload...)
+	if len(payload)&1 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
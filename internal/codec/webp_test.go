@@ -0,0 +1,229 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+	"time"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+)
+
+func TestParseWebp(t *testing.T) {
+	t.Run("Animated", func(t *testing.T) {
+		c, err := parseWebp(newMockWebp(8, 8, 0))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(len(c.frames), 3),
+			it.Equal(c.Duration(), 300*time.Millisecond),
+			it.Seq(c.Delay()).Equal(10, 10, 10),
+			it.Equal(webpLoop(c.loop), 0),
+		)
+	})
+
+	t.Run("Still", func(t *testing.T) {
+		c, err := parseWebp(newMockWebpStill(8, 8))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(len(c.frames), 0),
+		)
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		data := newMockWebp(8, 8, 0)
+		binary.LittleEndian.PutUint32(data[16:], 1<<31)
+
+		_, err := parseWebp(data)
+		it.Then(t).ShouldNot(it.Nil(err))
+	})
+
+	t.Run("OutOfCanvas", func(t *testing.T) {
+		_, err := parseWebp(newMockWebp(4, 4, 0))
+		it.Then(t).ShouldNot(it.Nil(err))
+	})
+
+	t.Run("Loop", func(t *testing.T) {
+		it.Then(t).Should(
+			it.Equal(webpLoop(0), 0),
+			it.Equal(webpLoop(1), -1),
+			it.Equal(webpLoop(3), 2),
+		)
+	})
+}
+
+func TestWebpCoalesce(t *testing.T) {
+	c, err := parseWebp(newMockWebp(8, 8, 0))
+	it.Then(t).Should(it.Nil(err))
+
+	anim, err := c.coalesce(3)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(anim.Frames), 3),
+		it.Equal(anim.Frames[2].Bounds(), image.Rect(0, 0, 8, 8)),
+		// background frame is kept by following frames
+		it.Equiv(color.RGBAModel.Convert(anim.Frames[1].At(7, 7)), color.Color(color.RGBA{0, 0, 255, 255})),
+		it.Equiv(color.RGBAModel.Convert(anim.Frames[1].At(2, 2)), color.Color(color.RGBA{255, 0, 0, 255})),
+		// frame 1 is disposed to transparent background
+		it.Equiv(color.RGBAModel.Convert(anim.Frames[2].At(2, 2)), color.Color(color.RGBA{0, 0, 0, 0})),
+		it.Equiv(color.RGBAModel.Convert(anim.Frames[2].At(4, 4)), color.Color(color.RGBA{255, 0, 0, 255})),
+	)
+
+	poster, err := c.coalesce(1)
+	it.Then(t).Should(
+		it.Nil(err),
+		it.Equal(len(poster.Frames), 1),
+		it.Equal(len(poster.Delay), 1),
+	)
+}
+
+func TestCodecAnimationWebp(t *testing.T) {
+	process := func(t *testing.T, profile medium.Profile, data []byte) *mockFS {
+		t.Helper()
+
+		rfs, wfs := newMockFS(), newMockFS()
+		rfs.Put("/a/b.webp", data)
+
+		err := NewCodec(profile, rfs, wfs, Emitters{}).Process(context.Background(), newMockEvent("a/b.webp"))
+		it.Then(t).Should(it.Nil(err))
+
+		return wfs
+	}
+
+	profile := medium.On("a", "").Process(
+		medium.ScaleTo("small", 4, 4),
+		medium.Replica("origin"),
+	)
+
+	t.Run("Animated", func(t *testing.T) {
+		wfs := process(t, profile.KeepAnimation(10, time.Second), newMockWebp(8, 8, 0))
+
+		g, err := gif.DecodeAll(bytes.NewReader(wfs.files["/a/b.small-4x4.gif"]))
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(len(g.Image), 3),
+			it.Equal(g.Image[0].Bounds(), image.Rect(0, 0, 4, 4)),
+			it.Seq(g.Delay).Equal(10, 10, 10),
+			it.True(wfs.Has("/a/b.origin.gif")),
+		)
+	})
+
+	t.Run("Poster", func(t *testing.T) {
+		wfs := process(t, profile, newMockWebp(8, 8, 0))
+
+		it.Then(t).Should(
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+			it.True(wfs.Has("/a/b.origin.jpg")),
+		)
+	})
+
+	t.Run("ExceedsFrames", func(t *testing.T) {
+		wfs := process(t, profile.KeepAnimation(2, 0), newMockWebp(8, 8, 0))
+
+		it.Then(t).Should(
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+		)
+	})
+
+	t.Run("Still", func(t *testing.T) {
+		wfs := process(t, profile.KeepAnimation(10, time.Second), newMockWebpStill(8, 8))
+
+		it.Then(t).Should(
+			it.True(wfs.Has("/a/b.small-4x4.jpg")),
+			it.True(wfs.Has("/a/b.origin.jpg")),
+		)
+	})
+}
+
+// animation with blue background and red square moving along diagonal,
+// squares are disposed to background, the last one is blended.
+func newMockWebp(w, h int, loop int) []byte {
+	blue, red := color.NRGBA{0, 0, 255, 255}, color.NRGBA{255, 0, 0, 255}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x10 | 0x02
+	putUint24(vp8x[4:], uint32(w-1))
+	putUint24(vp8x[7:], uint32(h-1))
+
+	anim := make([]byte, 6)
+	binary.LittleEndian.PutUint16(anim[4:], uint16(loop))
+
+	chunks := append(webpChunk("VP8X", vp8x), webpChunk("ANIM", anim)...)
+	chunks = append(chunks, newMockWebpFrame(0, 0, 8, 8, 0x02, blue)...)
+	chunks = append(chunks, newMockWebpFrame(2, 2, 2, 2, 0x02|0x01, red)...)
+	chunks = append(chunks, newMockWebpFrame(4, 4, 2, 2, 0x00, red)...)
+
+	return newMockRiff(chunks)
+}
+
+func newMockWebpStill(w, h int) []byte {
+	return newMockRiff(webpChunk("VP8L", newMockVP8L(w, h, color.NRGBA{0, 0, 255, 255})))
+}
+
+func newMockWebpFrame(x, y, w, h int, flags byte, c color.NRGBA) []byte {
+	anmf := make([]byte, 16)
+	putUint24(anmf[0:], uint32(x/2))
+	putUint24(anmf[3:], uint32(y/2))
+	putUint24(anmf[6:], uint32(w-1))
+	putUint24(anmf[9:], uint32(h-1))
+	putUint24(anmf[12:], 100)
+	anmf[15] = flags
+
+	return webpChunk("ANMF", append(anmf, webpChunk("VP8L", newMockVP8L(w, h, c))...))
+}
+
+func newMockRiff(chunks []byte) []byte {
+	riff := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+len(chunks)))...)
+	return append(append(riff, "WEBP"...), chunks...)
+}
+
+// lossless bitstream of solid color, each prefix code has a single symbol,
+// pixels are encoded with zero bits.
+func newMockVP8L(w, h int, c color.NRGBA) []byte {
+	var bits vp8lBits
+	bits.write(0x2f, 8)
+	bits.write(uint32(w-1), 14)
+	bits.write(uint32(h-1), 14)
+	bits.write(1, 1) // alpha
+	bits.write(0, 3) // version
+	bits.write(0, 1) // no transform
+	bits.write(0, 1) // no color cache
+	bits.write(0, 1) // no meta prefix codes
+
+	// green, red, blue, alpha and distance codes
+	for _, symbol := range []uint8{c.G, c.R, c.B, c.A, 0} {
+		bits.write(1, 1) // simple code
+		bits.write(0, 1) // single symbol
+		bits.write(1, 1) // 8 bits symbol
+		bits.write(uint32(symbol), 8)
+	}
+
+	return bits.buf
+}
+
+type vp8lBits struct {
+	buf []byte
+	n   int
+}
+
+func (b *vp8lBits) write(v uint32, n int) {
+	for i := 0; i < n; i++ {
+		if b.n%8 == 0 {
+			b.buf = append(b.buf, 0)
+		}
+		b.buf[b.n/8] |= byte((v>>i)&1) << (b.n % 8)
+		b.n++
+	}
+}
@@ -9,9 +9,17 @@
 package codec
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
 	"log/slog"
+	"mime"
+	"path/filepath"
+	"strings"
 )
 
 type Writer struct {
@@ -24,23 +32,259 @@ func NewWriter(fsys WriterFS) *Writer {
 	}
 }
 
-func (wrt Writer) Put(ctx context.Context, media *Media) (err error) {
+func (wrt Writer) Put(ctx context.Context, media *Media) error {
+	if media.origin != nil {
+		if _, err := wrt.replicate(media.path, media.origin); err != nil {
+			return err
+		}
+	} else {
+		path, meta, data, err := wrt.encode(media)
+		if err != nil {
+			return err
+		}
+
+		if err := wrt.write(path, meta, data); err != nil {
+			return err
+		}
+	}
+
+	for _, sidecar := range media.sidecars {
+		if err := wrt.PutSidecar(ctx, sidecar); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PutSidecar writes object published along with media
+func (wrt Writer) PutSidecar(ctx context.Context, sidecar Sidecar) error {
+	return wrt.write(sidecar.path, sidecar.meta, sidecar.data)
+}
+
+// Media is encoded in memory, failed encoding never reaches the storage.
+func (wrt Writer) encode(media *Media) (string, *Meta, []byte, error) {
 	slog.Debug("write media object",
 		slog.String("path", media.path),
 		slog.Group("source", "x", media.image.Bounds().Dx(), "y", media.image.Bounds().Dy()),
 	)
 
-	path := media.path + ".jpg"
-	fd, err := wrt.fsys.Create(path, &Meta{ContentType: "image/jpg"})
+	switch media.format {
+	case "svg":
+		return media.path, &Meta{ContentType: "image/svg+xml"}, media.vector.svg, nil
+	case "gif":
+		data, err := encodeGif(media.animation)
+		if err != nil {
+			return "", nil, nil, errCodecIO.With(err)
+		}
+
+		return media.path, &Meta{ContentType: "image/gif"}, data, nil
+	case "png":
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, media.image); err != nil {
+			return "", nil, nil, errCodecIO.With(err)
+		}
+
+		return media.path, &Meta{ContentType: "image/png"}, buf.Bytes(), nil
+	}
+
+	// TODO: Make customizable but 93% is optimal
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, media.image, &jpeg.Options{Quality: 93}); err != nil {
+		return "", nil, nil, errCodecIO.With(err)
+	}
+
+	data := buf.Bytes()
+	if media.icc != nil {
+		data = withICC(data, media.icc)
+	}
+
+	return media.path, &Meta{ContentType: "image/jpeg"}, data, nil
+}
+
+// ICC profile is embedded into JPEG as APP2 segments next to SOI marker
+func withICC(jpeg []byte, icc []byte) []byte {
+	const chunk = 65535 - 2 - 14 // segment length, ICC_PROFILE header
+	n := (len(icc) + chunk - 1) / chunk
+	if len(jpeg) < 2 || n > 255 {
+		return jpeg
+	}
+
+	out := make([]byte, 0, len(jpeg)+len(icc)+n*18)
+	out = append(out, jpeg[:2]...)
+	for i := 0; i < n; i++ {
+		seq := icc[i*chunk : min((i+1)*chunk, len(icc))]
+		size := 2 + 14 + len(seq)
+		out = append(out, 0xff, 0xe2, byte(size>>8), byte(size))
+		out = append(out, "ICC_PROFILE\x00"...)
+		out = append(out, byte(i+1), byte(n))
+		out = append(out, seq...)
+	}
+
+	return append(out, jpeg[2:]...)
+}
+
+// Manifest of media published from the source object
+func (wrt Writer) Manifest(source string) (*Manifest, error) {
+	fd, err := wrt.fsys.Open(manifestPath(source))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(fd).Decode(&manifest); err != nil {
+		return nil, errCodecIO.With(err)
+	}
+
+	return &manifest, nil
+}
+
+// Checks that all variants listed by manifest are published
+func (wrt Writer) HasManifest(manifest *Manifest) bool {
+	for _, path := range manifest.Objects() {
+		if _, err := fs.Stat(wrt.fsys, path); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (wrt Writer) PutManifest(ctx context.Context, manifest *Manifest) error {
+	data, err := json.Marshal(manifest)
 	if err != nil {
 		return errCodecIO.With(err)
 	}
-	defer func() { err = fd.Close() }()
 
-	// TODO: Make customizable but 93% is optimal
-	if err := jpeg.Encode(fd, media.image, &jpeg.Options{Quality: 93}); err != nil {
-		slog.Error("failed encode jpeg", "error", err)
+	return wrt.write(manifestPath(manifest.Source), &Meta{ContentType: "application/json"}, data)
+}
+
+func manifestPath(source string) string {
+	return strings.TrimSuffix(source, filepath.Ext(source)) + ".manifest.json"
+}
+
+// Unpublish removes variants listed by manifest and the manifest itself.
+func (wrt Writer) Unpublish(ctx context.Context, manifest *Manifest) error {
+	for _, variant := range manifest.Objects() {
+		if err := wrt.Remove(ctx, variant); err != nil {
+			return errCodecIO.With(err)
+		}
+	}
+
+	if err := wrt.Remove(ctx, manifestPath(manifest.Source)); err != nil {
+		return errCodecIO.With(err)
+	}
+
+	return nil
+}
+
+// content type of published object, it follows the content type of encoded
+// or replicated media
+func contentTypeOf(path string) string {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	switch ext {
+	case "m3u8":
+		return contentTypeHLS
+	case "mp4", "mov", "webm":
+		return (&Video{Container: ext}).ContentType()
+	case "wav", "mp3", "ogg":
+		return (&Audio{Format: ext}).ContentType()
+	case "pdf":
+		return (&Document{Format: ext}).ContentType()
+	default:
+		return mime.TypeByExtension("." + ext)
+	}
+}
+
+// replicate copies the origin of media as-is
+func (wrt Writer) replicate(path string, origin *Origin) (*Meta, error) {
+	fd, err := origin.fsys.Open(origin.path)
+	if err != nil {
+		return nil, errCodecIO.With(err)
+	}
+	defer fd.Close()
+
+	var stream io.Reader = fd
+	if origin.sanitize != nil {
+		sanitized := sanitize(origin.sanitize, fd)
+		defer sanitized.Close()
+		stream = sanitized
+	}
+
+	meta := &Meta{ContentType: origin.contentType}
+	if err := wrt.stream(path, meta, stream); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+func (wrt Writer) write(path string, meta *Meta, data []byte) error {
+	return wrt.stream(path, meta, bytes.NewReader(data))
+}
+
+func (wrt Writer) stream(path string, meta *Meta, r io.Reader) error {
+	fd, err := wrt.fsys.Create(path, meta)
+	if err != nil {
+		return errCodecIO.With(err)
+	}
+
+	// cancelled file is never committed to the storage
+	if _, err := io.Copy(fd, r); err != nil {
+		if err := fd.Cancel(); err != nil {
+			slog.Warn("failed to cancel partial media object",
+				slog.String("path", path),
+				"error", err,
+			)
+		}
+		return errCodecIO.With(err)
+	}
+
+	if err := fd.Close(); err != nil {
+		wrt.remove(path)
+		return errCodecIO.With(err)
 	}
 
 	return nil
 }
+
+// metadata of the media object, it is nil if file system does not expose it
+func (wrt Writer) metaOf(fd fs.File) *Meta {
+	stat, err := fd.Stat()
+	if err != nil {
+		return nil
+	}
+
+	if fsys, ok := wrt.fsys.(interface{ StatSys(fs.FileInfo) *Meta }); ok {
+		return fsys.StatSys(stat)
+	}
+
+	meta, _ := stat.Sys().(*Meta)
+	return meta
+}
+
+// Remove media object
+func (wrt Writer) Remove(ctx context.Context, path string) error {
+	fsys, ok := wrt.fsys.(interface{ Remove(string) error })
+	if !ok {
+		return errCodecNotSupported.With(nil, "remove")
+	}
+
+	return fsys.Remove(path)
+}
+
+// removes partially written object, if file system supports it
+func (wrt Writer) remove(path string) {
+	fsys, ok := wrt.fsys.(interface{ Remove(string) error })
+	if !ok {
+		return
+	}
+
+	if err := fsys.Remove(path); err != nil {
+		slog.Warn("failed to remove partial media object",
+			slog.String("path", path),
+			"error", err,
+		)
+	}
+}
@@ -0,0 +1,254 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package codec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/stream"
+)
+
+func TestWriter(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+
+	t.Run("Put", func(t *testing.T) {
+		fsys := newMockFS()
+		err := NewWriter(fsys).Put(context.Background(), &Media{path: "/a/b.jpg", image: img})
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.True(fsys.Has("/a/b.jpg")),
+		)
+	})
+
+	t.Run("EncodeFailed", func(t *testing.T) {
+		fsys := newMockFS()
+		large := image.NewGray(image.Rect(0, 0, 1<<16, 1))
+		err := NewWriter(fsys).Put(context.Background(), &Media{path: "/a/b.jpg", image: large})
+
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("codec I/O error"),
+			it.Equal(fsys.Len(), 0),
+		)
+	})
+
+	t.Run("CreateFailed", func(t *testing.T) {
+		fsys := newMockFS()
+		fsys.failCreate = errors.New("create")
+		err := NewWriter(fsys).Put(context.Background(), &Media{path: "/a/b.jpg", image: img})
+
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("codec I/O error"),
+			it.Equal(fsys.Len(), 0),
+		)
+	})
+
+	t.Run("WriteFailed", func(t *testing.T) {
+		fsys := newMockFS()
+		fsys.failWrite = errors.New("write")
+		err := NewWriter(fsys).Put(context.Background(), &Media{path: "/a/b.jpg", image: img})
+
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("codec I/O error"),
+			it.Equal(fsys.Len(), 0),
+		)
+	})
+
+	t.Run("WriteFailedKeepsPrevious", func(t *testing.T) {
+		fsys := newMockFS()
+		fsys.Put("/a/b.jpg", []byte("previous"))
+		fsys.failWrite = errors.New("write")
+		err := NewWriter(fsys).Put(context.Background(), &Media{path: "/a/b.jpg", image: img})
+
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("codec I/O error"),
+			it.Equal(string(fsys.files["/a/b.jpg"]), "previous"),
+		)
+	})
+
+	t.Run("CloseFailed", func(t *testing.T) {
+		fsys := newMockFS()
+		fsys.failClose = errors.New("close")
+		err := NewWriter(fsys).Put(context.Background(), &Media{path: "/a/b.jpg", image: img})
+
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("codec I/O error"),
+			it.Equal(fsys.Len(), 0),
+		)
+	})
+}
+
+//------------------------------------------------------------------------------
+
+// In-memory file system with fault injection, it commits file on close.
+// Faults are injected to paths containing failAt (any path if empty) or
+// to the failPath only. The number of faults is limited by failCount.
+type mockFS struct {
+	sync.Mutex
+	files      map[string][]byte
+	meta       map[string]Meta
+	failAt     string
+	failPath   string
+	failCount  int
+	failed     int
+	failCreate error
+	failWrite  error
+	failClose  error
+}
+
+var _ WriterFS = (*mockFS)(nil)
+
+func newMockFS() *mockFS {
+	return &mockFS{
+		files: map[string][]byte{},
+		meta:  map[string]Meta{},
+	}
+}
+
+func (fsys *mockFS) Has(path string) bool {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	_, has := fsys.files[path]
+	return has
+}
+
+func (fsys *mockFS) Len() int {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	return len(fsys.files)
+}
+
+func (fsys *mockFS) Put(path string, data []byte) {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	fsys.files[path] = data
+}
+
+func (fsys *mockFS) Open(path string) (fs.File, error) {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	data, has := fsys.files[path]
+	if !has {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+
+	var sys any
+	if meta, has := fsys.meta[path]; has {
+		sys = &meta
+	}
+
+	key := strings.TrimPrefix(path, "/")
+	return fstest.MapFS{key: {Data: data, ModTime: time.Now(), Sys: sys}}.Open(key)
+}
+
+func (fsys *mockFS) StatSys(stat fs.FileInfo) *Meta {
+	meta, _ := stat.Sys().(*Meta)
+	return meta
+}
+
+func (fsys *mockFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	dir := fstest.MapFS{}
+	for key, data := range fsys.files {
+		dir[strings.TrimPrefix(key, "/")] = &fstest.MapFile{Data: data}
+	}
+
+	return dir.ReadDir(strings.Trim(path, "/"))
+}
+
+func (fsys *mockFS) fault(path string, err error) error {
+	if err == nil || (fsys.failCount != 0 && fsys.failed >= fsys.failCount) {
+		return nil
+	}
+
+	if (fsys.failPath != "" && path == fsys.failPath) || (fsys.failPath == "" && strings.Contains(path, fsys.failAt)) {
+		fsys.failed++
+		return err
+	}
+	return nil
+}
+
+func (fsys *mockFS) Create(path string, meta *Meta) (stream.File, error) {
+	if err := fsys.fault(path, fsys.failCreate); err != nil {
+		return nil, err
+	}
+
+	return &mockFile{fsys: fsys, path: path, meta: meta}, nil
+}
+
+func (fsys *mockFS) Remove(path string) error {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	delete(fsys.files, path)
+	delete(fsys.meta, path)
+	return nil
+}
+
+type mockFile struct {
+	bytes.Buffer
+	fsys     *mockFS
+	path     string
+	meta     *Meta
+	canceled bool
+}
+
+func (fd *mockFile) Stat() (fs.FileInfo, error) { return nil, fs.ErrInvalid }
+
+func (fd *mockFile) Cancel() error {
+	fd.canceled = true
+	return nil
+}
+
+func (fd *mockFile) Write(b []byte) (int, error) {
+	if err := fd.fsys.fault(fd.path, fd.fsys.failWrite); err != nil {
+		return 0, err
+	}
+
+	return fd.Buffer.Write(b)
+}
+
+// faults are injected to streamed content as well
+func (fd *mockFile) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{fd}, r)
+}
+
+func (fd *mockFile) Close() error {
+	// emulates the object storage, where partial content is committed on close
+	fd.fsys.Lock()
+	defer fd.fsys.Unlock()
+
+	if fd.canceled {
+		return nil
+	}
+
+	fd.fsys.files[fd.path] = fd.Buffer.Bytes()
+	if fd.meta != nil {
+		fd.fsys.meta[fd.path] = *fd.meta
+	}
+
+	return fd.fsys.fault(fd.path, fd.fsys.failClose)
+}
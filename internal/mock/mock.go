@@ -0,0 +1,151 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+// Package mock implements test fixtures shared by packages using the codec.
+package mock
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing/fstest"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/fogfish/medium/internal/codec"
+	"github.com/fogfish/stream"
+	"github.com/fogfish/swarm"
+)
+
+// Emitter collects events
+type Emitter[T any] struct {
+	sync.Mutex
+	Events []T
+}
+
+func (e *Emitter[T]) Enq(_ context.Context, evt T, _ ...string) error {
+	e.Lock()
+	defer e.Unlock()
+
+	e.Events = append(e.Events, evt)
+	return nil
+}
+
+// NewEvent of S3 object
+func NewEvent(key string) swarm.Msg[*events.S3EventRecord] {
+	var evt events.S3EventRecord
+	evt.S3.Object.Key = key
+
+	return swarm.Msg[*events.S3EventRecord]{Object: &evt}
+}
+
+// NewJpeg encodes blank image
+func NewJpeg(w, h int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		panic(err)
+	}
+
+	return buf.Bytes()
+}
+
+// FS is in-memory file system
+type FS struct {
+	sync.Mutex
+	files map[string][]byte
+}
+
+var _ codec.WriterFS = (*FS)(nil)
+
+func NewFS() *FS { return &FS{files: map[string][]byte{}} }
+
+func (fsys *FS) Has(path string) bool {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	_, has := fsys.files[path]
+	return has
+}
+
+func (fsys *FS) Len() int {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	return len(fsys.files)
+}
+
+func (fsys *FS) Put(path string, data []byte) {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	fsys.files[path] = data
+}
+
+func (fsys *FS) Open(path string) (fs.File, error) {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	data, has := fsys.files[path]
+	if !has {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+
+	key := strings.TrimPrefix(path, "/")
+	return fstest.MapFS{key: {Data: data}}.Open(key)
+}
+
+func (fsys *FS) ReadDir(path string) ([]fs.DirEntry, error) {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	dir := fstest.MapFS{}
+	for key, data := range fsys.files {
+		dir[strings.TrimPrefix(key, "/")] = &fstest.MapFile{Data: data}
+	}
+
+	return dir.ReadDir(strings.Trim(path, "/"))
+}
+
+func (fsys *FS) Create(path string, _ *codec.Meta) (stream.File, error) {
+	return &file{fsys: fsys, path: path}, nil
+}
+
+func (fsys *FS) Remove(path string) error {
+	fsys.Lock()
+	defer fsys.Unlock()
+
+	delete(fsys.files, path)
+	return nil
+}
+
+type file struct {
+	bytes.Buffer
+	fsys     *FS
+	path     string
+	canceled bool
+}
+
+func (fd *file) Stat() (fs.FileInfo, error) { return nil, fs.ErrInvalid }
+
+func (fd *file) Cancel() error {
+	fd.canceled = true
+	return nil
+}
+
+func (fd *file) Close() error {
+	fd.fsys.Lock()
+	defer fd.fsys.Unlock()
+
+	if !fd.canceled {
+		fd.fsys.files[fd.path] = fd.Buffer.Bytes()
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+// Package takedown implements legal removal of published media. It removes
+// media and all its variants by key or content hash, records the reason and
+// blocks re-upload of the same content.
+package takedown
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/fogfish/faults"
+	"github.com/fogfish/medium/internal/codec"
+)
+
+const (
+	errInvalidRequest = faults.Type("invalid takedown request")
+	errNotFound       = faults.Safe1[string]("media is not published (%s)")
+	errTakedownIO     = faults.Type("takedown I/O error")
+)
+
+// Takedown request, either key or hash is required
+type Request struct {
+	Key    string `json:"key,omitempty"`  // S3 key of media at inbox
+	Hash   string `json:"hash,omitempty"` // sha256 of media content
+	Reason string `json:"reason"`         // reason of the takedown
+}
+
+// Audit event of the takedown, the hash of request is resolved to sha256 of
+// blocked content
+type MediaTakenDown struct {
+	Request
+	PHash string   `json:"banned,omitempty"` // perceptual hash of banned content
+	Keys  []string `json:"keys"`             // S3 keys of removed variants
+}
+
+type Takedown struct {
+	writer    *codec.Writer
+	index     *codec.Index
+	blocklist *codec.Blocklist
+	emitter   codec.Emitter[MediaTakenDown]
+}
+
+func New(media codec.WriterFS, index *codec.Index, blocklist *codec.Blocklist, emitter codec.Emitter[MediaTakenDown]) *Takedown {
+	return &Takedown{
+		writer:    codec.NewWriter(media),
+		index:     index,
+		blocklist: blocklist,
+		emitter:   emitter,
+	}
+}
+
+// Apply the takedown request
+func (t *Takedown) Apply(ctx context.Context, req Request) (*MediaTakenDown, error) {
+	if req.Key == "" && req.Hash == "" {
+		return nil, errInvalidRequest.With(nil)
+	}
+
+	manifests, err := t.lookup(req)
+	if err != nil {
+		return nil, err
+	}
+
+	event := MediaTakenDown{Request: req, Keys: []string{}}
+	if event.Hash == "" {
+		event.Hash = manifests[0].Hash
+	}
+
+	for _, manifest := range manifests {
+		slog.Info("taking down media",
+			slog.String("path", manifest.Source),
+			slog.String("hash", manifest.Hash),
+			slog.String("reason", req.Reason),
+		)
+
+		if err := t.writer.Unpublish(ctx, manifest); err != nil {
+			return nil, errTakedownIO.With(err)
+		}
+
+		if manifest.Hash != "" {
+			if err := t.index.Remove(ctx, manifest.Hash, manifest.Source); err != nil {
+				return nil, errTakedownIO.With(err)
+			}
+		}
+
+		// near-duplicates of the content are banned
+		if manifest.PHash != "" {
+			if err := t.index.RemoveSimilar(ctx, manifest.PHash, manifest.Source); err != nil {
+				return nil, errTakedownIO.With(err)
+			}
+
+			banned := codec.Similar{PHash: manifest.PHash, Source: manifest.Source, Reason: req.Reason}
+			if err := t.index.PutSimilar(ctx, banned); err != nil {
+				return nil, errTakedownIO.With(err)
+			}
+			event.PHash = manifest.PHash
+		}
+
+		for _, variant := range manifest.Objects() {
+			event.Keys = append(event.Keys, strings.TrimPrefix(variant, "/"))
+		}
+	}
+
+	if event.Hash != "" {
+		if err := t.blocklist.Add(ctx, event.Hash, req.Reason); err != nil {
+			return nil, errTakedownIO.With(err)
+		}
+	}
+
+	if t.emitter != nil {
+		if err := t.emitter.Enq(ctx, event); err != nil {
+			return nil, errTakedownIO.With(err)
+		}
+	}
+
+	return &event, nil
+}
+
+// lookup manifests of published media matching the request, the media
+// requested by key must be published
+func (t *Takedown) lookup(req Request) ([]*codec.Manifest, error) {
+	var seq []*codec.Manifest
+
+	if req.Key != "" {
+		manifest, err := t.manifest(filepath.Join("/", req.Key))
+		if err != nil {
+			return nil, err
+		}
+		if manifest == nil {
+			return nil, errNotFound.With(nil, req.Key)
+		}
+		seq = append(seq, manifest)
+	}
+
+	if req.Hash != "" {
+		sources, err := t.index.Sources(req.Hash)
+		if err != nil {
+			return nil, errTakedownIO.With(err)
+		}
+
+		for _, source := range sources {
+			if len(seq) > 0 && seq[0].Source == source {
+				continue
+			}
+
+			manifest, err := t.manifest(source)
+			if err != nil {
+				return nil, err
+			}
+
+			// source might be re-published with other content
+			if manifest != nil && manifest.Hash == req.Hash {
+				seq = append(seq, manifest)
+			}
+		}
+	}
+
+	return seq, nil
+}
+
+func (t *Takedown) manifest(source string) (*codec.Manifest, error) {
+	manifest, err := t.writer.Manifest(source)
+	switch {
+	case err == nil:
+		return manifest, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, nil
+	default:
+		return nil, errTakedownIO.With(err)
+	}
+}
@@ -0,0 +1,146 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package takedown_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/fogfish/it/v2"
+	"github.com/fogfish/medium"
+	"github.com/fogfish/medium/internal/codec"
+	"github.com/fogfish/medium/internal/mock"
+	"github.com/fogfish/medium/internal/takedown"
+)
+
+func TestTakedown(t *testing.T) {
+	profile := medium.On("a", "").Process(
+		medium.ScaleTo("small", 4, 4),
+		medium.Replica("origin"),
+	)
+
+	setup := func(t *testing.T) (*mock.FS, *mock.FS, *codec.Index, string) {
+		t.Helper()
+
+		data := mock.NewJpeg(16, 12)
+		inbox, media, index := mock.NewFS(), mock.NewFS(), codec.NewIndex(mock.NewFS())
+		inbox.Put("/a/b.jpg", data)
+		inbox.Put("/a/c.jpg", data)
+
+		duplicates, err := codec.NewDuplicates(index, 0, codec.MODERATION_REVIEW)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// perceptual hash is recorded for a/b.jpg only, a/c.jpg is published
+		// without detection of duplicates
+		for key, c := range map[string]*codec.Codec{
+			"a/b.jpg": codec.NewCodec(profile, inbox, media, codec.Emitters{}, codec.WithIndex(index), codec.WithDuplicates(duplicates)),
+			"a/c.jpg": codec.NewCodec(profile, inbox, media, codec.Emitters{}, codec.WithIndex(index)),
+		} {
+			if err := c.Process(context.Background(), mock.NewEvent(key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		hash := sha256.Sum256(data)
+		return inbox, media, index, hex.EncodeToString(hash[:])
+	}
+
+	t.Run("ByKey", func(t *testing.T) {
+		_, media, index, hash := setup(t)
+		blocklist := mock.NewFS()
+		emitter := &mock.Emitter[takedown.MediaTakenDown]{}
+
+		td := takedown.New(media, index, codec.NewBlocklist(blocklist), emitter)
+		evt, err := td.Apply(context.Background(), takedown.Request{Key: "a/b.jpg", Reason: "test"})
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(evt.Hash, hash),
+			it.Seq(evt.Keys).Equal("a/b.small-4x4.jpg", "a/b.origin.jpg"),
+			it.Equal(media.Has("/a/b.small-4x4.jpg"), false),
+			it.True(media.Has("/a/c.small-4x4.jpg")),
+			it.True(blocklist.Has("/"+hash)),
+			it.Equal(len(emitter.Events), 1),
+		)
+
+		sources, err := index.Sources(hash)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Seq(sources).Equal("/a/c.jpg"),
+		)
+
+		// near-duplicates of the content are banned
+		phash, err := codec.ParsePHash(evt.PHash)
+		it.Then(t).Should(it.Nil(err))
+
+		similar, err := index.Similar(phash, 0)
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(len(similar), 1),
+			it.Equal(similar[0].Reason, "test"),
+		)
+	})
+
+	t.Run("ByHash", func(t *testing.T) {
+		_, media, index, hash := setup(t)
+		blocklist := mock.NewFS()
+
+		td := takedown.New(media, index, codec.NewBlocklist(blocklist), nil)
+		evt, err := td.Apply(context.Background(), takedown.Request{Hash: hash, Reason: "test"})
+
+		it.Then(t).Should(
+			it.Nil(err),
+			it.Equal(len(evt.Keys), 4),
+			it.Equal(media.Len(), 0),
+			it.True(blocklist.Has("/"+hash)),
+		)
+	})
+
+	t.Run("BlocksReupload", func(t *testing.T) {
+		inbox, media, index, hash := setup(t)
+		blocklist := codec.NewBlocklist(mock.NewFS())
+
+		td := takedown.New(media, index, blocklist, nil)
+		_, err := td.Apply(context.Background(), takedown.Request{Hash: hash, Reason: "test"})
+		it.Then(t).Should(it.Nil(err))
+
+		c := codec.NewCodec(profile, inbox, media, codec.Emitters{}, codec.WithModerator(codec.Rules{Blocklist: blocklist}))
+		it.Then(t).Should(
+			it.Nil(c.Process(context.Background(), mock.NewEvent("a/b.jpg"))),
+			it.Equal(media.Len(), 0),
+		)
+	})
+
+	t.Run("NotPublished", func(t *testing.T) {
+		_, media, index, _ := setup(t)
+		blocklist, emitter := mock.NewFS(), &mock.Emitter[takedown.MediaTakenDown]{}
+
+		td := takedown.New(media, index, codec.NewBlocklist(blocklist), emitter)
+		_, err := td.Apply(context.Background(), takedown.Request{Key: "a/d.jpg", Reason: "test"})
+
+		it.Then(t).Should(
+			it.Fail(func() error { return err }).Contain("media is not published"),
+			it.Equal(len(emitter.Events), 0),
+			it.Equal(blocklist.Len(), 0),
+		)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		td := takedown.New(mock.NewFS(), codec.NewIndex(mock.NewFS()), codec.NewBlocklist(mock.NewFS()), nil)
+		_, err := td.Apply(context.Background(), takedown.Request{Reason: "test"})
+
+		it.Then(t).ShouldNot(
+			it.Nil(err),
+		)
+	})
+}
@@ -0,0 +1,21 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package medium
+
+// Rules of local moderation, zero value of the rule disables it. Media that
+// violates any rule is denied. The rules apply to images only, the geometry
+// of video, audio and documents is not moderated.
+type Rules struct {
+	MinWidth  int     `json:"minWidth,omitempty"`
+	MinHeight int     `json:"minHeight,omitempty"`
+	MaxWidth  int     `json:"maxWidth,omitempty"`
+	MaxHeight int     `json:"maxHeight,omitempty"`
+	MinAspect float64 `json:"minAspect,omitempty"` // width / height
+	MaxAspect float64 `json:"maxAspect,omitempty"` // width / height
+}
@@ -0,0 +1,182 @@
+//
+// Copyright (C) 2023 Dmitry Kolesnikov
+//
+// This file may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+// https://github.com/fogfish/medium
+//
+
+package medium
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Placeholder of output key template {name} or {name[:N]}
+var placeholder = regexp.MustCompile(`\{([a-z0-9]+)(?:\[:([0-9]+)\])?\}`)
+
+// Variables of output key template
+type OutputVars struct {
+	Path       string     // path of the original media file
+	Hash       string     // sha256 of the original media file
+	Resolution Resolution // resolution of the media file
+	Format     string     // format of the media file (e.g. jpeg)
+	Ext        string     // extension of the media file
+}
+
+func validateOutput(template string) error {
+	if template == "" {
+		return fmt.Errorf("invalid output template")
+	}
+
+	if strings.ContainsAny(template, "|,") {
+		return fmt.Errorf("invalid output template: %s", template)
+	}
+
+	for _, m := range placeholder.FindAllStringSubmatch(template, -1) {
+		switch m[1] {
+		case "prefix", "dir", "name", "sha256", "hash",
+			"label", "width", "w", "height", "h", "format", "ext":
+		default:
+			return fmt.Errorf("invalid output template: unknown {%s}", m[1])
+		}
+	}
+
+	return nil
+}
+
+// Variants of the profile are published at distinct keys, the template
+// distinguishes them by {label} or by the resolution. Sidecars (playlists of
+// video, peaks of audio) are published along with variants, the rendition and
+// its media playlist share the label, they are distinguished by {ext}.
+func (p Profile) validateLayout() error {
+	if p.Output == "" {
+		return nil
+	}
+
+	if err := validateOutput(p.Output); err != nil {
+		return err
+	}
+
+	ext := false
+	for _, m := range placeholder.FindAllStringSubmatch(p.Output, -1) {
+		ext = ext || m[1] == "ext" || m[1] == "format"
+	}
+
+	keys := map[string]string{}
+	unique := func(r Resolution, ext string) error {
+		key := p.OutputKey(OutputVars{Path: "/a/b", Hash: "0", Resolution: r, Format: ext, Ext: ext})
+		if other, has := keys[key]; has {
+			return fmt.Errorf("invalid output template: variants %s and %s.%s share the key, use {label}", other, r, ext)
+		}
+		keys[key] = r.String() + "." + ext
+		return nil
+	}
+
+	playlist := false
+	for _, r := range p.Resolutions {
+		if r.Bitrate == 0 {
+			if err := unique(r, "jpg"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !ext {
+			return fmt.Errorf("invalid output template: rendition %s requires {ext} or {format}", r)
+		}
+		if err := unique(r, "mp4"); err != nil {
+			return err
+		}
+		if err := unique(r, "m3u8"); err != nil {
+			return err
+		}
+		playlist = true
+	}
+
+	if playlist {
+		if err := unique(Resolution{Label: labelPlaylist}, "m3u8"); err != nil {
+			return err
+		}
+	}
+
+	return unique(Resolution{Label: labelPeaks}, "json")
+}
+
+// ContentAddressed layout derives keys from the hash of media file, the keys
+// cannot be derived from the path of media file.
+func (p Profile) ContentAddressed() bool {
+	for _, m := range placeholder.FindAllStringSubmatch(p.Output, -1) {
+		if m[1] == "sha256" || m[1] == "hash" {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputKey returns key of the media file produced by the profile.
+// The key is derived from original one if output template is not defined.
+//
+// Output key template defines the layout of media files at S3 bucket.
+// The template consists of placeholders substituted for each media file:
+//
+//	{prefix}         - path prefix of the profile
+//	{dir}            - directory of the original media file
+//	{name}           - name of the original media file without extension
+//	{sha256}, {hash} - hash of the original media file
+//	{label}          - label of the resolution
+//	{width}, {w}     - width of the resolution (0 for replica)
+//	{height}, {h}    - height of the resolution (0 for replica)
+//	{format}         - format of the media file (e.g. jpeg)
+//	{ext}            - extension of the media file
+//
+// The placeholder is truncated to first N characters using {sha256[:N]}.
+// The template must not contain `|` and `,` characters.
+//
+//	{prefix}/{sha256[:16]}.{label}.{ext}
+//	{dir}/{label}/{name}.{ext}
+//	{name}/{w}w.{ext}
+func (p Profile) OutputKey(vars OutputVars) string {
+	if p.Output == "" {
+		return vars.Resolution.FileSuffix(vars.Path) + "." + vars.Ext
+	}
+
+	key := placeholder.ReplaceAllStringFunc(p.Output, func(s string) string {
+		m := placeholder.FindStringSubmatch(s)
+
+		var val string
+		switch m[1] {
+		case "prefix":
+			val = p.Prefix
+		case "dir":
+			val = filepath.Dir(vars.Path)
+		case "name":
+			base := filepath.Base(vars.Path)
+			val = strings.TrimSuffix(base, filepath.Ext(base))
+		case "sha256", "hash":
+			val = vars.Hash
+		case "label":
+			val = vars.Resolution.Label
+		case "width", "w":
+			val = strconv.Itoa(vars.Resolution.Width)
+		case "height", "h":
+			val = strconv.Itoa(vars.Resolution.Height)
+		case "format":
+			val = vars.Format
+		case "ext":
+			val = vars.Ext
+		}
+
+		if n, err := strconv.Atoi(m[2]); err == nil && n < len(val) {
+			val = val[:n]
+		}
+
+		return val
+	})
+
+	return filepath.Join("/", key)
+}
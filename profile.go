@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Media encoding profile, ensemble of resolutions builds the profile
@@ -22,13 +23,34 @@ type Profile struct {
 	Suffix      string       // S3 file extension
 	Resolutions []Resolution // array of transformation functions
 	Sink        string       // Event Sink when successfully completed
+	Atomic      bool         // Publish variants all-or-nothing
+	Reemit      bool         // Emit event again for already published media
+	Output      string       // Output key template
+
+	// Media is quarantined and published only after approval
+	RequireApproval bool
+
+	// Animation is kept for animated media, otherwise the poster (first frame)
+	// is used. Animation exceeding limits is replaced by the poster.
+	Animated    bool
+	MaxFrames   int           // limit of animation frames, 0 is unlimited
+	MaxDuration time.Duration // limit of animation duration, 0 is unlimited
+
+	// Timestamp of video frame used as the poster
+	Poster time.Duration
+
+	// Page of document used as the poster, the first page if 0
+	Page int
+
+	// ICC profile is kept by sanitized replica, otherwise it is removed
+	KeepICC bool
 }
 
 // Profiles is part of config DSL
 func Profiles(seq ...Profile) []Profile { return seq }
 
 // Parses Profile from string
-// {Path}.{Ext}|{Name}-{Width}x{Height}:{Name}-{Width}x{Height}|{Sink}
+// {Path}.{Ext}|{Name}-{Width}x{Height}:{Name}-{Width}x{Height}|{Sink}|{Option},{Option}
 func NewProfile(spec string) (Profile, error) {
 	seq := strings.Split(spec, "|")
 	if len(seq) < 2 {
@@ -65,12 +87,114 @@ func NewProfile(spec string) (Profile, error) {
 		sink = seq[2]
 	}
 
-	return Profile{
+	profile := Profile{
 		Prefix:      prefix,
 		Suffix:      suffix,
 		Resolutions: resolutions,
 		Sink:        sink,
-	}, nil
+	}
+
+	// Options
+	if len(seq) > 3 {
+		if err := profile.parseOptions(seq[3]); err != nil {
+			return Profile{}, err
+		}
+	}
+
+	if err := profile.Validate(); err != nil {
+		return Profile{}, err
+	}
+
+	return profile, nil
+}
+
+func (p *Profile) parseOptions(spec string) error {
+	for _, opt := range strings.Split(spec, ",") {
+		key, val, _ := strings.Cut(opt, "=")
+		switch key {
+		case "":
+			continue
+		case "atomic":
+			p.Atomic = true
+		case "reemit":
+			p.Reemit = true
+		case "approval":
+			p.RequireApproval = true
+		case "animated":
+			p.Animated = true
+		case "frames":
+			frames, err := strconv.Atoi(val)
+			if err != nil || frames < 0 {
+				return fmt.Errorf("invalid option: %s", opt)
+			}
+			p.MaxFrames = frames
+		case "duration":
+			duration, err := time.ParseDuration(val)
+			if err != nil || duration < 0 {
+				return fmt.Errorf("invalid option: %s", opt)
+			}
+			p.MaxDuration = duration
+		case "poster":
+			poster, err := time.ParseDuration(val)
+			if err != nil || poster < 0 {
+				return fmt.Errorf("invalid option: %s", opt)
+			}
+			p.Poster = poster
+		case "page":
+			page, err := strconv.Atoi(val)
+			if err != nil || page < 1 {
+				return fmt.Errorf("invalid option: %s", opt)
+			}
+			p.Page = page
+		case "icc":
+			p.KeepICC = true
+		case "output":
+			if err := validateOutput(val); err != nil {
+				return err
+			}
+			p.Output = val
+		default:
+			return fmt.Errorf("invalid option: %s", opt)
+		}
+	}
+
+	return nil
+}
+
+func (p Profile) options() []string {
+	var seq []string
+	if p.Atomic {
+		seq = append(seq, "atomic")
+	}
+	if p.Reemit {
+		seq = append(seq, "reemit")
+	}
+	if p.RequireApproval {
+		seq = append(seq, "approval")
+	}
+	if p.Animated {
+		seq = append(seq, "animated")
+	}
+	if p.MaxFrames != 0 {
+		seq = append(seq, "frames="+strconv.Itoa(p.MaxFrames))
+	}
+	if p.MaxDuration != 0 {
+		seq = append(seq, "duration="+p.MaxDuration.String())
+	}
+	if p.Poster != 0 {
+		seq = append(seq, "poster="+p.Poster.String())
+	}
+	if p.Page != 0 {
+		seq = append(seq, "page="+strconv.Itoa(p.Page))
+	}
+	if p.KeepICC {
+		seq = append(seq, "icc")
+	}
+	if p.Output != "" {
+		seq = append(seq, "output="+p.Output)
+	}
+
+	return seq
 }
 
 func (p Profile) String() string {
@@ -88,28 +212,111 @@ func (p Profile) String() string {
 	}
 	bseq = append(bseq, fmap)
 
-	if p.Sink != "" {
+	opts := p.options()
+	if p.Sink != "" || len(opts) > 0 {
 		bseq = append(bseq, p.Sink)
 	}
 
+	if len(opts) > 0 {
+		bseq = append(bseq, strings.Join(opts, ","))
+	}
+
 	return strings.Join(bseq, "|")
 }
 
 // Media file resolution.
 type Resolution struct {
-	Label  string
-	Width  int
-	Height int
+	Label   string
+	Width   int
+	Height  int
+	Bitrate int    // kbit/s of video rendition, 0 for images
+	Filter  Filter // resampling filter of image, Lanczos if empty
+	Copy    Copy   // replica copies bytes of source, re-encoded if empty
+}
+
+// Resampling filter used to scale images
+type Filter string
+
+const (
+	Nearest    = Filter("nearest")    // pixel-art, screenshots and QR codes
+	Linear     = Filter("linear")     // fast thumbnails
+	CatmullRom = Filter("catmullrom") // photos, sharp
+	Lanczos    = Filter("lanczos")    // photos, default
+)
+
+func (f Filter) valid() bool {
+	switch f {
+	case Nearest, Linear, CatmullRom, Lanczos:
+		return true
+	default:
+		return false
+	}
 }
 
-// Parses resolution from string {Name}-{Width}x{Height}
+// Copy mode of replica
+type Copy string
+
+const (
+	Exact     = Copy("exact")     // byte-exact copy of source
+	Sanitized = Copy("sanitized") // copy of source without metadata, pixels are not re-encoded
+)
+
+func (c Copy) valid() bool {
+	return c == Exact || c == Sanitized
+}
+
+// Labels of sidecars published along with variants, they are reserved
+const (
+	labelPlaylist = "hls"
+	labelPeaks    = "peaks"
+)
+
+// Parses resolution from string {Name}-{Width}x{Height}, video rendition
+// defines bitrate {Name}-{Width}x{Height}@{Bitrate}k, image defines the
+// resampling filter {Name}-{Width}x{Height}~{Filter}, replica defines the
+// copy mode {Name}~{Copy}. Labels hls and peaks are reserved for sidecars.
 func NewResolution(spec string) (Resolution, error) {
+	r, err := newResolution(spec)
+	if err != nil {
+		return Resolution{}, err
+	}
+
+	if r.Label == labelPlaylist || r.Label == labelPeaks {
+		return Resolution{}, fmt.Errorf("invalid resolution: %s (label %s is reserved)", spec, r.Label)
+	}
+
+	return r, nil
+}
+
+func newResolution(spec string) (Resolution, error) {
 	if len(spec) == 0 {
 		return Resolution{}, fmt.Errorf("invalid resolution: %s", spec)
 	}
 
+	filter := Filter("")
+	if base, f, has := strings.Cut(spec, "~"); has {
+		if mode := Copy(f); mode.valid() && len(base) != 0 && !strings.ContainsAny(base, "-@") {
+			return Resolution{Label: base, Copy: mode}, nil
+		}
+
+		filter = Filter(f)
+		if !filter.valid() {
+			return Resolution{}, fmt.Errorf("invalid resolution: %s", spec)
+		}
+		spec = base
+	}
+
+	bitrate := 0
+	if base, rate, has := strings.Cut(spec, "@"); has {
+		kbps, err := strconv.Atoi(strings.TrimSuffix(rate, "k"))
+		if err != nil || kbps <= 0 || !strings.HasSuffix(rate, "k") {
+			return Resolution{}, fmt.Errorf("invalid resolution: %s", spec)
+		}
+		spec, bitrate = base, kbps
+	}
+
 	seq := strings.Split(spec, "-")
-	if len(seq) == 1 {
+	if len(seq) == 1 && bitrate == 0 && filter == "" {
 		return Resolution{Label: spec}, nil
 	}
 
@@ -132,14 +339,39 @@ func NewResolution(spec string) (Resolution, error) {
 		return Resolution{}, fmt.Errorf("invalid resolution: %s", spec)
 	}
 
+	if bitrate != 0 && (width <= 0 || height <= 0) {
+		return Resolution{}, fmt.Errorf("invalid resolution: %s", spec)
+	}
+
+	// filter is applicable only to scaled images
+	if filter != "" && (bitrate != 0 || (width == 0 && height == 0)) {
+		return Resolution{}, fmt.Errorf("invalid resolution: %s", spec)
+	}
+
 	return Resolution{
-		Label:  seq[0],
-		Width:  width,
-		Height: height,
+		Label:   seq[0],
+		Width:   width,
+		Height:  height,
+		Bitrate: bitrate,
+		Filter:  filter,
 	}, nil
 }
 
 func (r Resolution) String() string {
+	switch {
+	case r.Bitrate != 0:
+		return fmt.Sprintf("%s@%dk", r.name(), r.Bitrate)
+	case r.Filter != "":
+		return fmt.Sprintf("%s~%s", r.name(), r.Filter)
+	case r.Copy != "" && r.Width == 0 && r.Height == 0:
+		return fmt.Sprintf("%s~%s", r.name(), r.Copy)
+	default:
+		return r.name()
+	}
+}
+
+// name of resolution used by file suffix
+func (r Resolution) name() string {
 	if r.Width == 0 && r.Height == 0 {
 		return r.Label
 	}
@@ -147,9 +379,38 @@ func (r Resolution) String() string {
 	return fmt.Sprintf("%s-%dx%d", r.Label, r.Width, r.Height)
 }
 
+// Srcset returns name of responsive image set and descriptor of the
+// resolution (e.g. 2x, 320w). The resolution belongs to the set if its label
+// is {Set}.{Descriptor}, see Srcset and WidthLadder.
+func (r Resolution) Srcset() (string, string, bool) {
+	i := strings.LastIndex(r.Label, ".")
+	if i <= 0 {
+		return "", "", false
+	}
+
+	set, descriptor := r.Label[:i], r.Label[i+1:]
+	if len(descriptor) < 2 {
+		return "", "", false
+	}
+
+	val, err := strconv.Atoi(descriptor[:len(descriptor)-1])
+	if err != nil || val <= 0 {
+		return "", "", false
+	}
+
+	switch {
+	case descriptor[len(descriptor)-1] == 'x' && (r.Width != 0 || r.Height != 0):
+		return set, descriptor, true
+	case descriptor[len(descriptor)-1] == 'w' && r.Width == val:
+		return set, descriptor, true
+	default:
+		return "", "", false
+	}
+}
+
 func (r Resolution) FileSuffix(path string) string {
 	ext := filepath.Ext(path)
-	return strings.TrimSuffix(path, ext) + "." + r.String()
+	return strings.TrimSuffix(path, ext) + "." + r.name()
 }
 
 //
@@ -164,30 +425,187 @@ func On(prefix, suffix string) Profile {
 
 // `Process` defines operation to be executed for media file.
 func (p Profile) Process(seq ...Resolution) Profile {
-	return Profile{
-		Prefix:      p.Prefix,
-		Suffix:      p.Suffix,
-		Resolutions: seq,
-		Sink:        p.Sink,
+	p.Resolutions = seq
+	return p
+}
+
+// `Responsive` appends sets of responsive images to operations of the
+// profile, see Srcset and WidthLadder.
+//
+//	medium.On("photo", "").
+//		Process(medium.Replica("origin")).
+//		Responsive(
+//			medium.Srcset("thumb", 240, 240, 1, 2, 3),
+//			medium.WidthLadder("w", 320, 640, 1024, 1600),
+//		)
+func (p Profile) Responsive(sets ...[]Resolution) Profile {
+	seq := append([]Resolution{}, p.Resolutions...)
+	for _, set := range sets {
+		seq = append(seq, set...)
 	}
+	p.Resolutions = seq
+	return p
 }
 
-// ScaleTo processing step scales media into specified resolution
+// ScaleTo processing step scales media into specified resolution, the
+// resolution of single dimension is replica (see WidthLadder)
 func ScaleTo(label string, w int, h int) Resolution {
 	return Resolution{Label: label, Width: w, Height: h}
 }
 
-// Replica processing step copies media "almost" as-is
+// Resample defines the resampling filter used to scale media
+//
+//	medium.ScaleTo("qr", 256, 256).Resample(medium.Nearest)
+func (r Resolution) Resample(filter Filter) Resolution {
+	r.Filter = filter
+	return r
+}
+
+// Rendition processing step transcodes video into specified resolution
+// and bitrate (kbit/s). The rendition is published as H.264 video along
+// with HLS playlist.
+func Rendition(label string, w int, h int, bitrate int) Resolution {
+	return Resolution{Label: label, Width: w, Height: h, Bitrate: bitrate}
+}
+
+// Srcset processing step scales media into resolutions of pixel densities
+// (e.g. 1x, 2x, 3x) for responsive images. Resolutions are labeled
+// {label}.{density}x
+//
+//	medium.Srcset("thumb", 240, 240, 1, 2, 3) // thumb.1x-240x240, thumb.2x-480x480, ...
+func Srcset(label string, w int, h int, density ...int) []Resolution {
+	seq := make([]Resolution, len(density))
+	for i, x := range density {
+		seq[i] = Resolution{
+			Label:  fmt.Sprintf("%s.%dx", label, x),
+			Width:  w * x,
+			Height: h * x,
+		}
+	}
+	return seq
+}
+
+// WidthLadder processing step scales media into widths for responsive
+// images, the height keeps the aspect ratio. Resolutions are labeled
+// {label}.{width}w
+//
+//	medium.WidthLadder("w", 320, 640) // w.320w-320x0, w.640w-640x0
+func WidthLadder(label string, widths ...int) []Resolution {
+	seq := make([]Resolution, len(widths))
+	for i, w := range widths {
+		seq[i] = Resolution{
+			Label: fmt.Sprintf("%s.%dw", label, w),
+			Width: w,
+		}
+	}
+	return seq
+}
+
+// Replica processing step copies media "almost" as-is, the image is
+// re-encoded so that its metadata is removed
 func Replica(label string) Resolution {
 	return Resolution{Label: label, Width: 0, Height: 0}
 }
 
+// ExactReplica processing step copies bytes of media as-is, the format and
+// metadata of the source are preserved
+func ExactReplica(label string) Resolution {
+	return Resolution{Label: label, Copy: Exact}
+}
+
+// SanitizedReplica processing step copies bytes of media without metadata
+// (e.g. EXIF, XMP, IPTC), the image is not re-encoded
+func SanitizedReplica(label string) Resolution {
+	return Resolution{Label: label, Copy: Sanitized}
+}
+
 // Sink output to event bus
 func (p Profile) SinkTo(sink string) Profile {
-	return Profile{
-		Prefix:      p.Prefix,
-		Suffix:      p.Suffix,
-		Resolutions: p.Resolutions,
-		Sink:        sink,
+	p.Sink = sink
+	return p
+}
+
+// Atomically publishes media variants all-or-nothing. Variants are staged
+// and promoted only when all of them are successfully processed.
+func (p Profile) Atomically() Profile {
+	p.Atomic = true
+	return p
+}
+
+// Reemitting the event when the same media is uploaded again. The media is
+// not re-encoded if its variants are already published.
+func (p Profile) Reemitting() Profile {
+	p.Reemit = true
+	return p
+}
+
+// WithApproval quarantines media until it is approved
+func (p Profile) WithApproval() Profile {
+	p.RequireApproval = true
+	return p
+}
+
+// KeepAnimation of animated media within the limits of frames and duration,
+// zero value disables the limit. The animation is published as GIF, including
+// animated WebP.
+func (p Profile) KeepAnimation(frames int, duration time.Duration) Profile {
+	p.Animated = true
+	p.MaxFrames = frames
+	p.MaxDuration = duration
+	return p
+}
+
+// PosterAt defines timestamp of video frame used as the poster, the poster
+// is scaled to resolutions of the profile.
+func (p Profile) PosterAt(at time.Duration) Profile {
+	p.Poster = at
+	return p
+}
+
+// CoverPage defines page of document used as the poster, the poster is
+// scaled to resolutions of the profile. The first page is used if document
+// has less pages.
+func (p Profile) CoverPage(page int) Profile {
+	p.Page = page
+	return p
+}
+
+// KeepColorProfile keeps ICC profile of media copied by sanitized replica,
+// the profile defines color rendering of wide gamut photos.
+func (p Profile) KeepColorProfile() Profile {
+	p.KeepICC = true
+	return p
+}
+
+// OutputTo defines the template of output keys, see OutputKey for details.
+// Content addressed keys builds immutable URLs of media files
+//
+//	medium.On("photo", "").OutputTo("{prefix}/{sha256[:16]}.{label}.{ext}")
+func (p Profile) OutputTo(template string) Profile {
+	p.Output = template
+	return p
+}
+
+// Validate the profile, the profile defined by DSL is validated when
+// the stack is synthesized.
+func (p Profile) Validate() error {
+	if err := p.validateResolutions(); err != nil {
+		return err
+	}
+
+	return p.validateLayout()
+}
+
+// Resolutions are passed to the codec as specification, resolution defined
+// by DSL is valid if the specification parses back to it (e.g. the filter
+// of rendition is not specified).
+func (p Profile) validateResolutions() error {
+	for _, r := range p.Resolutions {
+		spec, err := NewResolution(r.String())
+		if err != nil || spec != r {
+			return fmt.Errorf("invalid resolution: %s (filter %q, copy %q)", r.name(), r.Filter, r.Copy)
+		}
 	}
+
+	return nil
 }
@@ -10,6 +10,7 @@ package medium_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/fogfish/it/v2"
 	"github.com/fogfish/medium"
@@ -18,11 +19,18 @@ import (
 func TestResolution(t *testing.T) {
 	t.Run("WellFormat", func(t *testing.T) {
 		for input, expect := range map[string]medium.Resolution{
-			"pixel-1x1":       {Label: "pixel", Width: 1, Height: 1},
-			"small-128x128":   {Label: "small", Width: 128, Height: 128},
-			"large-1080x1920": {Label: "large", Width: 1080, Height: 1920},
-			"origin":          {Label: "origin", Width: 0, Height: 0},
-			"o":               {Label: "o", Width: 0, Height: 0},
+			"pixel-1x1":          {Label: "pixel", Width: 1, Height: 1},
+			"small-128x128":      {Label: "small", Width: 128, Height: 128},
+			"large-1080x1920":    {Label: "large", Width: 1080, Height: 1920},
+			"origin":             {Label: "origin", Width: 0, Height: 0},
+			"o":                  {Label: "o", Width: 0, Height: 0},
+			"hd-1280x720@2500k":  {Label: "hd", Width: 1280, Height: 720, Bitrate: 2500},
+			"qr-256x256~nearest": {Label: "qr", Width: 256, Height: 256, Filter: medium.Nearest},
+			"w-320x0~linear":     {Label: "w", Width: 320, Filter: medium.Linear},
+			"p-64x64~catmullrom": {Label: "p", Width: 64, Height: 64, Filter: medium.CatmullRom},
+			"p-64x64~lanczos":    {Label: "p", Width: 64, Height: 64, Filter: medium.Lanczos},
+			"origin~exact":       {Label: "origin", Copy: medium.Exact},
+			"origin~sanitized":   {Label: "origin", Copy: medium.Sanitized},
 		} {
 			val, err := medium.NewResolution(input)
 			it.Then(t).Should(
@@ -41,6 +49,21 @@ func TestResolution(t *testing.T) {
 			"small-x128",
 			"small-Ax128",
 			"small-128xA",
+			"hd@2500k",
+			"hd-1280x720@",
+			"hd-1280x720@2500",
+			"hd-1280x720@0k",
+			"hd-1280x720@Ak",
+			"qr-256x256~",
+			"qr-256x256~bicubic",
+			"origin~nearest",
+			"hd-1280x720@2500k~linear",
+			"~exact",
+			"origin~copy",
+			"small-128x128~exact",
+			"hd-1280x720@2500k~sanitized",
+			"hls",
+			"peaks-100x100",
 		} {
 			_, err := medium.NewResolution(input)
 			it.Then(t).ShouldNot(
@@ -48,17 +71,94 @@ func TestResolution(t *testing.T) {
 			)
 		}
 	})
+
+	t.Run("Replica", func(t *testing.T) {
+		it.Then(t).Should(
+			it.Equal(medium.ExactReplica("origin").String(), "origin~exact"),
+			it.Equal(medium.SanitizedReplica("origin").String(), "origin~sanitized"),
+			it.Equal(medium.SanitizedReplica("origin").FileSuffix("a/b.jpg"), "a/b.origin"),
+		)
+	})
+
+	t.Run("Resample", func(t *testing.T) {
+		r := medium.ScaleTo("qr", 256, 256).Resample(medium.Nearest)
+		it.Then(t).Should(
+			it.Equiv(r, medium.Resolution{Label: "qr", Width: 256, Height: 256, Filter: medium.Nearest}),
+			it.Equal(r.String(), "qr-256x256~nearest"),
+			it.Equal(r.FileSuffix("a/b.png"), "a/b.qr-256x256"),
+		)
+	})
+}
+
+func TestResponsive(t *testing.T) {
+	t.Run("Srcset", func(t *testing.T) {
+		it.Then(t).Should(
+			it.Seq(medium.Srcset("thumb", 240, 120, 1, 2, 3)).Equal(
+				medium.Resolution{Label: "thumb.1x", Width: 240, Height: 120},
+				medium.Resolution{Label: "thumb.2x", Width: 480, Height: 240},
+				medium.Resolution{Label: "thumb.3x", Width: 720, Height: 360},
+			),
+		)
+	})
+
+	t.Run("WidthLadder", func(t *testing.T) {
+		it.Then(t).Should(
+			it.Seq(medium.WidthLadder("w", 320, 640)).Equal(
+				medium.Resolution{Label: "w.320w", Width: 320},
+				medium.Resolution{Label: "w.640w", Width: 640},
+			),
+		)
+	})
+
+	t.Run("Responsive", func(t *testing.T) {
+		profile := medium.On("f", "").
+			Process(medium.Replica("o")).
+			Responsive(medium.Srcset("t", 1, 1, 2), medium.WidthLadder("w", 8))
+
+		it.Then(t).Should(
+			it.Equal(profile.String(), "f|o:t.2x-2x2:w.8w-8x0"),
+		)
+	})
+
+	t.Run("Resolution", func(t *testing.T) {
+		for _, r := range append(medium.Srcset("t.a", 1, 1, 2), medium.WidthLadder("w", 8)...) {
+			set, descriptor, ok := r.Srcset()
+			it.Then(t).Should(
+				it.True(ok),
+				it.Equal(set+"."+descriptor, r.Label),
+			)
+		}
+
+		for _, r := range []medium.Resolution{
+			medium.Replica("o"),
+			medium.Replica("o.2x"),
+			medium.ScaleTo("a", 1, 1),
+			medium.ScaleTo(".2x", 1, 1),
+			medium.ScaleTo("a.x", 1, 1),
+			medium.ScaleTo("a.0x", 1, 1),
+			medium.ScaleTo("a.2y", 1, 1),
+			medium.ScaleTo("w.320w", 640, 0),
+		} {
+			_, _, ok := r.Srcset()
+			it.Then(t).Should(it.Equal(ok, false))
+		}
+	})
 }
 
 func TestProfile(t *testing.T) {
 	t.Run("WellFormat", func(t *testing.T) {
 		for input, expect := range map[string]medium.Profile{
-			"f|a-1x1":           {Prefix: "f", Resolutions: []medium.Resolution{{"a", 1, 1}}},
-			"f|a-1x1:b-1x1":     {Prefix: "f", Resolutions: []medium.Resolution{{"a", 1, 1}, {"b", 1, 1}}},
-			"f|a-1x1:b-1x1|s":   {Prefix: "f", Resolutions: []medium.Resolution{{"a", 1, 1}, {"b", 1, 1}}, Sink: "s"},
-			"f@p|a-1x1":         {Prefix: "f", Suffix: "p", Resolutions: []medium.Resolution{{"a", 1, 1}}},
-			"f@p|a-1x1:b-1x1":   {Prefix: "f", Suffix: "p", Resolutions: []medium.Resolution{{"a", 1, 1}, {"b", 1, 1}}},
-			"f@p|a-1x1:b-1x1|s": {Prefix: "f", Suffix: "p", Resolutions: []medium.Resolution{{"a", 1, 1}, {"b", 1, 1}}, Sink: "s"},
+			"f|a-1x1":                 {Prefix: "f", Resolutions: []medium.Resolution{{Label: "a", Width: 1, Height: 1}}},
+			"f|a-1x1:b-1x1":           {Prefix: "f", Resolutions: []medium.Resolution{{Label: "a", Width: 1, Height: 1}, {Label: "b", Width: 1, Height: 1}}},
+			"f|a-1x1:b-1x1|s":         {Prefix: "f", Resolutions: []medium.Resolution{{Label: "a", Width: 1, Height: 1}, {Label: "b", Width: 1, Height: 1}}, Sink: "s"},
+			"f@p|a-1x1":               {Prefix: "f", Suffix: "p", Resolutions: []medium.Resolution{{Label: "a", Width: 1, Height: 1}}},
+			"f@p|a-1x1:b-1x1":         {Prefix: "f", Suffix: "p", Resolutions: []medium.Resolution{{Label: "a", Width: 1, Height: 1}, {Label: "b", Width: 1, Height: 1}}},
+			"f@p|a-1x1:b-1x1|s":       {Prefix: "f", Suffix: "p", Resolutions: []medium.Resolution{{Label: "a", Width: 1, Height: 1}, {Label: "b", Width: 1, Height: 1}}, Sink: "s"},
+			"f|a-1x1||atomic":         {Prefix: "f", Resolutions: []medium.Resolution{{Label: "a", Width: 1, Height: 1}}, Atomic: true},
+			"f|a-1x1|s|atomic":        {Prefix: "f", Resolutions: []medium.Resolution{{Label: "a", Width: 1, Height: 1}}, Sink: "s", Atomic: true},
+			"f|a-1x1|s|atomic,reemit": {Prefix: "f", Resolutions: []medium.Resolution{{Label: "a", Width: 1, Height: 1}}, Sink: "s", Atomic: true, Reemit: true},
+			"f|a-1x1||approval":       {Prefix: "f", Resolutions: []medium.Resolution{{Label: "a", Width: 1, Height: 1}}, RequireApproval: true},
+			"f|a-1x1||animated,frames=100,duration=10s": {Prefix: "f", Resolutions: []medium.Resolution{{Label: "a", Width: 1, Height: 1}}, Animated: true, MaxFrames: 100, MaxDuration: 10 * time.Second},
 		} {
 			val, err := medium.NewProfile(input)
 			it.Then(t).Should(
@@ -75,6 +175,16 @@ func TestProfile(t *testing.T) {
 			"f|p-128",
 			".f",
 			".f|p-128",
+			"f|a-1x1|s|unknown",
+			"f|a-1x1|s|output=",
+			"f|a-1x1|s|output={unknown}",
+			"f|a-1x1:b-2x2|s|output={prefix}/{sha256}.{ext}",
+			"f|a-1x1:b-1x1|s|output={prefix}/{sha256}/{w}.{ext}",
+			"f|a-1x1|s|frames=x",
+			"f|a-1x1|s|duration=10",
+			"f|a-1x1|s|poster=x",
+			"f|a-1x1|s|page=0",
+			"f|a-1x1|s|page=x",
 		} {
 			_, err := medium.NewProfile(input)
 			it.Then(t).ShouldNot(
@@ -83,4 +193,105 @@ func TestProfile(t *testing.T) {
 		}
 	})
 
+	t.Run("String", func(t *testing.T) {
+		for _, input := range []string{
+			"f|a-1x1",
+			"f@p|a-1x1:b|s",
+			"f|a-1x1||atomic",
+			"f@p|a-1x1|s|atomic",
+			"f|a-1x1||atomic,reemit",
+			"f|a-1x1||output={prefix}/{sha256[:16]}.{label}.{ext}",
+			"f|a-1x1|s|atomic,output={dir}/{label}/{name}.{ext}",
+			"f|a-1x1||reemit,approval",
+			"f|a-1x1||animated,frames=50,duration=5s",
+			"f|a-1x1||poster=1.5s",
+			"f|a-1x1||page=2",
+			"f|o:a-1x1:hd-1280x720@2500k",
+			"f|o:thumb.1x-240x240:thumb.2x-480x480:w.320w-320x0",
+			"f|o:qr-256x256~nearest:p-64x64~catmullrom",
+			"f|o~exact:s~sanitized:a-1x1",
+			"f|o~sanitized:a-1x1||icc",
+		} {
+			val, err := medium.NewProfile(input)
+			it.Then(t).Should(
+				it.Nil(err),
+				it.Equal(val.String(), input),
+			)
+		}
+	})
+}
+
+func TestProfileValidate(t *testing.T) {
+	profile := medium.On("f", "").Process(medium.ScaleTo("a", 1, 1), medium.ScaleTo("b", 2, 2))
+
+	it.Then(t).Should(
+		it.Nil(profile.Validate()),
+		it.Nil(profile.OutputTo("{prefix}/{sha256}.{label}.{ext}").Validate()),
+		it.Nil(profile.OutputTo("{prefix}/{sha256}/{w}.{ext}").Validate()),
+		it.Nil(medium.On("f", "").Process(medium.Replica("o")).OutputTo("{prefix}/{sha256}.{ext}").Validate()),
+	).ShouldNot(
+		it.Nil(profile.OutputTo("{prefix}/{sha256}.{ext}").Validate()),
+		it.Nil(profile.OutputTo("{prefix}/{unknown}.{ext}").Validate()),
+		it.Nil(profile.OutputTo("{prefix}/{label}|{ext}").Validate()),
+		it.Nil(profile.OutputTo("{prefix}/{label},{ext}").Validate()),
+	)
+
+	t.Run("Resolutions", func(t *testing.T) {
+		it.Then(t).Should(
+			it.Nil(medium.On("f", "").Process(medium.ScaleTo("a", 1, 1).Resample(medium.Nearest)).Validate()),
+			it.Nil(medium.On("f", "").Process(medium.SanitizedReplica("o"), medium.Rendition("hd", 1280, 720, 2500)).Validate()),
+			it.Nil(medium.On("f", "").Responsive(medium.WidthLadder("w", 320)).Validate()),
+		).ShouldNot(
+			it.Nil(medium.On("f", "").Process(medium.ScaleTo("a", 1, 1).Resample("bogus")).Validate()),
+			it.Nil(medium.On("f", "").Process(medium.Rendition("hd", 1280, 720, 2500).Resample(medium.Nearest)).Validate()),
+			it.Nil(medium.On("f", "").Process(medium.Replica("o").Resample(medium.Linear)).Validate()),
+		)
+	})
+
+	t.Run("Sidecars", func(t *testing.T) {
+		video := medium.On("f", "").Process(medium.Replica("o"), medium.Rendition("hd", 1280, 720, 2500))
+
+		it.Then(t).Should(
+			it.Nil(video.OutputTo("{dir}/{label}/{name}.{ext}").Validate()),
+			it.Nil(video.OutputTo("{dir}/{label}/{name}.{format}").Validate()),
+			it.Nil(medium.On("f", "").Process(medium.Replica("o")).OutputTo("{dir}/{w}/{name}.{ext}").Validate()),
+		).ShouldNot(
+			it.Nil(video.OutputTo("{dir}/{label}/{name}").Validate()),
+			it.Nil(medium.On("f", "").Process(medium.Replica("o")).OutputTo("{dir}/{w}/{name}").Validate()),
+			it.Nil(medium.On("f", "").Process(medium.ScaleTo("peaks", 100, 100)).Validate()),
+			it.Nil(medium.On("f", "").Process(medium.Replica("hls")).Validate()),
+		)
+	})
+}
+
+func TestOutputKey(t *testing.T) {
+	vars := medium.OutputVars{
+		Path:       "/f/a/b.jpg",
+		Hash:       "0123456789abcdef0123456789abcdef",
+		Resolution: medium.ScaleTo("small", 128, 96),
+		Format:     "jpeg",
+		Ext:        "jpg",
+	}
+
+	for template, expect := range map[string]string{
+		"":                                       "/f/a/b.small-128x96.jpg",
+		"{prefix}/{sha256[:16]}.{label}.{ext}":   "/f/0123456789abcdef.small.jpg",
+		"{prefix}/{sha256}/{label}.{ext}":        "/f/0123456789abcdef0123456789abcdef/small.jpg",
+		"{sha256[:64]}.{ext}":                    "/0123456789abcdef0123456789abcdef.jpg",
+		"{dir}/{label}/{name}.{ext}":             "/f/a/small/b.jpg",
+		"{name}/{w}w.{ext}":                      "/b/128w.jpg",
+		"{dir}/{name}-{width}x{height}.{format}": "/f/a/b-128x96.jpeg",
+		"{dir}/{hash[:4]}/{name}.{ext}":          "/f/a/0123/b.jpg",
+	} {
+		profile := medium.Profile{Prefix: "f", Output: template}
+		it.Then(t).Should(
+			it.Equal(profile.OutputKey(vars), expect),
+		)
+	}
+
+	// bitrate of rendition is not part of the key
+	vars.Resolution, vars.Format, vars.Ext = medium.Rendition("hd", 1280, 720, 2500), "mp4", "mp4"
+	it.Then(t).Should(
+		it.Equal(medium.Profile{Prefix: "f"}.OutputKey(vars), "/f/a/b.hd-1280x720.mp4"),
+	)
 }